@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	crawlerConfig "webcrawler/config/crawler"
 	crawler "webcrawler/internal/crawler"
@@ -23,26 +28,142 @@ func main() {
 	// fetch seed urls from config
 	crawlerConfig := crawlerConfig.Get()
 
-	crawlerSession := crawler.NewCrawlSession(crawlerConfig.ReadTimeoutSeconds)
+	// "explain <url>" prints which rules would accept or reject the url, rather than crawling
+	if len(os.Args) > 1 && os.Args[1] == "explain" {
+		explain(crawlerConfig.ReadTimeoutSeconds, os.Args[2:])
+		return
+	}
+
+	// "resume" continues the crawl saved in the checkpoint directory rather than starting afresh from the seeds
+	resume := len(os.Args) > 1 && os.Args[1] == "resume"
 
 	// check seed urls are not empty
-	if len(crawlerConfig.Seeds) == 0 {
+	if !resume && len(crawlerConfig.Seeds) == 0 {
 		logger.Error("no configured seeds, nowhere to crawl :(")
-		crawlerSession.DoneChan <- true
+		return
+	}
+
+	// an interrupt stops the crawl early, but still prints whatever has been crawled so far
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	crawlerSession := crawler.NewCrawlSessionWithContext(ctx, crawlerConfig.ReadTimeoutSeconds)
+
+	// large crawls keep their state on disk, so memory stays flat as they grow
+	if crawlerConfig.StoreDir != "" {
+		if e := crawlerSession.UseDiskStore(crawlerConfig.StoreDir); e != nil {
+			logger.Error(e)
+			return
+		}
+		defer crawlerSession.Close()
+	}
+
+	var pending []*crawler.Page
+	if resume {
+		if crawlerConfig.CheckpointDir == "" {
+			logger.Error("no configured checkpoint directory, nothing to resume :(")
+			return
+		}
+
+		checkpoint, e := crawler.LoadCheckpoint(crawlerConfig.CheckpointDir)
+		if e != nil {
+			logger.Error(e)
+			return
+		}
+
+		pending, e = crawlerSession.Restore(checkpoint)
+		if e != nil {
+			logger.Error(e)
+			return
+		}
+	}
+
+	// decide which urls are appropriate to crawl, and route filtered urls to host-specific channel
+	crawlerSession.Start()
+
+	// the crawl isn't done until every page has been sent, even if the first are rejected
+	crawlerSession.Hold()
+	if resume {
+		// send pages left unprocessed by the interrupted crawl to be filtered and crawled
+		for _, page := range pending {
+			if !crawlerSession.Submit(page) {
+				break
+			}
+		}
+	} else {
+		// send seed urls to be filtered and crawled
+		for _, url := range crawlerConfig.Seeds {
+			if !crawlerSession.SubmitSeed(crawler.NewPage(url, url, 0, nil)) {
+				break
+			}
+		}
+	}
+	crawlerSession.Release()
+
+	// a resumed crawl may have had nothing left to do
+	if crawlerSession.PendingURLs.GetCount() > 0 {
+		// a spent budget ends the crawl much as an interrupt does, by cancelling the session
+		select {
+		case <-crawlerSession.DoneChan:
+		case <-crawlerSession.Context.Done():
+			if budget := crawlerSession.Budget.SpentBy(); budget != "" {
+				logger.Warnf("crawl budget [%s] spent, printing partial site tree", budget)
+			} else {
+				logger.Warn("interrupted, printing partial site tree")
+			}
+		}
+	}
+
+	// no page of the tree may still be changing while it's saved and printed
+	crawlerSession.Stop()
+	crawlerSession.LogVisitedStats()
+	crawlerSession.LogRedirectReport()
+	crawlerSession.LogErrorSummary()
+	crawlerSession.LogRejectionReport()
+	crawlerSession.LogBudgetReport()
+
+	if crawlerConfig.CheckpointDir != "" {
+		if e := crawlerSession.SaveCheckpoint(crawlerConfig.CheckpointDir); e != nil {
+			logger.Error(e)
+		}
 	}
 
-	// decide which urls are appropriate to crawl
-	go crawlerSession.FilterURLs()
+	for _, seed := range crawlerSession.Seeds {
+		seed.PrintTree()
+	}
+}
+
+// explain evaluates a url against the config, as a link found at the given depth (0, a seed, by default),
+// and prints the decision of every rule - the first rule to reject the url is the one that decides. It
+// exits with status 1 if it's used wrongly or the url can't be evaluated
+func explain(readTimeoutSecs int, args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		logger.Error("usage: crawler explain <url> [depth]")
+		os.Exit(1)
+	}
 
-	// route filtered urls to host-specific channel
-	go crawlerSession.RouteAcceptedURLs()
+	depth := 0
+	if len(args) == 2 {
+		var e error
+		if depth, e = strconv.Atoi(args[1]); e != nil || depth < 0 {
+			logger.Errorf("depth must be a non-negative number, got [%s]", args[1])
+			os.Exit(1)
+		}
+	}
 
-	// send seed urls to be filtered and crawled
-	for _, url := range crawlerConfig.Seeds {
-		page := crawler.NewPage(url, url, 0, nil)
-		defer page.PrintTree()
-		crawlerSession.ToBeFiltered <- page
+	session := crawler.NewCrawlSession(readTimeoutSecs)
+	decisions, e := session.Explain(args[0], depth)
+	if e != nil {
+		logger.Error(e)
+		os.Exit(1)
 	}
 
-	<-crawlerSession.DoneChan
+	verdict := "crawled"
+	for _, decision := range decisions {
+		fmt.Println(decision)
+		if !decision.Accepted && verdict == "crawled" {
+			verdict = fmt.Sprintf("rejected by rule [%s]", decision.Rule)
+		}
+	}
+	fmt.Printf("\n[%s] would be %s\n", decisions[0].URL, verdict)
 }
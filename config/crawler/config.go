@@ -1,8 +1,14 @@
 package crawler
 
 import (
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strings"
 	logger "webcrawler/logger"
 
 	"gopkg.in/yaml.v3"
@@ -13,23 +19,214 @@ const configFile = "../../config/crawler/config.yml"
 var config *Config
 
 var defaultConfig = Config{
-	ReadTimeoutSeconds: 3,
-	Seeds:              []string{"https://www.wisdomforgoldfish.com"},
-	DomainHitDelayMS:   2000,
-	MaxDepth:           5,
-	IgnoreIfContains:   []string{".png", ".jpg", "javascript"},
-	PrintIndent:        20,
+	ReadTimeoutSeconds:     3,
+	Seeds:                  []string{"https://www.wisdomforgoldfish.com"},
+	DomainHitDelayMS:       2000,
+	MaxDepth:               5,
+	IgnoreIfContains:       []string{".png", ".jpg", "javascript"},
+	PrintIndent:            20,
+	UserAgent:              "webcrawler",
+	MaxRetryAfterSecs:      300,
+	UseSitemaps:            true,
+	MaxConcurrency:         10,
+	Frontier:               "bfs",
+	TrailingSlash:          "keep",
+	QueryPolicy:            QueryPolicy{Mode: "deny", Params: []string{"utm_*", "sessionid", "jsessionid", "phpsessid", "sid", "fbclid", "gclid"}},
+	FollowLinks:            []string{"a", "area", "iframe", "frame", "meta_refresh", "link_next", "link_prev"},
+	RecordLinks:            []string{"link_alternate", "canonical"},
+	HonorNofollow:          true,
+	MaxRedirects:           10,
+	RedirectReportHops:     3,
+	CheckpointIntervalSecs: 60,
+	VisitedStore:           "exact",
+	BloomFalsePositiveRate: 0.001,
+	SeedScope:              SeedScope{Mode: "any"},
+	MaxBodyBytes:           10 << 20,
+	OversizeBody:           "truncate",
+	Retry: RetryPolicy{
+		MaxAttempts:   3,
+		BaseDelayMS:   1000,
+		MaxDelayMS:    30000,
+		Jitter:        0.5,
+		Statuses:      []int{408, 429, 500, 502, 503, 504},
+		NetworkErrors: true},
 }
 
 // Config - configuration relating to the Crawler app
 type Config struct {
 	ReadTimeoutSeconds int      `yaml:"read_timeout_secs"`
 	Seeds              []string `yaml:"seeds"`
-	BlacklistedURLs    []string `yaml:"blacklisted_urls"`
 	DomainHitDelayMS   int      `yaml:"domain_delay_ms"`
 	MaxDepth           int      `yaml:"max_depth"`
 	IgnoreIfContains   []string `yaml:"ignore_if_contains"`
 	PrintIndent        int      `yaml:"print_indent"`
+	UserAgent          string   `yaml:"user_agent"`
+	IgnoreRobots       bool     `yaml:"ignore_robots"`
+	MaxRetryAfterSecs  int      `yaml:"max_retry_after_secs"`
+	UseSitemaps        bool     `yaml:"use_sitemaps"`
+	MaxConcurrency     int      `yaml:"max_concurrency"`
+
+	// the order each host's pages are crawled in - "bfs" (breadth first), "dfs" (depth first) or "best_first"
+	Frontier string `yaml:"frontier"`
+
+	// whether urls keep their trailing slash as found, or have one added or removed, so e.g. "/about" and "/about/" are the same page
+	TrailingSlash string `yaml:"trailing_slash"`
+
+	// which kinds of link are followed, and which only recorded in the site tree - any others are ignored. Kinds are
+	// a, area, iframe, frame, meta_refresh, link_next, link_prev, link_alternate (<link rel=...>) and canonical
+	FollowLinks []string `yaml:"follow_links"`
+	RecordLinks []string `yaml:"record_links"`
+
+	// whether links marked rel="nofollow", or found in pages whose meta robots tags or X-Robots-Tag
+	// headers say nofollow, are left unfollowed - they're recorded in the site tree either way
+	HonorNofollow bool `yaml:"honor_nofollow"`
+
+	// the most of a page's body that's read, 0 meaning no limit, and whether a larger body is cut short at that
+	// size and its page crawled as far as it got ("truncate"), or its fetch failed and the page left uncrawled ("abort")
+	MaxBodyBytes int64  `yaml:"max_body_bytes"`
+	OversizeBody string `yaml:"oversize_body"`
+
+	// limits on how much a crawl does, 0 meaning no limit - the crawl ends cleanly once it's fetched max_pages pages, downloaded
+	// max_bytes bytes, or run for max_duration_secs, and stops fetching pages of any host it's fetched max_pages_per_host from
+	MaxPages        int   `yaml:"max_pages"`
+	MaxBytes        int64 `yaml:"max_bytes"`
+	MaxDurationSecs int   `yaml:"max_duration_secs"`
+	MaxPagesPerHost int   `yaml:"max_pages_per_host"`
+
+	// how far from its seed a crawl reaches, by default and for particular seeds, and whether links out of
+	// a seed's scope are recorded in its site tree, as leaves that are never fetched, or left out
+	SeedScope        SeedScope            `yaml:"seed_scope"`
+	SeedScopes       map[string]SeedScope `yaml:"seed_scopes"`
+	RecordOutOfScope bool                 `yaml:"record_out_of_scope"`
+
+	// pages never crawled - see BlacklistEntry for the forms entries take. They're parsed by Validate
+	BlacklistedURLs []string `yaml:"blacklisted_urls"`
+	blacklist       []BlacklistEntry
+
+	// which urls are in scope, checked in order, the first rule to match a url including or excluding it -
+	// urls matched by none are included. See ScopeRule
+	ScopeRules []ScopeRule `yaml:"scope_rules"`
+
+	// whether links with no text, image alt text, aria-label or title to describe them are still added to the site tree
+	KeepTextlessLinks bool `yaml:"keep_textless_links"`
+
+	// which query params urls keep, by default and for particular domains (and their subdomains)
+	QueryPolicy         QueryPolicy            `yaml:"query_policy"`
+	DomainQueryPolicies map[string]QueryPolicy `yaml:"domain_query_policies"`
+
+	// how many redirects a fetch follows before giving up, and how many hops a redirect chain
+	// needs to be listed in the report of long chains logged at the end of a crawl
+	MaxRedirects       int `yaml:"max_redirects"`
+	RedirectReportHops int `yaml:"redirect_report_hops"`
+
+	// how failed fetches are retried, by default and for particular domains (and their subdomains)
+	Retry               RetryPolicy            `yaml:"retry"`
+	DomainRetryPolicies map[string]RetryPolicy `yaml:"domain_retry_policies"`
+
+	// where crawl checkpoints are saved to and resumed from, checkpointing is off when empty
+	CheckpointDir          string `yaml:"checkpoint_dir"`
+	CheckpointIntervalSecs int    `yaml:"checkpoint_interval_secs"`
+
+	// where frontiers, visited sets and page bodies are kept on disk, they're kept in memory when empty
+	StoreDir string `yaml:"store_dir"`
+
+	// how visited urls and seen content are recorded in memory - "exact" or "bloom", a far lighter filter
+	// that may wrongly skip a page now and then, at most at the given false positive rate
+	VisitedStore           string  `yaml:"visited_store"`
+	BloomFalsePositiveRate float64 `yaml:"bloom_false_positive_rate"`
+}
+
+// QueryPolicy decides which of a url's query params are kept - "keep" all, "drop" all, keep only
+// those in an "allow" list, or keep all but those in a "deny" list. Params may be globs, e.g. "utm_*"
+type QueryPolicy struct {
+	Mode   string   `yaml:"mode"`
+	Params []string `yaml:"params"`
+}
+
+// RetryPolicy decides which failed fetches are tried again - those answered with one of the given status codes,
+// or failing with a network error such as a timeout if set - and how. A page is fetched at most max_attempts
+// times, waiting base_delay_ms before its first retry, doubling each retry up to max_delay_ms, less a random
+// share of up to jitter (between 0 and 1) of each delay, so retries of many pages don't all land at once
+type RetryPolicy struct {
+	MaxAttempts   int     `yaml:"max_attempts"`
+	BaseDelayMS   int     `yaml:"base_delay_ms"`
+	MaxDelayMS    int     `yaml:"max_delay_ms"`
+	Jitter        float64 `yaml:"jitter"`
+	Statuses      []int   `yaml:"statuses"`
+	NetworkErrors bool    `yaml:"network_errors"`
+}
+
+// SeedScope decides which urls a seed's crawl reaches - "host", those of the seed's own host only, "domain", those of
+// any host of its registrable domain by the public suffix list, e.g. www.example.co.uk and shop.example.co.uk, "hosts",
+// those of its own host and the listed hosts and their subdomains, or "any", wherever its links lead
+type SeedScope struct {
+	Mode  string   `yaml:"mode"`
+	Hosts []string `yaml:"hosts"`
+}
+
+// BlacklistEntry is a parsed blacklisted_urls entry, blacklisting pages of a host and its subdomains, e.g. "example.com"
+// or "https://example.com", only the subdomains of a domain, e.g. "*.example.com", or the ip hosts of a CIDR range, e.g.
+// "10.0.0.0/8". Host entries may be scoped to a path prefix, e.g. "example.com/private", and ports are ignored
+type BlacklistEntry struct {
+	Entry    string
+	Host     string
+	Wildcard bool
+	Network  *net.IPNet
+	Path     string
+}
+
+// ParseBlacklistEntry parses a blacklisted_urls entry
+func ParseBlacklistEntry(entry string) (parsed BlacklistEntry, e error) {
+	parsed.Entry = entry
+	entry = strings.TrimSpace(entry)
+
+	if !strings.Contains(entry, "://") {
+		_, network, e := net.ParseCIDR(entry)
+		if e == nil {
+			parsed.Network = network
+			return parsed, nil
+		}
+		// an ip with a prefix length is meant as a CIDR range, not an ip host with a path
+		if ip, bits, found := strings.Cut(entry, "/"); found && net.ParseIP(ip) != nil && bits != "" && strings.Trim(bits, "0123456789") == "" {
+			return parsed, fmt.Errorf("could not parse blacklist entry [%s] - %s", parsed.Entry, e)
+		}
+		if strings.HasPrefix(entry, "*.") {
+			parsed.Wildcard = true
+			entry = strings.TrimPrefix(entry, "*.")
+		}
+		entry = "//" + entry
+	}
+
+	link, e := url.Parse(entry)
+	if e != nil {
+		return parsed, fmt.Errorf("could not parse blacklist entry [%s] - %s", parsed.Entry, e)
+	}
+	parsed.Host = strings.ToLower(strings.Trim(link.Hostname(), "[]"))
+	if parsed.Host == "" || strings.Contains(parsed.Host, "*") {
+		return parsed, fmt.Errorf("could not parse blacklist entry [%s] - no valid host", parsed.Entry)
+	}
+	if link.Path != "/" {
+		parsed.Path = link.Path
+	}
+	return parsed, nil
+}
+
+// Blacklist returns the blacklisted_urls entries as parsed by Validate, which must be called again if they're changed
+func (c *Config) Blacklist() []BlacklistEntry {
+	return c.blacklist
+}
+
+// ScopeRule includes or excludes the urls it matches - by "regex", matched against the whole url, or by "glob" or path
+// "prefix", matched against its path. In globs, * and ? match within a path segment and ** across segments, e.g.
+// "/docs/**.js". A rule with hosts only applies to urls of those hosts and their subdomains
+type ScopeRule struct {
+	Action  string   `yaml:"action"`
+	Match   string   `yaml:"match"`
+	Pattern string   `yaml:"pattern"`
+	Hosts   []string `yaml:"hosts"`
+
+	// the pattern compiled to a regular expression, whichever kind it is
+	compiled *regexp.Regexp
 }
 
 // Get returns the config from file, or, if unavailable, default config
@@ -55,13 +252,15 @@ func NewFromFile() (c *Config, e error) {
 		return
 	}
 
-	c = &defaultConfig
+	// the file is read over a copy of the defaults, so a file that fails to load or validate leaves them untouched
+	conf := defaultConfig
+	c = &conf
 	if e = yaml.Unmarshal(yamlBytes, c); e != nil {
 		logger.Error(e)
 		return nil, e
 	}
 
-	e = c.validate()
+	e = c.Validate()
 	if e != nil {
 		logger.Error(e)
 		return nil, e
@@ -69,11 +268,187 @@ func NewFromFile() (c *Config, e error) {
 	return
 }
 
-// NewDefault creates and returns the default instance of Config
+// NewDefault creates and returns a new, validated instance of the default Config
 func NewDefault() *Config {
-	return &defaultConfig
+	conf := defaultConfig
+	if e := conf.Validate(); e != nil {
+		logger.Fatal(fmt.Sprintf("default config invalid - %s", e))
+	}
+	return &conf
 }
 
-func (c *Config) validate() (e error) {
+// Validate checks the config is valid, parsing the blacklist and compiling the scope rules on the way - the config
+// is validated when it's loaded, so it only needs calling again once those, or anything else, are changed, e.g. in tests
+func (c *Config) Validate() (e error) {
+	if c.MaxConcurrency < 1 {
+		return fmt.Errorf("invalid config - max_concurrency must be at least 1, got [%d]", c.MaxConcurrency)
+	}
+	if c.MaxRedirects < 0 {
+		return fmt.Errorf("invalid config - max_redirects can't be negative, got [%d]", c.MaxRedirects)
+	}
+	if c.MaxPages < 0 || c.MaxBytes < 0 || c.MaxDurationSecs < 0 || c.MaxPagesPerHost < 0 {
+		return fmt.Errorf("invalid config - max_pages, max_bytes, max_duration_secs and max_pages_per_host can't be negative")
+	}
+	if c.MaxBodyBytes < 0 {
+		return fmt.Errorf("invalid config - max_body_bytes can't be negative, got [%d]", c.MaxBodyBytes)
+	}
+	switch c.OversizeBody {
+	case "truncate", "abort":
+	default:
+		return fmt.Errorf("invalid config - oversize_body must be one of [truncate, abort], got [%s]", c.OversizeBody)
+	}
+	if c.RedirectReportHops < 0 {
+		return fmt.Errorf("invalid config - redirect_report_hops can't be negative, got [%d]", c.RedirectReportHops)
+	}
+	switch c.Frontier {
+	case "bfs", "dfs", "best_first":
+	default:
+		return fmt.Errorf("invalid config - frontier must be one of [bfs, dfs, best_first], got [%s]", c.Frontier)
+	}
+	switch c.TrailingSlash {
+	case "keep", "add", "remove":
+	default:
+		return fmt.Errorf("invalid config - trailing_slash must be one of [keep, add, remove], got [%s]", c.TrailingSlash)
+	}
+	for _, kind := range append(append([]string{}, c.FollowLinks...), c.RecordLinks...) {
+		switch kind {
+		case "a", "area", "iframe", "frame", "meta_refresh", "link_next", "link_prev", "link_alternate", "canonical":
+		default:
+			return fmt.Errorf("invalid config - unknown link kind [%s]", kind)
+		}
+	}
+	policies := map[string]QueryPolicy{"default": c.QueryPolicy}
+	for domain, policy := range c.DomainQueryPolicies {
+		policies[domain] = policy
+	}
+	for domain, policy := range policies {
+		if e = policy.validate(); e != nil {
+			return fmt.Errorf("invalid config - query policy for [%s] - %s", domain, e)
+		}
+	}
+	c.blacklist = make([]BlacklistEntry, 0, len(c.BlacklistedURLs))
+	for _, entry := range c.BlacklistedURLs {
+		parsed, e := ParseBlacklistEntry(entry)
+		if e != nil {
+			return fmt.Errorf("invalid config - %s", e)
+		}
+		c.blacklist = append(c.blacklist, parsed)
+	}
+	seedScopes := map[string]SeedScope{"default": c.SeedScope}
+	for seed, scope := range c.SeedScopes {
+		seedScopes[seed] = scope
+	}
+	for seed, scope := range seedScopes {
+		switch scope.Mode {
+		case "host", "domain", "hosts", "any":
+		default:
+			return fmt.Errorf("invalid config - seed scope for [%s] - mode must be one of [host, domain, hosts, any], got [%s]", seed, scope.Mode)
+		}
+	}
+	for i := range c.ScopeRules {
+		if e = c.ScopeRules[i].compile(); e != nil {
+			return fmt.Errorf("invalid config - scope rule [%d] - %s", i, e)
+		}
+	}
+	retryPolicies := map[string]RetryPolicy{"default": c.Retry}
+	for domain, policy := range c.DomainRetryPolicies {
+		retryPolicies[domain] = policy
+	}
+	for domain, policy := range retryPolicies {
+		if e = policy.validate(); e != nil {
+			return fmt.Errorf("invalid config - retry policy for [%s] - %s", domain, e)
+		}
+	}
+	switch c.VisitedStore {
+	case "exact":
+	case "bloom":
+		if c.BloomFalsePositiveRate <= 0 || c.BloomFalsePositiveRate >= 1 {
+			return fmt.Errorf("invalid config - bloom_false_positive_rate must be between 0 and 1, got [%g]", c.BloomFalsePositiveRate)
+		}
+	default:
+		return fmt.Errorf("invalid config - visited_store must be one of [exact, bloom], got [%s]", c.VisitedStore)
+	}
+	if c.CheckpointDir != "" && c.CheckpointIntervalSecs < 1 {
+		return fmt.Errorf("invalid config - checkpoint_interval_secs must be at least 1, got [%d]", c.CheckpointIntervalSecs)
+	}
 	return nil
 }
+
+func (p QueryPolicy) validate() error {
+	switch p.Mode {
+	case "keep", "drop", "allow", "deny":
+	default:
+		return fmt.Errorf("mode must be one of [keep, drop, allow, deny], got [%s]", p.Mode)
+	}
+	for _, param := range p.Params {
+		if _, e := path.Match(param, ""); e != nil {
+			return fmt.Errorf("bad param pattern [%s] - %s", param, e)
+		}
+	}
+	return nil
+}
+
+func (p RetryPolicy) validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("max_attempts must be at least 1, got [%d]", p.MaxAttempts)
+	}
+	if p.BaseDelayMS < 0 || p.MaxDelayMS < p.BaseDelayMS {
+		return fmt.Errorf("delays must satisfy 0 <= base_delay_ms <= max_delay_ms, got [%d] and [%d]", p.BaseDelayMS, p.MaxDelayMS)
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1, got [%g]", p.Jitter)
+	}
+	return nil
+}
+
+// Regexp returns the rule's pattern compiled to a regular expression by Validate, which must be called again if rules are changed
+func (r *ScopeRule) Regexp() *regexp.Regexp {
+	return r.compiled
+}
+
+func (r *ScopeRule) compile() (e error) {
+	switch r.Action {
+	case "include", "exclude":
+	default:
+		return fmt.Errorf("action must be one of [include, exclude], got [%s]", r.Action)
+	}
+	if r.Pattern == "" {
+		return fmt.Errorf("pattern can't be empty")
+	}
+	r.compiled, e = r.regexp()
+	return
+}
+
+// regexp compiles the rule's pattern, of whichever kind, to a regular expression
+func (r *ScopeRule) regexp() (*regexp.Regexp, error) {
+	switch r.Match {
+	case "regex":
+		return regexp.Compile(r.Pattern)
+	case "glob":
+		return regexp.Compile(globRegexp(r.Pattern))
+	case "prefix":
+		return regexp.Compile("^" + regexp.QuoteMeta(r.Pattern))
+	}
+	return nil, fmt.Errorf("match must be one of [regex, glob, prefix], got [%s]", r.Match)
+}
+
+// globRegexp converts a glob to an anchored regular expression - ** matches anything, * and ? anything within a path segment
+func globRegexp(glob string) string {
+	var builder strings.Builder
+	builder.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			builder.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			builder.WriteString("[^/]*")
+		case glob[i] == '?':
+			builder.WriteString("[^/]")
+		default:
+			builder.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	builder.WriteString("$")
+	return builder.String()
+}
@@ -4,4 +4,12 @@ go 1.21.6
 
 require gopkg.in/yaml.v3 v3.0.1
 
-require golang.org/x/net v0.28.0
+require (
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/net v0.28.0
+)
+
+require (
+	golang.org/x/sys v0.23.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+)
@@ -0,0 +1,140 @@
+package crawler
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	crawlerConfig "webcrawler/config/crawler"
+)
+
+// what's done with a body larger than the max body size - see crawlerConfig.Config.OversizeBody
+const (
+	OversizeTruncate = "truncate"
+	OversizeAbort    = "abort"
+)
+
+// errBodyLimit stops a body being read any further once it's found to be larger than the max body size, when that aborts the fetch
+var errBodyLimit = errors.New("body exceeds max body size")
+
+// bodyReader streams a page's body to whatever reads it, e.g. the link extractor, hashing it and writing it straight
+// to where it's kept on the way, and reading no more than limit bytes of it, if there's a limit
+type bodyReader struct {
+	source io.ReadCloser
+	limit  int64
+	abort  bool
+
+	kept   io.Writer
+	hasher hash.Hash
+	size   int64
+
+	// whether the body was larger than the limit, and any error reading it other than reaching its end
+	truncated bool
+	e         error
+}
+
+// newBodyReader creates a bodyReader for the given body, bounded by the configured max body size, writing what's read to kept
+func newBodyReader(source io.ReadCloser, kept io.Writer) *bodyReader {
+	config := crawlerConfig.Get()
+	return &bodyReader{
+		source: source,
+		limit:  config.MaxBodyBytes,
+		abort:  config.OversizeBody == OversizeAbort,
+		kept:   kept,
+		hasher: md5.New()}
+}
+
+// Read reads the body up to its limit, at which it ends, or fails if oversized bodies abort the fetch
+func (r *bodyReader) Read(p []byte) (n int, e error) {
+	if r.limit > 0 && r.size >= r.limit {
+		return 0, r.atLimit()
+	}
+	if r.limit > 0 && int64(len(p)) > r.limit-r.size {
+		p = p[:r.limit-r.size]
+	}
+
+	n, e = r.source.Read(p)
+	r.size += int64(n)
+	r.kept.Write(p[:n])
+	r.hasher.Write(p[:n])
+	if e != nil && e != io.EOF {
+		r.e = e
+	}
+	return
+}
+
+// atLimit checks whether there's more to the body once its limit is reached, by reading one more byte
+func (r *bodyReader) atLimit() error {
+	if !r.truncated {
+		var next [1]byte
+		if n, _ := io.ReadFull(r.source, next[:]); n == 0 {
+			return io.EOF
+		}
+		r.truncated = true
+	}
+	if r.abort {
+		return errBodyLimit
+	}
+	return io.EOF
+}
+
+// Close closes the body being read
+func (r *bodyReader) Close() error {
+	return r.source.Close()
+}
+
+// drain reads whatever of the body, up to its limit, hasn't been read yet - a reader may stop short, e.g. at a parse error
+func (r *bodyReader) drain() {
+	if r.e == nil {
+		io.Copy(io.Discard, r)
+	}
+}
+
+// err returns why the body couldn't be read in full - a read error, or it being too large when that aborts the fetch
+func (r *bodyReader) err(url string) error {
+	if r.e != nil {
+		return &CrawlError{Kind: fetchErrorKind(r.e), URL: url, Err: fmt.Errorf("error reading page [%s] - %w", url, r.e)}
+	}
+	if r.truncated && r.abort {
+		return &CrawlError{Kind: ErrTooLarge, URL: url, Err: fmt.Errorf("body of page [%s] larger than max body size of [%d] bytes", url, r.limit)}
+	}
+	return nil
+}
+
+// Hash returns the hash of the body read, the same as util.Hash would give for it
+func (r *bodyReader) Hash() string {
+	return string(r.hasher.Sum(nil))
+}
+
+// keptBody takes a page's body as it's read, in the form the session keeps it in, until it's known whether the page is kept
+type keptBody interface {
+	io.Writer
+	// keep saves the body written as the given page's
+	keep(page *Page) error
+	// discard drops whatever of the body has been written, its page not being kept
+	discard()
+}
+
+// memoryBody is kept as the page's RawContent when the session has no disk store, without being copied again
+type memoryBody struct {
+	strings.Builder
+}
+
+func (b *memoryBody) keep(page *Page) error {
+	page.RawContent = b.String()
+	return nil
+}
+
+func (b *memoryBody) discard() {
+	b.Reset()
+}
+
+// newKeptBody creates a keptBody for the given page's body, written straight to the disk store if the session has one
+func (c *CrawlSession) newKeptBody(page *Page) keptBody {
+	if c.Store != nil {
+		return c.Store.bodyWriter(page.URLHash)
+	}
+	return &memoryBody{}
+}
@@ -0,0 +1,183 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	config "webcrawler/config/crawler"
+	"webcrawler/internal/util"
+)
+
+func TestBodyReader(t *testing.T) {
+	tests := []struct {
+		name              string
+		body              io.Reader
+		limit             int64
+		abort             string
+		expectedContent   string
+		expectedTruncated bool
+		expectedErrorKind string
+	}{
+		{name: "success_unlimited", body: strings.NewReader("0123456789"), expectedContent: "0123456789"},
+		{name: "success_under_limit", body: strings.NewReader("0123456789"), limit: 20, expectedContent: "0123456789"},
+		{name: "success_at_limit", body: strings.NewReader("0123456789"), limit: 10, expectedContent: "0123456789"},
+		{name: "success_truncated", body: strings.NewReader("0123456789"), limit: 4, expectedContent: "0123", expectedTruncated: true},
+		{name: "success_truncated_small_reads", body: iotest.OneByteReader(strings.NewReader("0123456789")), limit: 4, expectedContent: "0123", expectedTruncated: true},
+		{name: "success_aborted", body: strings.NewReader("0123456789"), limit: 4, abort: OversizeAbort, expectedContent: "0123", expectedTruncated: true, expectedErrorKind: "too_large"},
+		{name: "fail_read", body: io.MultiReader(strings.NewReader("01"), iotest.ErrReader(io.ErrUnexpectedEOF)), expectedContent: "01", expectedErrorKind: "network"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withConfig(t, func(conf *config.Config) {
+				conf.MaxBodyBytes, conf.OversizeBody = test.limit, OversizeTruncate
+				if test.abort != "" {
+					conf.OversizeBody = test.abort
+				}
+			})
+
+			kept := &memoryBody{}
+			body := newBodyReader(io.NopCloser(test.body), kept)
+			read, _ := io.ReadAll(body)
+
+			if string(read) != test.expectedContent || kept.String() != test.expectedContent {
+				t.Errorf("content mismatch.\n- received: %s (kept %s)\n- expected: %s", read, kept.String(), test.expectedContent)
+			}
+			if body.Hash() != util.Hash(test.expectedContent) {
+				t.Errorf("hash mismatch for content [%s]", test.expectedContent)
+			}
+			if body.truncated != test.expectedTruncated {
+				t.Errorf("truncated mismatch.\n- received: %t\n- expected: %t", body.truncated, test.expectedTruncated)
+			}
+
+			e := body.err("https://www.example.com")
+			if test.expectedErrorKind == "" && e != nil || test.expectedErrorKind != "" && ErrorKind(e) != test.expectedErrorKind {
+				t.Errorf("error mismatch.\n- received: %v\n- expected kind: %s", e, test.expectedErrorKind)
+			}
+		})
+	}
+}
+
+// endlessBody is a body that never ends, of the same byte over and over
+type endlessBody struct{}
+
+func (endlessBody) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	return len(p), nil
+}
+
+func TestBodyReaderToDiskStore(t *testing.T) {
+	// several chunks' worth, and not a whole number of them
+	limit := int64(4*bodyChunkSize + 10)
+	withConfig(t, func(conf *config.Config) {
+		conf.MaxBodyBytes, conf.OversizeBody = limit, OversizeTruncate
+	})
+
+	store, e := OpenDiskStore(t.TempDir())
+	if e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+	defer store.Close()
+
+	urlHash := util.Hash("https://www.example.com")
+	kept := store.bodyWriter(urlHash)
+	body := newBodyReader(io.NopCloser(endlessBody{}), kept)
+
+	// the body is written to the store as it's read, no more than a chunk of it ever held by the writer
+	for {
+		if _, e := body.Read(make([]byte, 3000)); e != nil {
+			break
+		}
+		if held := cap(kept.chunk); held > bodyChunkSize {
+			t.Fatalf("body writer held [%d] bytes, more than a chunk", held)
+		}
+	}
+	if !body.truncated || body.size != limit {
+		t.Errorf("truncation mismatch - truncated [%t], size [%d]", body.truncated, body.size)
+	}
+	if e := kept.keep(nil); e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+
+	saved, e := store.GetBody(urlHash)
+	if e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+	if string(saved) != strings.Repeat("a", int(limit)) {
+		t.Errorf("saved body mismatch.\n- received: %d bytes\n- expected: %d bytes", len(saved), limit)
+	}
+
+	// a body that isn't kept leaves nothing behind
+	kept.discard()
+	if saved, _ := store.GetBody(urlHash); saved != nil {
+		t.Errorf("discarded body still saved - %d bytes", len(saved))
+	}
+}
+
+func TestCrawlMaxBodySize(t *testing.T) {
+	// the seed's first link is well within the max body size, its second well past it
+	page := fmt.Sprintf(`<a href="/near">near</a>%s<a href="/far">far</a>`, strings.Repeat(" ", 2000))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Query().Has("chunked") {
+			// a flushed response is sent chunked, with no declared length
+			fmt.Fprint(w, page[:10])
+			w.(http.Flusher).Flush()
+			fmt.Fprint(w, page[10:])
+			return
+		}
+		fmt.Fprint(w, page)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name              string
+		url               string
+		oversize          string
+		expectedChildren  []string
+		expectedSize      int64
+		expectedErrorKind string
+	}{
+		{name: "success_truncated", url: server.URL, oversize: OversizeTruncate, expectedChildren: []string{"/near"}, expectedSize: 1000},
+		{name: "success_aborted_declared_length", url: server.URL, oversize: OversizeAbort, expectedSize: int64(len(page)), expectedErrorKind: "too_large"},
+		{name: "success_aborted_streamed", url: server.URL + "/?chunked=true", oversize: OversizeAbort, expectedSize: 1000, expectedErrorKind: "too_large"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, seed := crawlFixture(t, test.url, fixtureConfig(func(conf *config.Config) {
+				conf.MaxDepth = 1
+				conf.MaxBodyBytes = 1000
+				conf.OversizeBody = test.oversize
+			}))
+
+			var children []string
+			for _, child := range seed.Children {
+				children = append(children, strings.TrimPrefix(child.URL, server.URL))
+			}
+			if fmt.Sprint(children) != fmt.Sprint(test.expectedChildren) {
+				t.Errorf("children mismatch.\n- received: %v\n- expected: %v", children, test.expectedChildren)
+			}
+			if seed.Fetch == nil || !seed.Fetch.Truncated || seed.Fetch.Size != test.expectedSize {
+				t.Fatalf("fetch mismatch - %+v", seed.Fetch)
+			}
+			if test.expectedErrorKind == "" {
+				if seed.Error != nil || len(seed.RawContent) != 1000 {
+					t.Errorf("truncated page mismatch - error [%v], content length [%d]", seed.Error, len(seed.RawContent))
+				}
+				return
+			}
+			if ErrorKind(seed.Error) != test.expectedErrorKind || !errors.Is(seed.Error, ErrTooLarge) {
+				t.Errorf("error mismatch.\n- received: %v\n- expected kind: %s", seed.Error, test.expectedErrorKind)
+			}
+		})
+	}
+}
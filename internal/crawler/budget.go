@@ -0,0 +1,219 @@
+package crawler
+
+import (
+	"sort"
+	"sync"
+	"time"
+	crawlerConfig "webcrawler/config/crawler"
+	logger "webcrawler/logger"
+)
+
+// the budgets a crawl can spend - all but the per host budget end the crawl once spent
+const (
+	BudgetPages        = "max_pages"
+	BudgetBytes        = "max_bytes"
+	BudgetDuration     = "max_duration_secs"
+	BudgetPagesPerHost = "max_pages_per_host"
+)
+
+// Budget tracks what a crawl has spent against its configured limits - pages fetched, in all and from
+// each host, bytes downloaded and time taken - and which budget, if any, ended it
+type Budget struct {
+	mutex     sync.Mutex
+	started   time.Time
+	pages     int
+	bytes     int64
+	hostPages map[string]int
+	spentBy   string
+
+	// the time taken by the runs of the crawl before this one, if it was resumed from a checkpoint
+	elapsedBefore time.Duration
+}
+
+// BudgetRecord is what a crawl had spent of its budgets when a checkpoint was taken, so a resumed crawl carries on from there
+type BudgetRecord struct {
+	Pages     int            `json:"pages"`
+	Bytes     int64          `json:"bytes"`
+	HostPages map[string]int `json:"host_pages,omitempty"`
+	ElapsedMS int64          `json:"elapsed_ms"`
+}
+
+// NewBudget creates and returns a pointer to a new, unspent Budget
+func NewBudget() *Budget {
+	return &Budget{started: time.Now(), hostPages: map[string]int{}}
+}
+
+// begin starts the clock on the crawl's duration
+func (b *Budget) begin() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.started = time.Now()
+}
+
+// reservePage takes a page from the budget before a page of the given host is fetched, returning the budget that's
+// spent if the page can't be fetched - one of the crawl's, or the host's. The crawl's budgets are only found spent
+// here, when there's another page to fetch, so a crawl that finishes on its last allowed page isn't cut short
+func (b *Budget) reservePage(host string) (spent string) {
+	config := crawlerConfig.Get()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if config.MaxPages > 0 && b.pages >= config.MaxPages {
+		return BudgetPages
+	}
+	if config.MaxBytes > 0 && b.bytes >= config.MaxBytes {
+		return BudgetBytes
+	}
+	if config.MaxPagesPerHost > 0 && b.hostPages[host] >= config.MaxPagesPerHost {
+		return BudgetPagesPerHost
+	}
+	b.pages++
+	b.hostPages[host]++
+	return ""
+}
+
+// hostSpent checks whether the given host has fetched all the pages it's allowed
+func (b *Budget) hostSpent(host string) bool {
+	limit := crawlerConfig.Get().MaxPagesPerHost
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return limit > 0 && b.hostPages[host] >= limit
+}
+
+// record captures what's been spent of the budget so far
+func (b *Budget) record() *BudgetRecord {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	hostPages := make(map[string]int, len(b.hostPages))
+	for host, pages := range b.hostPages {
+		hostPages[host] = pages
+	}
+	return &BudgetRecord{Pages: b.pages, Bytes: b.bytes, HostPages: hostPages,
+		ElapsedMS: (b.elapsedBefore + time.Since(b.started)).Milliseconds()}
+}
+
+// restore takes what a checkpointed crawl had spent back into the budget, before the resumed crawl begins
+func (b *Budget) restore(record *BudgetRecord) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.pages, b.bytes = record.Pages, record.Bytes
+	b.hostPages = map[string]int{}
+	for host, pages := range record.HostPages {
+		b.hostPages[host] = pages
+	}
+	b.elapsedBefore = time.Duration(record.ElapsedMS) * time.Millisecond
+}
+
+// spendBytes takes downloaded bytes from the budget
+func (b *Budget) spendBytes(bytes int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.bytes += bytes
+}
+
+// spend records that the given budget ended the crawl, returning false if another already had
+func (b *Budget) spend(budget string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.spentBy != "" {
+		return false
+	}
+	b.spentBy = budget
+	return true
+}
+
+// SpentBy returns the budget that ended the crawl, or "" if none did
+func (b *Budget) SpentBy() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.spentBy
+}
+
+// Pages returns how many pages have been fetched
+func (b *Budget) Pages() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.pages
+}
+
+// Bytes returns how many bytes have been downloaded
+func (b *Budget) Bytes() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.bytes
+}
+
+// Elapsed returns how long the crawl has run, across all its runs if it was resumed
+func (b *Budget) Elapsed() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.elapsedBefore + time.Since(b.started)
+}
+
+// SpentHosts returns the hosts that fetched all the pages they're allowed, in order
+func (b *Budget) SpentHosts() (hosts []string) {
+	limit := crawlerConfig.Get().MaxPagesPerHost
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for host, pages := range b.hostPages {
+		if limit > 0 && pages >= limit {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return
+}
+
+// endCrawl stops the crawl once one of its budgets is spent, leaving the pages not yet crawled unprocessed
+// and the site tree as it stands, to be printed, saved or resumed as if the crawl had been interrupted
+func (c *CrawlSession) endCrawl(budget string) {
+	if c.Budget.spend(budget) {
+		logger.Warnf("crawl budget [%s] spent, ending crawl", budget)
+		c.Cancel()
+	}
+}
+
+// rejectOverBudget rejects a page of a host that's fetched all the pages it's allowed, without fetching it
+func (c *CrawlSession) rejectOverBudget(page *Page, host string) {
+	if page == nil {
+		return
+	}
+	c.reject(page, policyError(page.URL, RuleHostBudget, "host [%s] has fetched its [%d] pages", host, crawlerConfig.Get().MaxPagesPerHost))
+	c.finish(page)
+}
+
+// limitDuration ends the crawl once it's run for the configured max duration, counting the time taken before it was resumed
+func (c *CrawlSession) limitDuration(duration time.Duration) {
+	c.Budget.mutex.Lock()
+	duration -= c.Budget.elapsedBefore
+	c.Budget.mutex.Unlock()
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		c.endCrawl(BudgetDuration)
+	case <-c.Context.Done():
+	}
+}
+
+// LogBudgetReport logs what the crawl spent, which budget ended it if any did, and which hosts spent theirs
+func (c *CrawlSession) LogBudgetReport() {
+	logger.Infof("[%d] pages fetched, [%d] bytes downloaded in [%s]",
+		c.Budget.Pages(), c.Budget.Bytes(), c.Budget.Elapsed().Round(time.Millisecond))
+
+	if spentBy := c.Budget.SpentBy(); spentBy != "" {
+		logger.Warnf("crawl ended by budget [%s]", spentBy)
+	}
+	if hosts := c.Budget.SpentHosts(); len(hosts) > 0 {
+		logger.Warnf("[%d] hosts spent their [%s] budget - %v", len(hosts), BudgetPagesPerHost, hosts)
+	}
+}
@@ -0,0 +1,83 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	config "webcrawler/config/crawler"
+)
+
+func TestCrawlBudgets(t *testing.T) {
+	// the seed links to 9 pages, each a little over 100 bytes
+	var delay time.Duration
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			for i := 1; i <= 9; i++ {
+				fmt.Fprintf(w, `<a href="/%d">page %d</a>`, i, i)
+			}
+			return
+		}
+		fmt.Fprintf(w, `<p>page %s</p>%s`, r.URL.Path, strings.Repeat(".", 100))
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		name               string
+		maxPages           int
+		maxBytes           int64
+		maxDurationSecs    int
+		maxPagesPerHost    int
+		delay              time.Duration
+		expectedPages      int
+		expectedSpentBy    string
+		expectedSpentHosts []string
+	}{
+		{name: "success_unlimited", expectedPages: 10},
+		{name: "success_pages_unspent", maxPages: 10, expectedPages: 10},
+		{name: "success_pages", maxPages: 3, expectedPages: 3, expectedSpentBy: BudgetPages},
+		{name: "success_bytes", maxBytes: 400, expectedPages: 3, expectedSpentBy: BudgetBytes},
+		{name: "success_pages_per_host", maxPagesPerHost: 4, expectedPages: 4, expectedSpentHosts: []string{host}},
+		{name: "success_duration", maxDurationSecs: 1, delay: 400 * time.Millisecond, expectedPages: 3, expectedSpentBy: BudgetDuration},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delay = test.delay
+			session, seed := crawlFixture(t, server.URL, fixtureConfig(func(conf *config.Config) {
+				conf.MaxPages, conf.MaxBytes, conf.MaxDurationSecs, conf.MaxPagesPerHost =
+					test.maxPages, test.maxBytes, test.maxDurationSecs, test.maxPagesPerHost
+			}))
+
+			if pages := session.Budget.Pages(); pages != test.expectedPages {
+				t.Errorf("pages mismatch.\n- received: %d\n- expected: %d", pages, test.expectedPages)
+			}
+			if spentBy := session.Budget.SpentBy(); spentBy != test.expectedSpentBy {
+				t.Errorf("spent by mismatch.\n- received: %s\n- expected: %s", spentBy, test.expectedSpentBy)
+			}
+			if hosts := session.Budget.SpentHosts(); fmt.Sprint(hosts) != fmt.Sprint(test.expectedSpentHosts) {
+				t.Errorf("spent hosts mismatch.\n- received: %v\n- expected: %v", hosts, test.expectedSpentHosts)
+			}
+
+			// the tree is kept whichever budget ended the crawl, pages over the host budget rejected without being fetched
+			if len(seed.Children) != 9 {
+				t.Errorf("children mismatch.\n- received: %d\n- expected: %d", len(seed.Children), 9)
+			}
+			overBudget := 0
+			for _, child := range seed.Children {
+				if RejectionRule(child.Error) == RuleHostBudget && child.Fetch == nil {
+					overBudget++
+				}
+			}
+			if test.maxPagesPerHost > 0 && overBudget != 10-test.maxPagesPerHost {
+				t.Errorf("over budget pages mismatch.\n- received: %d\n- expected: %d", overBudget, 10-test.maxPagesPerHost)
+			}
+		})
+	}
+}
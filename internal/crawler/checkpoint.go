@@ -0,0 +1,311 @@
+package crawler
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+	"webcrawler/internal/util"
+	logger "webcrawler/logger"
+)
+
+// checkpointFile is the name of the file a checkpoint is saved to within the configured checkpoint directory
+const checkpointFile = "checkpoint.json"
+
+// Checkpoint is a snapshot of a crawl session's state, from which an interrupted crawl can be resumed
+type Checkpoint struct {
+	CreatedAt time.Time `json:"created_at"`
+
+	// every page in the site trees, parents before their children
+	Pages []*PageRecord `json:"pages"`
+
+	// hex-encoded hashes of the urls crawled and the content seen so far
+	VisitedURLs []string `json:"visited_urls"`
+	SeenContent []string `json:"seen_content"`
+
+	// the encoded bloom filters recording the urls crawled and content seen, in place of their hashes
+	VisitedFilter []byte `json:"visited_filter,omitempty"`
+	SeenFilter    []byte `json:"seen_filter,omitempty"`
+
+	// what the crawl had spent of its budgets, which the resumed crawl goes on spending
+	Budget *BudgetRecord `json:"budget,omitempty"`
+}
+
+// PageRecord is the form a Page takes in a checkpoint, with its place in the tree given by IDs rather than pointers
+type PageRecord struct {
+	ID          int        `json:"id"`
+	ParentID    int        `json:"parent_id"`
+	URL         string     `json:"url"`
+	LinkText    string     `json:"link_text"`
+	ContentHash string     `json:"content_hash,omitempty"`
+	Depth       int        `json:"depth"`
+	Source      string     `json:"source,omitempty"`
+	LinkKind    string     `json:"link_kind,omitempty"`
+	Element     string     `json:"element,omitempty"`
+	Attribute   string     `json:"attribute,omitempty"`
+	RecordOnly  bool       `json:"record_only,omitempty"`
+	Rel         []string   `json:"rel,omitempty"`
+	Robots      []string   `json:"robots,omitempty"`
+	NoIndex     bool       `json:"no_index,omitempty"`
+	NoFollow    bool       `json:"no_follow,omitempty"`
+	Fetch       *FetchInfo `json:"fetch,omitempty"`
+	Attempts    int        `json:"attempts,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	ErrorKind   string     `json:"error_kind,omitempty"`
+	ErrorRule   string     `json:"error_rule,omitempty"`
+	Processed   bool       `json:"processed"`
+}
+
+// Snapshot captures the session's current state. The page trees and visited sets
+// are read together under the tree lock, so the snapshot is always consistent
+func (c *CrawlSession) Snapshot() *Checkpoint {
+	c.treeMutex.Lock()
+	defer c.treeMutex.Unlock()
+
+	checkpoint := &Checkpoint{CreatedAt: time.Now(), Budget: c.Budget.record()}
+	checkpoint.VisitedURLs, checkpoint.VisitedFilter = snapshotVisited(c.VisitedURLs)
+	checkpoint.SeenContent, checkpoint.SeenFilter = snapshotVisited(c.SeenContent)
+
+	// walk the trees breadth first, so every parent is recorded before its children
+	type queued struct {
+		page     *Page
+		parentID int
+	}
+	var queue []queued
+	for _, seed := range c.Seeds {
+		queue = append(queue, queued{page: seed, parentID: -1})
+	}
+
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+
+		record := &PageRecord{
+			ID:         len(checkpoint.Pages),
+			ParentID:   next.parentID,
+			URL:        next.page.URL,
+			LinkText:   next.page.LinkText,
+			Depth:      next.page.Depth,
+			Source:     next.page.Source,
+			LinkKind:   next.page.LinkKind,
+			Element:    next.page.Element,
+			Attribute:  next.page.Attribute,
+			RecordOnly: next.page.RecordOnly,
+			Rel:        next.page.Rel,
+			Robots:     next.page.Robots,
+			NoIndex:    next.page.NoIndex,
+			NoFollow:   next.page.NoFollow,
+			Fetch:      next.page.Fetch,
+			Attempts:   next.page.Attempts,
+			Processed:  next.page.Processed}
+		if next.page.Error != nil {
+			record.Error, record.ErrorKind = next.page.Error.Error(), ErrorKind(next.page.Error)
+			var crawlError *CrawlError
+			if errors.As(next.page.Error, &crawlError) {
+				record.ErrorRule = crawlError.Rule
+			}
+		}
+		if next.page.ContentHash != "" {
+			record.ContentHash = hex.EncodeToString([]byte(next.page.ContentHash))
+		}
+		checkpoint.Pages = append(checkpoint.Pages, record)
+
+		for _, child := range next.page.Children {
+			queue = append(queue, queued{page: child, parentID: record.ID})
+		}
+	}
+	return checkpoint
+}
+
+// Restore rebuilds the session's page trees, visited sets and budget spending from a checkpoint, and returns
+// the pages that were still waiting to be processed when it was taken, ready to be submitted again
+func (c *CrawlSession) Restore(checkpoint *Checkpoint) (pending []*Page, e error) {
+	c.treeMutex.Lock()
+	defer c.treeMutex.Unlock()
+
+	if e = restoreFilter(c.VisitedURLs, checkpoint.VisitedFilter); e != nil {
+		return nil, fmt.Errorf("could not restore checkpoint, bad visited url filter - %s", e)
+	}
+	if e = restoreFilter(c.SeenContent, checkpoint.SeenFilter); e != nil {
+		return nil, fmt.Errorf("could not restore checkpoint, bad seen content filter - %s", e)
+	}
+
+	// a checkpoint saved before budgets were recorded starts them afresh
+	if checkpoint.Budget != nil {
+		c.Budget.restore(checkpoint.Budget)
+	}
+
+	for _, hash := range checkpoint.VisitedURLs {
+		decoded, e := hex.DecodeString(hash)
+		if e != nil {
+			return nil, fmt.Errorf("could not restore checkpoint, bad visited url hash [%s] - %s", hash, e)
+		}
+		c.VisitedURLs.Add(string(decoded), 1)
+	}
+	for _, hash := range checkpoint.SeenContent {
+		decoded, e := hex.DecodeString(hash)
+		if e != nil {
+			return nil, fmt.Errorf("could not restore checkpoint, bad content hash [%s] - %s", hash, e)
+		}
+		c.SeenContent.Add(string(decoded), 1)
+	}
+
+	pages := make(map[int]*Page, len(checkpoint.Pages))
+	for _, record := range checkpoint.Pages {
+		page := &Page{
+			URL:        record.URL,
+			LinkText:   record.LinkText,
+			URLHash:    util.Hash(record.URL),
+			Depth:      record.Depth,
+			Source:     record.Source,
+			LinkKind:   record.LinkKind,
+			Element:    record.Element,
+			Attribute:  record.Attribute,
+			RecordOnly: record.RecordOnly,
+			Rel:        record.Rel,
+			Robots:     record.Robots,
+			NoIndex:    record.NoIndex,
+			NoFollow:   record.NoFollow,
+			Fetch:      record.Fetch,
+			Attempts:   record.Attempts,
+			Processed:  record.Processed}
+
+		// the error's cause can't be saved, only its message, kind and rule
+		if record.Error != "" {
+			crawlError := &CrawlError{Kind: errorKindNamed(record.ErrorKind), Rule: record.ErrorRule, URL: record.URL, Err: errors.New(record.Error)}
+			if crawlError.Kind == ErrHTTPStatus && record.Fetch != nil {
+				crawlError.StatusCode = record.Fetch.StatusCode
+			}
+			page.Error = crawlError
+		}
+
+		if record.ContentHash != "" {
+			decoded, e := hex.DecodeString(record.ContentHash)
+			if e != nil {
+				return nil, fmt.Errorf("could not restore checkpoint, bad content hash for page [%s] - %s", record.URL, e)
+			}
+			page.ContentHash = string(decoded)
+		}
+
+		if record.ParentID < 0 {
+			page.index = len(c.Seeds)
+			c.Seeds = append(c.Seeds, page)
+		} else {
+			parent, ok := pages[record.ParentID]
+			if !ok {
+				return nil, fmt.Errorf("could not restore checkpoint, page [%s] recorded before its parent", record.URL)
+			}
+			page.Parent = parent
+			page.index = len(parent.Children)
+			parent.Children = append(parent.Children, page)
+		}
+		pages[record.ID] = page
+
+		// a visited page was crawled, its children joining the tree with it, and was only waiting on them when
+		// the checkpoint was taken - submitting it again would reject it as visited, overwriting its error
+		if !page.Processed {
+			if c.VisitedURLs.KeyExists(page.URLHash) {
+				page.Processed = true
+			} else {
+				pending = append(pending, page)
+			}
+		}
+	}
+
+	logger.Infof("restored checkpoint from [%s] - [%d] pages, [%d] pending, [%d] visited",
+		checkpoint.CreatedAt.Format(time.RFC3339), len(checkpoint.Pages), len(pending), len(checkpoint.VisitedURLs))
+	return pending, nil
+}
+
+// SaveCheckpoint writes a snapshot of the session to the given directory. The snapshot is written
+// to a temporary file first and then moved into place, so a crash mid-write never leaves a broken checkpoint
+func (c *CrawlSession) SaveCheckpoint(dir string) (e error) {
+	checkpoint := c.Snapshot()
+
+	if e = os.MkdirAll(dir, 0755); e != nil {
+		return fmt.Errorf("could not create checkpoint directory [%s] - %s", dir, e)
+	}
+
+	data, e := json.Marshal(checkpoint)
+	if e != nil {
+		return fmt.Errorf("could not encode checkpoint - %s", e)
+	}
+
+	path := filepath.Join(dir, checkpointFile)
+	temp := path + ".tmp"
+	if e = os.WriteFile(temp, data, 0644); e != nil {
+		return fmt.Errorf("could not write checkpoint [%s] - %s", temp, e)
+	}
+	if e = os.Rename(temp, path); e != nil {
+		return fmt.Errorf("could not move checkpoint into place [%s] - %s", path, e)
+	}
+
+	logger.Infof("checkpoint saved to [%s] - [%d] pages", path, len(checkpoint.Pages))
+	return nil
+}
+
+// LoadCheckpoint reads the checkpoint saved in the given directory
+func LoadCheckpoint(dir string) (checkpoint *Checkpoint, e error) {
+	path := filepath.Join(dir, checkpointFile)
+
+	data, e := os.ReadFile(path)
+	if e != nil {
+		return nil, fmt.Errorf("could not read checkpoint [%s] - %s", path, e)
+	}
+
+	checkpoint = &Checkpoint{}
+	if e = json.Unmarshal(data, checkpoint); e != nil {
+		return nil, fmt.Errorf("could not decode checkpoint [%s] - %s", path, e)
+	}
+	return checkpoint, nil
+}
+
+// CheckpointPeriodically saves a checkpoint to the given directory at the given interval until the session is stopped
+func (c *CrawlSession) CheckpointPeriodically(dir string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Context.Done():
+			return
+		case <-ticker.C:
+			if e := c.SaveCheckpoint(dir); e != nil {
+				logger.Error(e)
+			}
+		}
+	}
+}
+
+// snapshotVisited captures a visited store as its hashes, or if it's a bloom filter, as the encoded filter
+func snapshotVisited(store VisitedStore) (hashes []string, filter []byte) {
+	if bloom, ok := store.(*ScalableBloomFilter); ok {
+		filter, _ = bloom.MarshalBinary()
+		return []string{}, filter
+	}
+	return encodeHashes(store.Keys()), nil
+}
+
+// restoreFilter restores a bloom filter saved in a checkpoint into the session's visited store
+func restoreFilter(store VisitedStore, filter []byte) error {
+	if filter == nil {
+		return nil
+	}
+
+	bloom, ok := store.(*ScalableBloomFilter)
+	if !ok {
+		return fmt.Errorf("checkpoint was saved with a bloom filter, but visited_store is not [%s]", VisitedBloom)
+	}
+	return bloom.UnmarshalBinary(filter)
+}
+
+func encodeHashes(hashes []string) []string {
+	encoded := make([]string, len(hashes))
+	for i, hash := range hashes {
+		encoded[i] = hex.EncodeToString([]byte(hash))
+	}
+	return encoded
+}
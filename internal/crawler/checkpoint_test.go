@@ -0,0 +1,180 @@
+package crawler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	config "webcrawler/config/crawler"
+	"webcrawler/internal/util"
+	testutil "webcrawler/test/util"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	session := NewCrawlSession(3)
+
+	seed := NewPage("https://www.google.com", "google", 0, nil)
+	seed.ContentHash = util.Hash("<html>google</html>")
+	seed.Processed = true
+	images := NewPage("https://images.google.com", "images", 1, seed)
+	images.Processed = true
+	news := NewPage("https://news.google.com", "news", 1, seed)
+	news.Source = "https://www.google.com/sitemap.xml"
+	// crawled, but still waiting on its children when the checkpoint was taken
+	maps := NewPage("https://maps.google.com", "maps", 1, seed)
+	maps.Error = &CrawlError{Kind: ErrParse, URL: maps.URL, Err: errors.New("bad html")}
+	seed.Children = []*Page{images, news, maps}
+
+	session.Seeds = []*Page{seed}
+	session.VisitedURLs.Add(seed.URLHash, 1)
+	session.VisitedURLs.Add(maps.URLHash, 1)
+	session.SeenContent.Add(seed.ContentHash, 1)
+	session.Budget.reservePage("www.google.com")
+	session.Budget.reservePage("images.google.com")
+	session.Budget.spendBytes(300)
+
+	checkpoint := session.Snapshot()
+
+	restored := NewCrawlSession(3)
+	pending, e := restored.Restore(checkpoint)
+	if e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+
+	if len(restored.Seeds) != 1 {
+		t.Fatalf("seed count mismatch.\n- received: %d\n- expected: 1", len(restored.Seeds))
+	}
+	restoredSeed := restored.Seeds[0]
+	if restoredSeed.URL != seed.URL || restoredSeed.ContentHash != seed.ContentHash || !restoredSeed.Processed {
+		t.Errorf("seed not restored.\n- received: %+v\n- expected: %+v", restoredSeed, seed)
+	}
+	if len(restoredSeed.Children) != len(seed.Children) {
+		t.Fatalf("children count mismatch.\n- received: %d\n- expected: %d", len(restoredSeed.Children), len(seed.Children))
+	}
+	for i, child := range restoredSeed.Children {
+		original := seed.Children[i]
+		if child.URL != original.URL || child.URLHash != original.URLHash || child.Source != original.Source ||
+			child.Depth != original.Depth || child.Parent != restoredSeed {
+			t.Errorf("child not restored.\n- received: %+v\n- expected: %+v", child, original)
+		}
+	}
+
+	if len(pending) != 1 || pending[0].URL != news.URL {
+		t.Errorf("pending pages mismatch.\n- received: %v\n- expected: [%s]", pending, news.URL)
+	}
+	// a visited page isn't crawled again, so keeps the error it was restored with
+	if restoredMaps := restoredSeed.Children[2]; !restoredMaps.Processed || !errors.Is(restoredMaps.Error, ErrParse) {
+		t.Errorf("visited page not restored as processed.\n- received: processed %t, error %v\n- expected: processed, parse error",
+			restoredMaps.Processed, restoredMaps.Error)
+	}
+	if !restored.VisitedURLs.KeyExists(seed.URLHash) {
+		t.Error("visited url not restored")
+	}
+	if !restored.SeenContent.KeyExists(seed.ContentHash) {
+		t.Error("seen content not restored")
+	}
+
+	// the resumed crawl goes on spending the budgets where the interrupted one left off
+	if restored.Budget.Pages() != 2 || restored.Budget.Bytes() != 300 || restored.Budget.hostPages["www.google.com"] != 1 {
+		t.Errorf("budget not restored.\n- received: %d pages, %d bytes, %v\n- expected: 2 pages, 300 bytes", restored.Budget.Pages(),
+			restored.Budget.Bytes(), restored.Budget.hostPages)
+	}
+	if elapsed := time.Duration(checkpoint.Budget.ElapsedMS) * time.Millisecond; restored.Budget.Elapsed() < elapsed {
+		t.Errorf("elapsed time not restored.\n- received: %s\n- expected: at least %s", restored.Budget.Elapsed(), elapsed)
+	}
+	withConfig(t, func(conf *config.Config) {
+		conf.MaxPages = 2
+	})
+	if spent := restored.Budget.reservePage("news.google.com"); spent != BudgetPages {
+		t.Errorf("restored budget mismatch.\n- received: %q\n- expected: %q", spent, BudgetPages)
+	}
+}
+
+func TestResumeCrawl(t *testing.T) {
+	withFixtureConfig(t)
+
+	logBuffer := testutil.GetLogBuffer()
+
+	// count every fetch of every page across both runs
+	pageCount := 60
+	fixture := testutil.GetFixtureSite(pageCount, 3)
+	defer fixture.Close()
+
+	var hitsMutex sync.Mutex
+	hits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitsMutex.Lock()
+		hits[r.URL.Path]++
+		hitsMutex.Unlock()
+		fixture.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	// first run, interrupted part way through
+	session := NewCrawlSession(3)
+	session.Start()
+	session.SubmitSeed(NewPage(server.URL, server.URL, 0, nil))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(session.VisitedURLs.Keys()) < pageCount/3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	session.Stop()
+
+	dir := t.TempDir()
+	if e := session.SaveCheckpoint(dir); e != nil {
+		t.Fatalf("unexpected error saving checkpoint - %s", e)
+	}
+
+	interruptedAt := len(session.VisitedURLs.Keys())
+	if interruptedAt >= pageCount {
+		t.Fatalf("crawl finished before it could be interrupted")
+	}
+	t.Logf("first run interrupted after [%d] pages visited", interruptedAt)
+
+	// second run, resumed from the checkpoint
+	checkpoint, e := LoadCheckpoint(dir)
+	if e != nil {
+		t.Fatalf("unexpected error loading checkpoint - %s", e)
+	}
+
+	resumed := NewCrawlSession(3)
+	pending, e := resumed.Restore(checkpoint)
+	if e != nil {
+		t.Fatalf("unexpected error restoring checkpoint - %s", e)
+	}
+
+	resumed.Start()
+	resumed.Hold()
+	for _, page := range pending {
+		resumed.Submit(page)
+	}
+	resumed.Release()
+
+	select {
+	case <-resumed.DoneChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("resumed crawl never finished")
+	}
+	resumed.Stop()
+
+	t.Log(logBuffer.String())
+
+	if visited := len(resumed.VisitedURLs.Keys()); visited != pageCount {
+		t.Errorf("resumed crawl incomplete.\n- received: %d pages visited\n- expected: %d", visited, pageCount)
+	}
+
+	// only the single page in flight when the first run was interrupted may be fetched twice
+	refetched := 0
+	for path, count := range hits {
+		if count > 1 {
+			refetched++
+			t.Logf("page [%s] fetched [%d] times", path, count)
+		}
+	}
+	if refetched > 1 {
+		t.Errorf("visited pages refetched on resume.\n- received: %d pages refetched\n- expected: at most 1", refetched)
+	}
+}
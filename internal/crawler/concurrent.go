@@ -72,3 +72,22 @@ func (c *ConcurrentMap) KeyExists(key string) bool {
 	_, ok := c.data[key]
 	return ok
 }
+
+// Keys returns a snapshot of the keys in the ConcurrentMap's map
+func (c *ConcurrentMap) Keys() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	keys := make([]string, 0, len(c.data))
+	for key := range c.data {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Len returns the number of entries in the ConcurrentMap's map
+func (c *ConcurrentMap) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.data)
+}
@@ -1,20 +1,34 @@
 package crawler
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 	crawlerConfig "webcrawler/config/crawler"
-	"webcrawler/internal/util"
 	logger "webcrawler/logger"
 )
 
 // CrawlSession holds all data structures required to crawl a given set of seed URLs
 type CrawlSession struct {
 
+	// cancelling the context stops every goroutine of the session, including in-flight fetches
+	Context context.Context
+	Cancel  context.CancelFunc
+
+	// tracks the goroutines started by the session so that stopping it can wait for them
+	goroutines *sync.WaitGroup
+
+	// guards the page trees and visited sets while they change together, so checkpoints are consistent
+	treeMutex *sync.Mutex
+
+	// the roots of the site trees being crawled
+	Seeds []*Page
+
 	// http client for crawling links
 	Client http.Client
 
@@ -26,13 +40,40 @@ type CrawlSession struct {
 
 	// enforces politeness by having separate goroutines process urls per host,
 	// so number of host visits within a specific timeframe can be controlled
-	HostChannels map[string]chan *Page
+	HostQueues map[string]Frontier
+	hostsMutex *sync.Mutex
+
+	// rates pages for best-first frontiers, DefaultScore when nil
+	Score ScoreFunc
+
+	// bounds the number of pages being crawled at once across all hosts
+	CrawlSlots chan struct{}
 
 	// stores hashes of links already crawled
-	VisitedURLs *ConcurrentMap
+	VisitedURLs VisitedStore
 
 	// stores hashes of the content of pages already crawled
-	SeenContent *ConcurrentMap
+	SeenContent VisitedStore
+
+	// keeps the frontiers, visited sets and page bodies on disk rather than in memory, when set
+	Store *DiskStore
+
+	// the pages given ids, so the pages saved in disk frontiers can be found below them
+	pagesByID  map[uint64]*Page
+	lastPageID uint64
+	pagesMutex *sync.Mutex
+
+	// caches the robots.txt rules of each host, fetched before any of its pages are
+	Robots *RobotsCache
+
+	// spaces out hits to each host, honouring robots.txt crawl delays and retry-after responses
+	Pacers *HostPacers
+
+	// records the decision on every url found, accepted or rejected, and the rule that rejected it
+	Decisions *DecisionLog
+
+	// what the crawl has spent against its configured budgets, and which budget ended it
+	Budget *Budget
 
 	// enables safe counting of urls still to be crawled
 	PendingURLs *ConcurrentCounter
@@ -43,61 +84,237 @@ type CrawlSession struct {
 
 // NewCrawlSession creates and returns a pointer to a new CrawlerSession struct
 func NewCrawlSession(readTimeoutSecs int) *CrawlSession {
-	return &CrawlSession{
+	return NewCrawlSessionWithContext(context.Background(), readTimeoutSecs)
+}
+
+// NewCrawlSessionWithContext creates and returns a pointer to a new CrawlerSession
+// struct which runs until the given context is cancelled, or the session is stopped
+func NewCrawlSessionWithContext(ctx context.Context, readTimeoutSecs int) *CrawlSession {
+	ctx, cancel := context.WithCancel(ctx)
+	config := crawlerConfig.Get()
+
+	session := &CrawlSession{
+		Context:      ctx,
+		Cancel:       cancel,
+		goroutines:   &sync.WaitGroup{},
+		treeMutex:    &sync.Mutex{},
 		Client:       *&http.Client{Timeout: time.Duration(readTimeoutSecs) * time.Second},
 		ToBeFiltered: make(chan *Page),
 		ToBeVisited:  make(chan *Page),
-		HostChannels: make(map[string]chan *Page),
-		VisitedURLs:  NewConcurrentMap(),
-		SeenContent:  NewConcurrentMap(),
+		HostQueues:   make(map[string]Frontier),
+		hostsMutex:   &sync.Mutex{},
+		pagesByID:    make(map[uint64]*Page),
+		pagesMutex:   &sync.Mutex{},
+		CrawlSlots:   make(chan struct{}, config.MaxConcurrency),
+		VisitedURLs:  newVisitedStore(config.VisitedStore, config.BloomFalsePositiveRate),
+		SeenContent:  newVisitedStore(config.VisitedStore, config.BloomFalsePositiveRate),
+		Robots:       NewRobotsCache(),
+		Pacers:       NewHostPacers(),
+		Decisions:    NewDecisionLog(),
+		Budget:       NewBudget(),
 		PendingURLs:  NewConcurrentCounter(),
 		DoneChan:     make(chan bool)}
+	session.Client.CheckRedirect = session.checkRedirect
+	return session
+}
+
+// Start launches the filtering and routing goroutines of the session, along with periodic checkpointing
+// when a checkpoint directory is configured, and a timer ending the crawl when a max duration is
+func (c *CrawlSession) Start() {
+	config := crawlerConfig.Get()
+	c.Budget.begin()
+	if config.MaxDurationSecs > 0 {
+		c.goroutines.Add(1)
+		go func() {
+			defer c.goroutines.Done()
+			c.limitDuration(time.Duration(config.MaxDurationSecs) * time.Second)
+		}()
+	}
+	if config.CheckpointDir != "" {
+		c.goroutines.Add(1)
+		go func() {
+			defer c.goroutines.Done()
+			c.CheckpointPeriodically(config.CheckpointDir, time.Duration(config.CheckpointIntervalSecs)*time.Second)
+		}()
+	}
+
+	c.goroutines.Add(2)
+
+	go func() {
+		defer c.goroutines.Done()
+		c.FilterURLs()
+	}()
+
+	go func() {
+		defer c.goroutines.Done()
+		c.RouteAcceptedURLs()
+	}()
+}
+
+// Stop cancels the session and waits for all of its goroutines, including in-flight crawls, to finish
+func (c *CrawlSession) Stop() {
+	logger.Info("stopping crawl session")
+	c.Cancel()
+	c.goroutines.Wait()
+	logger.Info("crawl session stopped")
+}
+
+// SubmitSeed records a page as the root of a site tree and sends it to be filtered for crawling
+func (c *CrawlSession) SubmitSeed(page *Page) bool {
+	c.treeMutex.Lock()
+	page.index = len(c.Seeds)
+	c.Seeds = append(c.Seeds, page)
+	c.treeMutex.Unlock()
+
+	return c.Submit(page)
+}
+
+// Submit sends a page to be filtered for crawling, returning false if the session was stopped first.
+// The page counts as pending from the moment it's submitted, so the crawl can't be considered done
+// while it's still on its way through the filter
+func (c *CrawlSession) Submit(page *Page) bool {
+	c.PendingURLs.Add(1)
+
+	select {
+	case c.ToBeFiltered <- page:
+		return true
+	case <-c.Context.Done():
+		c.PendingURLs.Subtract(1)
+		return false
+	}
+}
+
+// Hold keeps the crawl from being considered done until Release is called, e.g. while pages are submitted one by one,
+// so it isn't ended by the first of them being rejected before the rest are sent - which would leave them never sent
+func (c *CrawlSession) Hold() {
+	c.PendingURLs.Add(1)
+}
+
+// Release undoes Hold, ending the crawl if nothing else is pending. The crawl being done is signalled
+// from its own goroutine, so whoever released it can go on to wait for that
+func (c *CrawlSession) Release() {
+	c.goroutines.Add(1)
+	go func() {
+		defer c.goroutines.Done()
+		c.finish(nil)
+	}()
 }
 
 // FilterURLs continuously receives from the "ToBeFiltered" channel and decides
-// which urls received should be send to the router for crawling
+// which urls received should be send to the router for crawling. It blocks while
+// there is nothing to filter, and while the router is busy
 func (c *CrawlSession) FilterURLs() {
 	for {
 		select {
-		case page := <-c.ToBeFiltered:
-			logger.Infof("new page to be filtered - %s", page.URL)
+		case <-c.Context.Done():
+			logger.Info("filtering stopped")
+			return
 
-			if page.IsCrawlable(c.VisitedURLs, c.SeenContent) {
-				logger.Infof("new page accepted - %s", page.URL)
-
-				c.PendingURLs.Add(1)
-				c.ToBeVisited <- page
-			} else {
-				logger.Infof("new page rejected - %s", page.URL)
-				c.CheckDone()
+		case page := <-c.ToBeFiltered:
+			if c.accept(page) {
+				select {
+				case c.ToBeVisited <- page:
+				case <-c.Context.Done():
+				}
 			}
-
-		default:
 		}
 	}
 }
 
 // RouteAcceptedURLs received recently-filtered urls from the "ToBeVisited" channel and
-// finds the appropriate channel to send them to for crawling based on their host. Crawling
+// finds the appropriate queue to send them to for crawling based on their host. Crawling
 // is split by host so that the timing of hits to that host can be controlled so as not to
-// overwhelm it / break its rate-limiting rules
+// overwhelm it / break its rate-limiting rules. Host queues are unbounded, so a slow host
+// never holds up routing to the others
 func (c *CrawlSession) RouteAcceptedURLs() {
 	for {
 		select {
+		case <-c.Context.Done():
+			logger.Info("routing stopped")
+			return
+
 		case page := <-c.ToBeVisited:
-			logger.Infof("new page to be routed - %s", page.URL)
+			c.route(page)
+		}
+	}
+}
 
-			channel, e := c.GetHostChannel(page)
-			if e != nil {
-				logger.Errorf("could not get host-specific channel to send page [%s] to", page.URL)
-				break
-			}
+// accept decides whether a page should be crawled, marking it as processed if not
+func (c *CrawlSession) accept(page *Page) bool {
+	logger.Infof("new page to be filtered - %s", page.URL)
+
+	e := page.CheckCrawlable(c.VisitedURLs, c.SeenContent, c.GetRobots(page.URL))
+	if e == nil {
+		logger.Infof("new page accepted - %s", page.URL)
+		c.Decisions.Record(decisionFor(StageCrawl, page.URL, nil))
+		return true
+	}
 
-			channel <- page
+	logger.Infof("new page rejected - %s", page.URL)
+	c.reject(page, e)
+	page.signalQueued()
+	c.finish(page)
+	return false
+}
+
+// fail records why a page wasn't crawled
+func (c *CrawlSession) fail(page *Page, e error) {
+	c.treeMutex.Lock()
+	page.Error = e
+	c.treeMutex.Unlock()
+}
 
-		default:
+// route pushes an accepted page onto the frontier of its host
+func (c *CrawlSession) route(page *Page) {
+	logger.Infof("new page to be routed - %s", page.URL)
+
+	// the signal is taken before the page is pushed, since from then on it may be popped, crawled,
+	// failed and routed again for a retry while this is still running
+	queued := page.takeQueued()
+	defer func() {
+		if queued != nil {
+			queued <- struct{}{}
 		}
+	}()
+
+	queue, e := c.GetHostQueue(page)
+	if e != nil {
+		logger.Errorf("could not get host-specific queue to send page [%s] to - %s", page.URL, e)
+		c.finish(page)
+		return
 	}
+
+	if e = queue.Push(page); e != nil {
+		logger.Errorf("could not queue page [%s] - %s", page.URL, e)
+		c.finish(page)
+	}
+}
+
+// signalQueued tells the crawl that found a page, if it's waiting, that the page has been rejected or routed to its host
+func (page *Page) signalQueued() {
+	if queued := page.takeQueued(); queued != nil {
+		queued <- struct{}{}
+	}
+}
+
+// takeQueued returns the channel the page signals once it's rejected or routed, if any, clearing it so it's only signalled once
+func (page *Page) takeQueued() chan<- struct{} {
+	queued := page.queued
+	page.queued = nil
+	return queued
+}
+
+// finish marks a page as processed - rejected or crawled - and checks whether it was the last one pending.
+// A page whose processing was cut short by the session stopping is left unprocessed, to be resumed later
+func (c *CrawlSession) finish(page *Page) {
+	if page != nil && c.Context.Err() == nil {
+		c.treeMutex.Lock()
+		page.Processed = true
+		c.treeMutex.Unlock()
+	}
+
+	c.PendingURLs.Subtract(1)
+	c.CheckDone()
 }
 
 // CheckDone checks whether it's time to finish the crawl session and print the link tree(s)
@@ -106,7 +323,10 @@ func (c *CrawlSession) CheckDone() {
 
 	if c.PendingURLs.GetCount() == 0 {
 		logger.Info("no more pending urls, ending crawl")
-		c.DoneChan <- true
+		select {
+		case c.DoneChan <- true:
+		case <-c.Context.Done():
+		}
 		return
 	}
 
@@ -114,41 +334,132 @@ func (c *CrawlSession) CheckDone() {
 	logger.Info("crawl continuing...")
 }
 
-// GetHostChannel finds the appropriate channel to send a crawlable page to in order to be crawled
-func (c *CrawlSession) GetHostChannel(page *Page) (hostChannel chan *Page, e error) {
+// GetHostQueue finds the appropriate queue to send a crawlable page to in order to be crawled.
+// Each host's queue is a frontier of the configured strategy, which decides the order its pages are crawled in
+func (c *CrawlSession) GetHostQueue(page *Page) (hostQueue Frontier, e error) {
 	// get url domain part
 	domain, e := GetURLDomain(page.URL)
 	if e != nil {
-		e = fmt.Errorf("could not get url domain in order to find host channel - %s", e)
+		e = fmt.Errorf("could not get url domain in order to find host queue - %s", e)
 		return
 	}
 
-	// check if domain exists in map & if not, create channel entry
-	channel, ok := c.HostChannels[domain]
-	if !ok {
-		channel = make(chan *Page)
-		c.HostChannels[domain] = channel
+	// make sure the host's robots.txt is known before anything is fetched from it - outside
+	// the lock, so a slow robots.txt doesn't hold up routing to every other host
+	c.GetRobots(page.URL)
 
-		go c.CrawlDomainURLs(domain, channel)
-		logger.Infof("host channel created for domain [%s]", domain)
+	// check if domain exists in map & if not, create queue entry
+	c.hostsMutex.Lock()
+	defer c.hostsMutex.Unlock()
+
+	queue, ok := c.HostQueues[domain]
+	if !ok {
+		if c.Store != nil {
+			queue, e = c.Store.Frontier(domain, crawlerConfig.Get().Frontier, c.Score, c)
+		} else {
+			queue, e = NewFrontier(crawlerConfig.Get().Frontier, c.Score)
+		}
+		if e != nil {
+			e = fmt.Errorf("could not create host queue - %s", e)
+			return
+		}
+		c.HostQueues[domain] = queue
+
+		c.goroutines.Add(1)
+		go func() {
+			defer c.goroutines.Done()
+			c.CrawlDomainURLs(domain, queue)
+		}()
+		logger.Infof("host queue created for domain [%s]", domain)
 	}
 
-	logger.Infof("returning host channel for domain [%s]", domain)
-	return channel, nil
+	logger.Infof("returning host queue for domain [%s]", domain)
+	return queue, nil
 }
 
-// CrawlDomainURLs runs once per domain
-func (c *CrawlSession) CrawlDomainURLs(domain string, channel chan *Page) {
+// CrawlDomainURLs runs once per domain, pacing the hits made to it and
+// waiting for a free crawl slot before handing each page off to be crawled
+func (c *CrawlSession) CrawlDomainURLs(domain string, queue Frontier) {
 	logger.Infof("now receiving urls to be crawled from domain [%s]", domain)
+	defer logger.Infof("stopped receiving urls to be crawled from domain [%s]", domain)
 
 	for {
-		page := <-channel
+		if e := WaitForPage(c.Context, queue); e != nil {
+			return
+		}
+
+		// pages may be pushed between peeking at the next page and popping it, so whatever's decided
+		// from the peeked page is decided again from the popped one, which may have taken its place
+		var page *Page
+		next := queue.Peek()
+		if next == nil || next.Attempts == 0 && c.Budget.hostSpent(domain) {
+			page = queue.Pop()
+
+			// a page that can't be read back from a disk frontier is dropped, and no longer pending
+			if page == nil {
+				c.finish(nil)
+				continue
+			}
+
+			// once the host has fetched all the pages it's allowed, the rest of its frontier is rejected without waiting
+			if page.Attempts == 0 && c.Budget.hostSpent(domain) {
+				c.rejectOverBudget(page, domain)
+				continue
+			}
+			next = page
+		}
+
+		// every page in the queue is from the same host, so shares its pacer whichever is popped
+		pacer := c.GetHostPacer(next.URL)
+		if e := pacer.Wait(c.Context); e != nil {
+			return
+		}
 
+		select {
+		case c.CrawlSlots <- struct{}{}:
+		case <-c.Context.Done():
+			return
+		}
+
+		// the next page is only chosen once it can be crawled, so pages
+		// queued while waiting are still considered in frontier order
+		if page == nil {
+			page = queue.Pop()
+			if page == nil {
+				<-c.CrawlSlots
+				c.finish(nil)
+				continue
+			}
+		}
 		logger.Infof("received new link [%s] from domain [%s] for crawl", page.URL, domain)
-		time.Sleep(time.Duration(crawlerConfig.Get().DomainHitDelayMS) * time.Millisecond)
+
+		// a retry was paid for by the page's first attempt
+		if page.Attempts == 0 {
+			switch spent := c.Budget.reservePage(domain); spent {
+			case "":
+			case BudgetPagesPerHost:
+				<-c.CrawlSlots
+				c.rejectOverBudget(page, domain)
+				continue
+			default:
+				<-c.CrawlSlots
+				c.endCrawl(spent)
+				return
+			}
+		}
+
+		// waiting for a slot may have taken a while, so the hit really happens now
+		pacer.Hit()
 		logger.Infof("queueing new link [%s] from domain [%s] for crawl", page.URL, domain)
 
-		go c.Crawl(page)
+		c.goroutines.Add(1)
+		go func() {
+			defer func() {
+				<-c.CrawlSlots
+				c.goroutines.Done()
+			}()
+			c.Crawl(page)
+		}()
 	}
 }
 
@@ -158,9 +469,13 @@ func (c *CrawlSession) CrawlDomainURLs(domain string, channel chan *Page) {
 func (c *CrawlSession) Crawl(currentPage *Page) {
 	var children []*Page
 
+	// a page sent to be retried is still pending, so it's only finished once it's been tried for the last time,
+	// and a seed only once the pages in its sitemaps have been added
+	retrying, addingSitemapPages := false, false
 	defer func() {
-		c.PendingURLs.Subtract(1)
-		c.CheckDone()
+		if !retrying && !addingSitemapPages {
+			c.finish(currentPage)
+		}
 	}()
 
 	if currentPage == nil {
@@ -175,74 +490,252 @@ func (c *CrawlSession) Crawl(currentPage *Page) {
 		logger.Infof("crawling seed page [%s], depth [%d]", currentPage.URL, currentPage.Depth)
 	}
 
-	// fetch page body
-	pageBody, e := c.FetchPageBody(currentPage.URL)
+	// the page may have been visited since it was queued, e.g. by a redirect to it from another page
+	if c.VisitedURLs.KeyExists(currentPage.URLHash) {
+		c.reject(currentPage, policyError(currentPage.URL, RuleVisited, "url visited since it was queued"))
+		return
+	}
+
+	// fetch page
+	response, fetch, e := c.fetchPage(withRedirectSeed(c.Context, currentPage.Seed().URL), currentPage.URL)
+	if c.Context.Err() != nil {
+		logger.Infof("crawl of page [%s] cancelled", currentPage.URL)
+		return
+	}
+
+	// the body is streamed through the link extractor as it's read, bounded by the max body size, hashed on the way and
+	// written straight to wherever the session keeps bodies, so with a disk store no more than a chunk of it is held in
+	// memory. What's written of it is discarded, and its links aren't decided on, unless the page is kept
+	var body *bodyReader
+	var kept keptBody
+	var metaDirectives []string
+	var parseError error
+	var linkDecisions []Decision
+	bodyKept := false
+	defer func() {
+		if kept != nil && !bodyKept {
+			kept.discard()
+		}
+	}()
+	if e == nil {
+		kept = c.newKeptBody(currentPage)
+		body = newBodyReader(response.Body, kept)
+
+		// a body declared too large isn't read at all, if that aborts the fetch, nor is that of a redirect to a page visited already
+		declaredTooLarge := body.abort && body.limit > 0 && response.ContentLength > body.limit
+		finalHash := fetch.finalHash()
+		redirectVisited := finalHash != currentPage.URLHash && c.VisitedURLs.KeyExists(finalHash)
+		if !declaredTooLarge && !redirectVisited {
+			children, metaDirectives, parseError = currentPage.parseChildren(body, currentPage.Depth+1,
+				fetch.documentURL(currentPage.URL), func(decision Decision) {
+					linkDecisions = append(linkDecisions, decision)
+				})
+			body.drain()
+		}
+		body.Close()
+		c.Budget.spendBytes(body.size)
+
+		fetch.Size = body.size
+		if declaredTooLarge {
+			fetch.Size, body.truncated = response.ContentLength, true
+		}
+		fetch.Truncated = body.truncated
+		if e = body.err(currentPage.URL); e != nil {
+			fetch.Error = e.Error()
+		}
+	}
+
+	// the fetch is recorded whatever its outcome, so broken and non-html pages keep their status, headers and error
+	c.treeMutex.Lock()
+	currentPage.Fetch = fetch
+	currentPage.Attempts++
+	currentPage.Error = e
+	c.treeMutex.Unlock()
+
 	if e != nil {
+		// e.g. its redirect was refused, having been recorded as the page's error already
+		if errors.Is(e, ErrPolicy) {
+			c.Decisions.Record(decisionFor(StageCrawl, currentPage.URL, e))
+			return
+		}
+		if retrying = c.retry(currentPage, e); retrying {
+			return
+		}
 		logger.Warnf("broken link [%s], can't crawl - %s", currentPage.URL, e)
 		return
 	}
-	if pageBody == nil || pageBody == http.NoBody {
-		logger.Errorf("page body for url [%s] is empty, nothing to crawl", currentPage.URL)
+
+	// a redirected page is stored under the url it was linked by, but is the page at the url it was
+	// redirected to - so it's only crawled once, whichever of its urls it's reached through first
+	if finalHash := fetch.finalHash(); finalHash != currentPage.URLHash {
+		c.treeMutex.Lock()
+		alreadyVisited := c.VisitedURLs.KeyExists(finalHash)
+		c.VisitedURLs.Add(finalHash, 1)
+		if alreadyVisited {
+			c.VisitedURLs.Add(currentPage.URLHash, 1)
+		}
+		c.treeMutex.Unlock()
+
+		if alreadyVisited {
+			c.reject(currentPage, policyError(currentPage.URL, RuleRedirectVisited, "redirected to [%s], already visited", fetch.FinalURL))
+			return
+		}
+	}
+
+	if fetch.Truncated {
+		logger.Warnf("body of page [%s] truncated at [%d] bytes", currentPage.URL, fetch.Size)
+	}
+
+	contentHash := body.Hash()
+	if c.SeenContent.KeyExists(contentHash) {
+		c.reject(currentPage, policyError(currentPage.URL, RuleContentSeen, "content already seen"))
 		return
 	}
 
-	// can't read the response twice, so after we've extracted the content to get the page content string,
-	// we reload the response with those same bytes in order to create the page tokeniser below
-	pageBytes, _ := io.ReadAll(pageBody)
-	pageBody = io.NopCloser(bytes.NewBuffer(pageBytes))
-	pageContentString := string(pageBytes)
+	bodyKept = true
+	if e := kept.keep(currentPage); e != nil {
+		logger.Errorf("could not save body of page [%s] to disk - %s", currentPage.URL, e)
+	}
 
-	currentPage.RawContent = pageContentString
-	currentPage.ContentHash = util.Hash(pageContentString)
+	for _, decision := range linkDecisions {
+		c.Decisions.Record(decision)
+	}
 
-	if c.SeenContent.KeyExists(currentPage.ContentHash) {
-		logger.Infof("page [%s] not crawlable - content already seen", currentPage.URL)
-		return
+	// the page's robots directives come from its X-Robots-Tag headers as well as its meta tags
+	var directives []string
+	for _, value := range response.Header.Values("X-Robots-Tag") {
+		directives = append(directives, ParseRobotsTag(value, crawlerConfig.Get().UserAgent)...)
 	}
+	directives = append(directives, metaDirectives...)
 
+	// the page's children join the tree at the same moment it's marked as visited,
+	// so a checkpoint never holds a visited page without its children
+	c.treeMutex.Lock()
+	currentPage.ContentHash = contentHash
+	currentPage.Children = children
+	for i, child := range children {
+		child.index = i
+	}
+	currentPage.AddRobotsDirectives(directives)
+	if parseError != nil {
+		currentPage.Error = parseError
+	}
 	c.VisitedURLs.Add(currentPage.URLHash, 1)
 	c.SeenContent.Add(currentPage.ContentHash, 1)
+	c.treeMutex.Unlock()
 
-	children = currentPage.GetChildren(pageBody, currentPage.Depth+1)
-	currentPage.Children = children
-
+	// children go through the filter and router like any other page, but this crawl only finishes once they're
+	// through, so they're already on their host's frontier when the next page is chosen, keeping the configured crawl order
+	queued := make(chan struct{}, len(children))
 	for _, child := range children {
-		c.ToBeFiltered <- child
+		child.queued = queued
+		if !c.Submit(child) {
+			logger.Infof("crawl of page [%s] cancelled before all children were queued", currentPage.URL)
+			return
+		}
+	}
+	for range children {
+		select {
+		case <-queued:
+		case <-c.Context.Done():
+			logger.Infof("crawl of page [%s] cancelled before all children were queued", currentPage.URL)
+			return
+		}
+	}
+
+	// seeds also take in the pages listed in their site's sitemaps, which may not be linked from anywhere
+	if currentPage.Depth == 0 && crawlerConfig.Get().UseSitemaps {
+		addingSitemapPages = true
+		c.addSitemapPages(currentPage)
 	}
 }
 
 // FetchPageBody performs a GET request on the given url and returns
 // the response body when it is of type "text/html"
 func (c *CrawlSession) FetchPageBody(url string) (body io.ReadCloser, e error) {
+	response, _, e := c.FetchPage(url)
+	if e != nil {
+		return
+	}
+	return response.Body, nil
+}
+
+// FetchPage performs a GET request on the given url and returns the response when its body is of
+// type "text/html", along with what's known of the fetch - which is returned even when it fails
+func (c *CrawlSession) FetchPage(url string) (response *http.Response, fetch *FetchInfo, e error) {
+	return c.fetchPage(c.Context, url)
+}
+
+// fetchPage performs a GET request on the given url with the given context, as FetchPage does
+func (c *CrawlSession) fetchPage(ctx context.Context, url string) (response *http.Response, fetch *FetchInfo, e error) {
 	logger.Infof("fetching page [%s]", url)
 
-	req, e := http.NewRequest(http.MethodGet, url, nil)
+	fetch = &FetchInfo{FetchedAt: time.Now(), Size: -1}
+	defer func() {
+		if e != nil {
+			fetch.Error = e.Error()
+		}
+	}()
+
+	req, e := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if e != nil {
-		e = fmt.Errorf("error creating GET request for url [%s] - %s", url, e)
+		e = &CrawlError{Kind: ErrParse, URL: url, Err: fmt.Errorf("error creating GET request for url [%s] - %w", url, e)}
 		logger.Error(e)
-		return
+		return nil, fetch, e
+	}
+	if userAgent := crawlerConfig.Get().UserAgent; userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
 	}
 
-	response, e := c.Client.Do(req)
+	response, e = c.Client.Do(req)
+	fetch.Latency = time.Since(fetch.FetchedAt)
 	if e != nil {
-		e = fmt.Errorf("error fetching page [%s] - %s", url, e)
+		// a redirect that couldn't be followed leaves the last response, so the chain up to it is still recorded
+		if response != nil {
+			fetch.record(response)
+		}
+		// a redirect refused by the crawl's rules is a policy decision, not a failed fetch
+		var policy *CrawlError
+		if errors.As(e, &policy) && policy.Kind == ErrPolicy {
+			return nil, fetch, policy
+		}
+		e = &CrawlError{Kind: fetchErrorKind(e), URL: url, Err: fmt.Errorf("error fetching page [%s] - %w", url, e)}
 		logger.Error(e)
-		return
+		return nil, fetch, e
 	}
+	fetch.record(response)
+
+	c.applyRetryAfter(url, response)
 
 	status := response.StatusCode
 	if status < 200 || status > 299 {
-		e = fmt.Errorf("could not fetch page [%s], status code [%d]", url, status)
+		response.Body.Close()
+		e = &CrawlError{Kind: ErrHTTPStatus, URL: url, StatusCode: status, Err: fmt.Errorf("could not fetch page [%s], status code [%d]", url, status)}
 		logger.Error(e)
-		return
+		return nil, fetch, e
 	}
 
 	for _, contentType := range response.Header["Content-Type"] {
 		if strings.Contains(contentType, "text/html") {
-			return response.Body, nil
+			return response, fetch, nil
 		}
 	}
-	e = fmt.Errorf("no html in page [%s]", url)
+	response.Body.Close()
+	e = &CrawlError{Kind: ErrContentType, URL: url, Err: fmt.Errorf("no html in page [%s]", url)}
 	logger.Error(e)
-	return
+	return nil, fetch, e
+}
+
+// record takes the status, headers and redirects of a response into the fetch
+func (fetch *FetchInfo) record(response *http.Response) {
+	fetch.StatusCode = response.StatusCode
+	fetch.Header = response.Header.Clone()
+	fetch.ContentType = response.Header.Get("Content-Type")
+	fetch.Size = response.ContentLength
+	fetch.FinalURL = response.Request.URL.String()
+
+	// each request made following a redirect holds the response that redirected it
+	for request := response.Request; request.Response != nil; request = request.Response.Request {
+		fetch.RedirectChain = append([]string{request.Response.Request.URL.String()}, fetch.RedirectChain...)
+	}
 }
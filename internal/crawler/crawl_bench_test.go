@@ -0,0 +1,94 @@
+//go:build unix
+
+package crawler
+
+import (
+	"io"
+	"log"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	config "webcrawler/config/crawler"
+	testutil "webcrawler/test/util"
+)
+
+// cpuTime returns the total user and system CPU time used by the process so far
+func cpuTime(b *testing.B) time.Duration {
+	var usage syscall.Rusage
+	if e := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); e != nil {
+		b.Fatalf("could not get cpu usage - %s", e)
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// benchmarkConfig points the crawler at a local fixture site - no delays, robots.txt, sitemaps or checkpoints,
+// and no logging to slow it down - for the rest of the benchmark
+func benchmarkConfig(b *testing.B, maxConcurrency int) {
+	withFixtureConfig(b, func(conf *config.Config) {
+		conf.CheckpointDir = ""
+		conf.MaxConcurrency = maxConcurrency
+	})
+
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// BenchmarkIdleSession measures the CPU used by a started session with nothing to crawl,
+// which should be close to none now the pipeline blocks instead of spinning
+func BenchmarkIdleSession(b *testing.B) {
+	benchmarkConfig(b, 10)
+
+	idle := 100 * time.Millisecond
+	var used time.Duration
+
+	for i := 0; i < b.N; i++ {
+		session := NewCrawlSession(3)
+		session.Start()
+
+		start := cpuTime(b)
+		time.Sleep(idle)
+		used += cpuTime(b) - start
+
+		session.Stop()
+	}
+
+	b.ReportMetric(float64(used.Microseconds())/float64(b.N)/idle.Seconds()/1000, "cpu-ms/idle-s")
+}
+
+func benchmarkCrawlFixtureSite(b *testing.B, maxConcurrency int) {
+	benchmarkConfig(b, maxConcurrency)
+
+	pageCount := 1000
+	server := testutil.GetFixtureSite(pageCount, 5)
+	defer server.Close()
+
+	var used time.Duration
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		start := cpuTime(b)
+
+		session := NewCrawlSession(3)
+		session.Start()
+		session.Submit(NewPage(server.URL, server.URL, 0, nil))
+		<-session.DoneChan
+		session.Stop()
+
+		used += cpuTime(b) - start
+
+		if visited := session.VisitedURLs.Len(); visited != pageCount {
+			b.Fatalf("fixture site not fully crawled.\n- received: %d pages\n- expected: %d pages", visited, pageCount)
+		}
+	}
+
+	b.ReportMetric(float64(pageCount*b.N)/b.Elapsed().Seconds(), "pages/s")
+	b.ReportMetric(float64(used.Milliseconds())/float64(b.N), "cpu-ms/crawl")
+}
+
+// BenchmarkCrawlFixtureSite measures crawl throughput on a local site of 1000 pages at various global concurrency limits
+func BenchmarkCrawlFixtureSite(b *testing.B) {
+	b.Run("concurrency_1", func(b *testing.B) { benchmarkCrawlFixtureSite(b, 1) })
+	b.Run("concurrency_10", func(b *testing.B) { benchmarkCrawlFixtureSite(b, 10) })
+	b.Run("concurrency_50", func(b *testing.B) { benchmarkCrawlFixtureSite(b, 50) })
+}
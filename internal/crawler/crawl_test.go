@@ -3,7 +3,10 @@ package crawler
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 	config "webcrawler/config/crawler"
@@ -11,6 +14,97 @@ import (
 	testutil "webcrawler/test/util"
 )
 
+// TestMain turns checkpointing off for every test and benchmark, so none of them writes a checkpoint
+// of its fixture pages to the configured directory, where a later resume would pick it up
+func TestMain(m *testing.M) {
+	config.Get().CheckpointDir = ""
+	os.Exit(m.Run())
+}
+
+// withConfig changes the config for the rest of a test or benchmark, restoring it once that's done. The
+// config is validated once changed, so e.g. a changed blacklist or scope rules are parsed as they would be on loading
+func withConfig(t testing.TB, change func(conf *config.Config)) {
+	t.Helper()
+
+	conf := config.Get()
+	previous := *conf
+	t.Cleanup(func() { *conf = previous })
+
+	change(conf)
+	if e := conf.Validate(); e != nil {
+		t.Fatalf("invalid config - %s", e)
+	}
+}
+
+// fixtureOptions change the config and session of a fixture crawl before it starts
+type fixtureOptions struct {
+	configure []func(conf *config.Config)
+	prepare   []func(session *CrawlSession)
+}
+
+type fixtureOption func(options *fixtureOptions)
+
+// fixtureConfig changes the config of a fixture crawl, over the fixture config
+func fixtureConfig(configure func(conf *config.Config)) fixtureOption {
+	return func(options *fixtureOptions) {
+		options.configure = append(options.configure, configure)
+	}
+}
+
+// fixtureSession changes the session of a fixture crawl before it's started
+func fixtureSession(prepare func(session *CrawlSession)) fixtureOption {
+	return func(options *fixtureOptions) {
+		options.prepare = append(options.prepare, prepare)
+	}
+}
+
+// withFixtureConfig sets the config for crawling a local test site - no politeness delay, robots.txt or sitemaps,
+// one crawl slot and depth enough for any test site - changed by configure, for the rest of a test or benchmark
+func withFixtureConfig(t testing.TB, configure ...func(conf *config.Config)) {
+	t.Helper()
+
+	withConfig(t, func(conf *config.Config) {
+		conf.DomainHitDelayMS = 0
+		conf.IgnoreRobots = true
+		conf.UseSitemaps = false
+		conf.MaxDepth = 100
+		conf.MaxConcurrency = 1
+		for _, change := range configure {
+			change(conf)
+		}
+	})
+}
+
+// crawlFixture crawls a local test site from the given seed url, with the fixture config unless options change it,
+// and returns the session, stopped, and the seed
+func crawlFixture(t *testing.T, url string, options ...fixtureOption) (*CrawlSession, *Page) {
+	t.Helper()
+
+	var fixture fixtureOptions
+	for _, option := range options {
+		option(&fixture)
+	}
+	withFixtureConfig(t, fixture.configure...)
+
+	session := NewCrawlSession(3)
+	for _, prepare := range fixture.prepare {
+		prepare(session)
+	}
+	seed := NewPage(url, url, 0, nil)
+	session.Start()
+	session.SubmitSeed(seed)
+
+	// a spent budget ends the crawl by cancelling the session
+	select {
+	case <-session.DoneChan:
+	case <-session.Context.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("crawl never finished")
+	}
+	session.Stop()
+	return session, seed
+}
+
 func TestNewCrawlSession(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -21,7 +115,7 @@ func TestNewCrawlSession(t *testing.T) {
 			expectedResult: CrawlSession{
 				ToBeFiltered: make(chan *Page),
 				ToBeVisited:  make(chan *Page),
-				HostChannels: make(map[string]chan *Page),
+				HostQueues:   make(map[string]Frontier),
 				VisitedURLs:  NewConcurrentMap(),
 				SeenContent:  NewConcurrentMap(),
 				PendingURLs:  NewConcurrentCounter(),
@@ -41,8 +135,11 @@ func TestNewCrawlSession(t *testing.T) {
 			if session.ToBeVisited == nil || len(session.ToBeVisited) != len(test.expectedResult.ToBeVisited) {
 				t.Errorf("unexpected result.\n- received: %v\n- expected %v", session.ToBeVisited, test.expectedResult.ToBeVisited)
 			}
-			if session.HostChannels == nil || len(session.HostChannels) != len(test.expectedResult.HostChannels) {
-				t.Errorf("unexpected result.\n- received: %v\n- expected %v", session.HostChannels, test.expectedResult.HostChannels)
+			if session.HostQueues == nil || len(session.HostQueues) != len(test.expectedResult.HostQueues) {
+				t.Errorf("unexpected result.\n- received: %v\n- expected %v", session.HostQueues, test.expectedResult.HostQueues)
+			}
+			if session.CrawlSlots == nil || cap(session.CrawlSlots) != config.Get().MaxConcurrency {
+				t.Errorf("unexpected result.\n- received: %d crawl slots\n- expected %d", cap(session.CrawlSlots), config.Get().MaxConcurrency)
 			}
 			if session.VisitedURLs == nil {
 				t.Errorf("unexpected result.\n- received: %v\n- expected %v", session.VisitedURLs, test.expectedResult.VisitedURLs)
@@ -61,6 +158,10 @@ func TestNewCrawlSession(t *testing.T) {
 }
 
 func TestFilterURLs(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.IgnoreRobots = true
+	})
+
 	tests := []struct {
 		name                 string
 		pages                []*Page
@@ -120,7 +221,7 @@ func TestFilterURLs(t *testing.T) {
 			}()
 
 			for _, page := range test.pages {
-				session.ToBeFiltered <- page
+				session.Submit(page)
 			}
 
 			time.Sleep(200 * time.Millisecond)
@@ -134,6 +235,10 @@ func TestFilterURLs(t *testing.T) {
 }
 
 func TestRouteAcceptedURLs(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.IgnoreRobots = true
+	})
+
 	tests := []struct {
 		name  string
 		pages []*Page
@@ -154,17 +259,15 @@ func TestRouteAcceptedURLs(t *testing.T) {
 			logBuffer := testutil.GetLogBuffer()
 
 			session := NewCrawlSession(3)
-			go session.RouteAcceptedURLs()
+			defer session.Stop()
 
-			for _, page := range test.pages {
-				go func(page *Page) {
-					for {
-						channel, _ := session.GetHostChannel(page)
-						<-channel
-					}
-				}(page)
+			// occupy every crawl slot so routed pages stay queued
+			for i := 0; i < cap(session.CrawlSlots); i++ {
+				session.CrawlSlots <- struct{}{}
 			}
 
+			go session.RouteAcceptedURLs()
+
 			for _, page := range test.pages {
 				session.ToBeVisited <- page
 			}
@@ -178,8 +281,8 @@ func TestRouteAcceptedURLs(t *testing.T) {
 					t.Errorf("bad test input, page url - %s", page.URL)
 				}
 
-				if _, ok := session.HostChannels[domain]; !ok {
-					t.Errorf("no host-specific channel found for domain [%s], input url [%s]", domain, page.URL)
+				if _, ok := session.HostQueues[domain]; !ok {
+					t.Errorf("no host-specific queue found for domain [%s], input url [%s]", domain, page.URL)
 				}
 			}
 		})
@@ -189,33 +292,83 @@ func TestRouteAcceptedURLs(t *testing.T) {
 func TestCheckDone(t *testing.T) {
 	session := NewCrawlSession(3)
 
-	isDone := false
+	// the crawl is done once it's signalled on the done channel, read here so the check never blocks
+	done := make(chan bool, 1)
+	go func() {
+		<-session.DoneChan
+		done <- true
+	}()
 
 	session.PendingURLs.Add(1)
 	session.CheckDone()
-	time.Sleep(200 * time.Millisecond)
 
-	if isDone {
-		t.Error("check done not working - 'isDone' should be false")
+	select {
+	case <-done:
+		t.Error("check done not working - crawl shouldn't be done")
+	case <-time.After(200 * time.Millisecond):
 	}
 
-	go func(done *bool) {
-		for {
-			<-session.DoneChan
-			*done = true
-		}
-	}(&isDone)
-
 	session.PendingURLs.Subtract(1)
 	session.CheckDone()
-	time.Sleep(200 * time.Millisecond)
 
-	if !isDone {
-		t.Error("check done not working - 'isDone' should be true")
+	select {
+	case <-done:
+	case <-time.After(200 * time.Millisecond):
+		t.Error("check done not working - crawl should be done")
+	}
+}
+
+func TestStop(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.IgnoreRobots = true
+	})
+
+	logBuffer := testutil.GetLogBuffer()
+
+	// a host that never finishes responding
+	hit := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit <- true
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	session := NewCrawlSession(30)
+	session.Start()
+	session.Submit(NewPage(server.URL, server.URL, 0, nil))
+
+	select {
+	case <-hit:
+	case <-time.After(2 * time.Second):
+		t.Fatal("crawl never started")
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		session.Stop()
+		stopped <- true
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Error("session did not stop while a crawl was in flight")
+	}
+
+	t.Log(logBuffer.String())
+
+	if session.Submit(NewPage(server.URL+"/other", server.URL, 0, nil)) {
+		t.Error("stopped session should not accept new pages")
 	}
 }
 
-func TestGetHostChannel(t *testing.T) {
+func TestGetHostQueue(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.IgnoreRobots = true
+	})
 
 	tests := []struct {
 		name          string
@@ -223,13 +376,13 @@ func TestGetHostChannel(t *testing.T) {
 		errorExpected bool
 	}{
 		{
-			name: "success_new_chan",
+			name: "success_new_queue",
 			page: &Page{
 				URL: "https://www.google.com",
 			},
 		},
 		{
-			name: "success_existing_chan",
+			name: "success_existing_queue",
 			page: &Page{
 				URL: "https://www.google.com",
 			},
@@ -249,7 +402,8 @@ func TestGetHostChannel(t *testing.T) {
 			logBuffer := testutil.GetLogBuffer()
 
 			session := NewCrawlSession(3)
-			hostChan, e := session.GetHostChannel(test.page)
+			hostQueue, e := session.GetHostQueue(test.page)
+			session.Stop()
 
 			t.Log(logBuffer.String())
 
@@ -261,12 +415,12 @@ func TestGetHostChannel(t *testing.T) {
 				if e != nil {
 					t.Errorf("unexpected error - %s", e)
 				}
-				if hostChan == nil {
+				if hostQueue == nil {
 					t.Error("missing expected return value")
 				}
 				domain, _ := GetURLDomain(test.page.URL)
-				if _, ok := session.HostChannels[domain]; !ok {
-					t.Error("expected host channel not in host channel map")
+				if _, ok := session.HostQueues[domain]; !ok {
+					t.Error("expected host queue not in host queue map")
 				}
 			}
 		})
@@ -334,6 +488,11 @@ func TestCrawl(t *testing.T) {
 		},
 	}
 
+	// reject every child found, so only the page under test is crawled
+	withConfig(t, func(conf *config.Config) {
+		conf.MaxDepth = 1
+	})
+
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 
@@ -342,30 +501,26 @@ func TestCrawl(t *testing.T) {
 			server := testutil.GetTestServer(test.path, http.StatusOK, test.pageContent, map[string]string{"Content-Type": "text/html"})
 			defer server.Close()
 
+			// children found go through the session's filter, so it's started too
 			session := NewCrawlSession(3)
+			session.Start()
+			defer session.Stop()
 			session.PendingURLs.Add(1)
 
 			domain, _ := GetURLDomain(server.URL)
-			session.HostChannels[domain] = make(chan *Page)
+			queue := NewFIFOFrontier()
+			session.HostQueues[domain] = queue
 
 			if test.isSeen {
 				session.SeenContent.Add(util.Hash(test.pageContent), 1)
 			}
 
-			go session.CrawlDomainURLs(domain, session.HostChannels[domain])
-
-			go func() {
-				for {
-					<-session.ToBeFiltered
-				}
-			}()
+			go session.CrawlDomainURLs(domain, queue)
 
 			url := fmt.Sprintf("%s%s", server.URL, test.path)
 			page := NewPage(url, url, 0, nil)
 
-			go func() {
-				session.HostChannels[domain] <- page
-			}()
+			queue.Push(page)
 
 			<-session.DoneChan
 
@@ -377,8 +532,8 @@ func TestCrawl(t *testing.T) {
 				}
 			}
 
-			if len(session.VisitedURLs.data) != len(test.expectedVisited) {
-				t.Errorf("visited links mismatch.\n- received: %d\n- expected: %d", len(session.VisitedURLs.data), len(test.expectedVisited))
+			if session.VisitedURLs.Len() != len(test.expectedVisited) {
+				t.Errorf("visited links mismatch.\n- received: %d\n- expected: %d", session.VisitedURLs.Len(), len(test.expectedVisited))
 			}
 
 			for _, expected := range test.expectedChildren {
@@ -478,3 +633,186 @@ func TestFetchPageBody(t *testing.T) {
 		})
 	}
 }
+
+func TestCrawlRobotsDirectives(t *testing.T) {
+	pages := map[string]string{
+		"/":  `<a href="/a">a</a><a href="/b" rel="nofollow">b</a>`,
+		"/a": `<a href="/c">c</a>`,
+		"/b": `<a href="/d">d</a>`,
+	}
+
+	var hitsMutex sync.Mutex
+	var hits []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitsMutex.Lock()
+		hits = append(hits, r.URL.Path)
+		hitsMutex.Unlock()
+
+		if r.URL.Path == "/a" {
+			w.Header().Add("X-Robots-Tag", "otherbot: noarchive")
+			w.Header().Add("X-Robots-Tag", "noindex, nofollow")
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><body>%s</body></html>", pages[r.URL.Path])
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		honorNofollow bool
+		expectedHits  []string
+	}{
+		// the nofollow link, and the link in the page whose header says nofollow, are recorded but never fetched
+		{name: "success_honored", honorNofollow: true, expectedHits: []string{"/", "/a"}},
+		{name: "success_ignored", expectedHits: []string{"/", "/a", "/b", "/c", "/d"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hits = nil
+			_, seed := crawlFixture(t, server.URL, fixtureConfig(func(conf *config.Config) {
+				conf.HonorNofollow = test.honorNofollow
+			}))
+
+			if fmt.Sprint(hits) != fmt.Sprint(test.expectedHits) {
+				t.Errorf("hits mismatch.\n- received: %v\n- expected: %v", hits, test.expectedHits)
+			}
+
+			if len(seed.Children) != 2 {
+				t.Fatalf("seed children count mismatch.\n- received: %d\n- expected: 2", len(seed.Children))
+			}
+			if !test.honorNofollow {
+				return
+			}
+			a, b := seed.Children[0], seed.Children[1]
+			if !a.NoIndex || !a.NoFollow || fmt.Sprint(a.Robots) != "[noindex nofollow]" {
+				t.Errorf("header directives not recorded.\n- received: %v", a.Robots)
+			}
+			if len(a.Children) != 1 || !a.Children[0].IsNoFollow() {
+				t.Error("link in nofollow page not recorded as nofollow")
+			}
+			if !b.IsNoFollow() || b.ContentHash != "" {
+				t.Error("nofollow link crawled")
+			}
+			if seed.NoIndex || seed.NoFollow {
+				t.Error("seed wrongly marked by its children's directives")
+			}
+		})
+	}
+}
+
+func TestCrawlFetchInfo(t *testing.T) {
+	seedBody := `<html><body><a href="/old">old</a><a href="/missing">missing</a><a href="/image">image</a></body></html>`
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, seedBody)
+	})
+	mux.Handle("/old", http.RedirectHandler("/moved", http.StatusMovedPermanently))
+	mux.Handle("/moved", http.RedirectHandler("/new", http.StatusFound))
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html>new</html>")
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte{1, 2, 3, 4})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	_, seed := crawlFixture(t, server.URL)
+
+	tests := []struct {
+		page                  *Page
+		expectedStatus        int
+		expectedContentType   string
+		expectedSize          int64
+		expectedRedirects     []string
+		expectedFinalURL      string
+		expectedErrorContains string
+	}{
+		{
+			page:                seed,
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/html; charset=utf-8",
+			expectedSize:        int64(len(seedBody)),
+			expectedFinalURL:    server.URL + "/",
+		},
+		{
+			page:                seed.Children[0],
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/html",
+			expectedSize:        int64(len("<html>new</html>")),
+			expectedRedirects:   []string{server.URL + "/old", server.URL + "/moved"},
+			expectedFinalURL:    server.URL + "/new",
+		},
+		{
+			page:                  seed.Children[1],
+			expectedStatus:        http.StatusNotFound,
+			expectedContentType:   "text/plain; charset=utf-8",
+			expectedSize:          int64(len("404 page not found\n")),
+			expectedFinalURL:      server.URL + "/missing",
+			expectedErrorContains: "status code [404]",
+		},
+		{
+			page:                  seed.Children[2],
+			expectedStatus:        http.StatusOK,
+			expectedContentType:   "image/png",
+			expectedSize:          4,
+			expectedFinalURL:      server.URL + "/image",
+			expectedErrorContains: "no html",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.page.URL, func(t *testing.T) {
+			fetch := test.page.Fetch
+			if fetch == nil {
+				t.Fatal("fetch not recorded")
+			}
+
+			if fetch.StatusCode != test.expectedStatus || fetch.ContentType != test.expectedContentType || fetch.Size != test.expectedSize {
+				t.Errorf("response mismatch.\n- received: %d %s %d\n- expected: %d %s %d", fetch.StatusCode, fetch.ContentType,
+					fetch.Size, test.expectedStatus, test.expectedContentType, test.expectedSize)
+			}
+			if fetch.Header.Get("Content-Type") != test.expectedContentType {
+				t.Errorf("headers not recorded - %v", fetch.Header)
+			}
+			if fmt.Sprint(fetch.RedirectChain) != fmt.Sprint(test.expectedRedirects) || fetch.FinalURL != test.expectedFinalURL {
+				t.Errorf("redirects mismatch.\n- received: %v %s\n- expected: %v %s", fetch.RedirectChain, fetch.FinalURL,
+					test.expectedRedirects, test.expectedFinalURL)
+			}
+			if fetch.FetchedAt.IsZero() || fetch.Latency <= 0 {
+				t.Errorf("timing not recorded - [%s] [%s]", fetch.FetchedAt, fetch.Latency)
+			}
+
+			if test.expectedErrorContains == "" && fetch.Error != "" {
+				t.Errorf("unexpected error - %s", fetch.Error)
+			}
+			if !strings.Contains(fetch.Error, test.expectedErrorContains) {
+				t.Errorf("error mismatch.\n- received: %s\n- expected to contain: %s", fetch.Error, test.expectedErrorContains)
+			}
+		})
+	}
+}
+
+func TestFetchPageUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	session := NewCrawlSession(3)
+	response, fetch, e := session.FetchPage(url)
+	if e == nil || response != nil {
+		t.Fatal("expected error fetching from closed server")
+	}
+	if fetch == nil || fetch.Error != e.Error() || fetch.StatusCode != 0 || fetch.FetchedAt.IsZero() {
+		t.Errorf("failed fetch not recorded - %+v", fetch)
+	}
+}
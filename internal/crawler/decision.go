@@ -0,0 +1,190 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	logger "webcrawler/logger"
+)
+
+// the stages a url is decided at - whether its link is added to the page tree, then whether its page is crawled
+const (
+	StageLink  = "link"
+	StageCrawl = "crawl"
+)
+
+// the rules urls are rejected by, named in decisions, policy errors and the rejection report
+const (
+	RuleEmptyURL         = "empty_url"
+	RuleInvalidURL       = "invalid_url"
+	RuleNoLinkText       = "no_link_text"
+	RuleDuplicateLink    = "duplicate_link"
+	RuleIgnoreIfContains = "ignore_if_contains"
+	RuleScope            = "scope"
+	RuleOutOfScope       = "out_of_scope"
+	RuleRecordOnly       = "record_only"
+	RuleNoFollow         = "nofollow"
+	RuleMaxDepth         = "max_depth"
+	RuleBlacklist        = "blacklist"
+	RuleRobots           = "robots"
+	RuleVisited          = "visited"
+	RuleContentSeen      = "content_seen"
+	RuleRedirectVisited  = "redirect_visited"
+	RuleHostBudget       = "host_budget"
+)
+
+// maxRejectionExamples bounds how many urls the rejection report keeps for each rule, so it stays small however large the crawl
+const maxRejectionExamples = 10
+
+// Decision is the outcome of a rule for a url - accepted, or rejected and why
+type Decision struct {
+	URL      string `json:"url"`
+	Stage    string `json:"stage"`
+	Rule     string `json:"rule"`
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// String describes the decision in a line, e.g. for explaining it
+func (d Decision) String() string {
+	if d.Accepted {
+		return fmt.Sprintf("%-6s %-20s accepted", d.Stage, d.Rule)
+	}
+	return fmt.Sprintf("%-6s %-20s REJECTED - %s", d.Stage, d.Rule, d.Reason)
+}
+
+// newDecision creates the decision of a rule from the error it rejected the url with, accepting it if there's none
+func newDecision(stage string, rule string, url string, e error) Decision {
+	decision := Decision{URL: url, Stage: stage, Rule: rule, Accepted: e == nil}
+	if e != nil {
+		decision.Reason = e.Error()
+	}
+	return decision
+}
+
+// decisionFor creates the overall decision on a url from the error it was rejected with, if any - naming
+// the rule that rejected it, or the kind of error, e.g. "parse", when it was rejected by none
+func decisionFor(stage string, url string, e error) Decision {
+	if e == nil {
+		return newDecision(stage, "", url, nil)
+	}
+	return newDecision(stage, RejectionRule(e), url, e)
+}
+
+// RejectionRule returns the rule a url was rejected by, or the kind of the error if it wasn't a rule, e.g. "parse"
+func RejectionRule(e error) string {
+	var crawlError *CrawlError
+	if errors.As(e, &crawlError) && crawlError.Rule != "" {
+		return crawlError.Rule
+	}
+	return ErrorKind(e)
+}
+
+// RuleRejections counts the urls a rule rejected at a stage, keeping a few of them as examples
+type RuleRejections struct {
+	Stage    string   `json:"stage"`
+	Rule     string   `json:"rule"`
+	Count    int      `json:"count"`
+	Examples []string `json:"examples"`
+}
+
+// DecisionLog records the decisions made on every url of a crawl - counting those accepted at each stage, and
+// those rejected by each rule - safe for use by the session's goroutines at once
+type DecisionLog struct {
+	mutex    sync.Mutex
+	accepted map[string]int
+	rejected map[string]*RuleRejections
+}
+
+// NewDecisionLog creates and returns a pointer to a new, empty DecisionLog
+func NewDecisionLog() *DecisionLog {
+	return &DecisionLog{accepted: map[string]int{}, rejected: map[string]*RuleRejections{}}
+}
+
+// Record adds a decision to the log
+func (l *DecisionLog) Record(decision Decision) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if decision.Accepted {
+		l.accepted[decision.Stage]++
+		return
+	}
+
+	key := decision.Stage + "/" + decision.Rule
+	rejections, ok := l.rejected[key]
+	if !ok {
+		rejections = &RuleRejections{Stage: decision.Stage, Rule: decision.Rule}
+		l.rejected[key] = rejections
+	}
+	rejections.Count++
+	if len(rejections.Examples) < maxRejectionExamples {
+		rejections.Examples = append(rejections.Examples, decision.URL)
+	}
+}
+
+// Accepted returns how many urls were accepted at the given stage
+func (l *DecisionLog) Accepted(stage string) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.accepted[stage]
+}
+
+// Rejections returns how many urls each rule rejected, the rules rejecting most first
+func (l *DecisionLog) Rejections() (rejections []RuleRejections) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, rule := range l.rejected {
+		copied := *rule
+		copied.Examples = append([]string{}, rule.Examples...)
+		rejections = append(rejections, copied)
+	}
+	sort.Slice(rejections, func(i, j int) bool {
+		if rejections[i].Count != rejections[j].Count {
+			return rejections[i].Count > rejections[j].Count
+		}
+		if rejections[i].Stage != rejections[j].Stage {
+			return rejections[i].Stage < rejections[j].Stage
+		}
+		return rejections[i].Rule < rejections[j].Rule
+	})
+	return
+}
+
+// reject records why a page wasn't crawled, on the page and in the session's decision log
+func (c *CrawlSession) reject(page *Page, e error) {
+	c.fail(page, e)
+	c.Decisions.Record(decisionFor(StageCrawl, page.URL, e))
+}
+
+// LogRejectionReport logs how many urls were accepted at each stage, and how many each rule rejected, with a few examples of each
+func (c *CrawlSession) LogRejectionReport() {
+	logger.Infof("[%d] links added to the page tree, [%d] pages accepted for crawling",
+		c.Decisions.Accepted(StageLink), c.Decisions.Accepted(StageCrawl))
+
+	for _, rejections := range c.Decisions.Rejections() {
+		examples := fmt.Sprint(rejections.Examples)
+		if rejections.Count > len(rejections.Examples) {
+			examples = fmt.Sprintf("%v ...", rejections.Examples)
+		}
+		logger.Infof("[%d] urls rejected at [%s] stage by rule [%s] - %s", rejections.Count, rejections.Stage, rejections.Rule, examples)
+	}
+}
+
+// Explain evaluates a url against the current config as a link found at the given depth, with link text, and then
+// as a page to crawl, returning the decision of every rule in the order they're made - the url is crawled if all of
+// them accept it, and otherwise rejected by the first that doesn't. The host's robots.txt is fetched if it's honoured
+func (c *CrawlSession) Explain(url string, depth int) (decisions []Decision, e error) {
+	link, e := CanonicalizeURL(url)
+	if e != nil {
+		return nil, e
+	}
+
+	decisions = EvaluateLinkRules(link, link, nil)
+
+	page := NewPage(link, link, depth, nil)
+	decisions = append(decisions, page.EvaluateCrawlRules(c.VisitedURLs, c.SeenContent, c.GetRobots(page.URL))...)
+	return
+}
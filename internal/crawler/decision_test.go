@@ -0,0 +1,213 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	config "webcrawler/config/crawler"
+)
+
+func TestCheckValidLinkRule(t *testing.T) {
+	children := []*Page{{URL: "https://www.example.com/about"}}
+
+	tests := []struct {
+		name         string
+		url          string
+		linkText     string
+		expectedRule string
+	}{
+		{name: "success_valid", url: "https://www.example.com/contact", linkText: "contact"},
+		{name: "success_empty_url", url: "", linkText: "contact", expectedRule: RuleEmptyURL},
+		{name: "success_no_link_text", url: "https://www.example.com/contact", expectedRule: RuleNoLinkText},
+		{name: "success_duplicate", url: "https://www.example.com/about", linkText: "about", expectedRule: RuleDuplicateLink},
+		{name: "success_ignored", url: "https://cdn.example.com/app.js", linkText: "app", expectedRule: RuleIgnoreIfContains},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := CheckValidLink(test.url, test.linkText, children)
+
+			if test.expectedRule == "" {
+				if e != nil {
+					t.Errorf("unexpected error - %s", e)
+				}
+				return
+			}
+			if !errors.Is(e, ErrPolicy) || RejectionRule(e) != test.expectedRule {
+				t.Errorf("rule mismatch.\n- received: %s (%v)\n- expected: %s", RejectionRule(e), e, test.expectedRule)
+			}
+		})
+	}
+}
+
+func TestCheckCrawlableRule(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.BlacklistedURLs = []string{"https://blocked.example.com"}
+	})
+	conf := config.Get()
+
+	tests := []struct {
+		name         string
+		page         *Page
+		visited      bool
+		robots       string
+		expectedRule string
+	}{
+		{name: "success_crawlable", page: NewPage("https://www.example.com/a", "a", 0, nil)},
+		{name: "success_record_only", page: &Page{URL: "https://www.example.com/a", LinkKind: LinkCanonical, RecordOnly: true}, expectedRule: RuleRecordOnly},
+		{name: "success_nofollow", page: &Page{URL: "https://www.example.com/a", Rel: []string{RelNoFollow}}, expectedRule: RuleNoFollow},
+		{name: "success_max_depth", page: NewPage("https://www.example.com/a", "a", conf.MaxDepth, nil), expectedRule: RuleMaxDepth},
+		{name: "success_blacklist", page: NewPage("https://blocked.example.com/a", "a", 0, nil), expectedRule: RuleBlacklist},
+		{name: "success_robots", page: NewPage("https://www.example.com/private", "a", 0, nil), robots: "User-agent: *\nDisallow: /private", expectedRule: RuleRobots},
+		{name: "success_visited", page: NewPage("https://www.example.com/a", "a", 0, nil), visited: true, expectedRule: RuleVisited},
+		{name: "fail_url_form", page: &Page{URL: "http://%zz"}, expectedRule: RuleInvalidURL},
+		{name: "fail_no_host", page: NewPage("notaurl", "a", 0, nil), expectedRule: RuleInvalidURL},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			session := NewCrawlSession(3)
+			if test.visited {
+				session.VisitedURLs.Add(test.page.URLHash, 1)
+			}
+			var robots *Robots
+			if test.robots != "" {
+				robots = ParseRobots(strings.NewReader(test.robots))
+			}
+
+			e := test.page.CheckCrawlable(session.VisitedURLs, session.SeenContent, robots)
+
+			if test.expectedRule == "" {
+				if e != nil {
+					t.Errorf("unexpected error - %s", e)
+				}
+				return
+			}
+			if RejectionRule(e) != test.expectedRule {
+				t.Errorf("rule mismatch.\n- received: %s (%v)\n- expected: %s", RejectionRule(e), e, test.expectedRule)
+			}
+
+			// every rule is evaluated, the first to reject the page being the one CheckCrawlable names
+			decisions := test.page.EvaluateCrawlRules(session.VisitedURLs, session.SeenContent, robots)
+			if len(decisions) != len(crawlRules) {
+				t.Fatalf("decision count mismatch.\n- received: %d\n- expected: %d", len(decisions), len(crawlRules))
+			}
+			for _, decision := range decisions {
+				if !decision.Accepted {
+					if decision.Reason != e.Error() {
+						t.Errorf("first rejection mismatch.\n- received: %s\n- expected: %s", decision.Reason, e)
+					}
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.IgnoreRobots = true
+	})
+	conf := config.Get()
+
+	tests := []struct {
+		name             string
+		url              string
+		depth            int
+		expectedRejected []string
+	}{
+		{name: "success_crawled", url: "https://www.example.com/about"},
+		{name: "success_ignored", url: "https://www.example.com/style.css", expectedRejected: []string{RuleIgnoreIfContains}},
+		{name: "success_too_deep", url: "https://www.example.com/about", depth: conf.MaxDepth, expectedRejected: []string{RuleMaxDepth}},
+		{name: "success_ignored_and_too_deep", url: "https://www.example.com/style.css", depth: conf.MaxDepth, expectedRejected: []string{RuleIgnoreIfContains, RuleMaxDepth}},
+		{name: "success_invalid_url", url: "notaurl", expectedRejected: []string{RuleInvalidURL}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decisions, e := NewCrawlSession(3).Explain(test.url, test.depth)
+			if e != nil {
+				t.Fatalf("unexpected error - %s", e)
+			}
+
+			if len(decisions) != len(linkRules)+len(crawlRules) {
+				t.Errorf("decision count mismatch.\n- received: %d\n- expected: %d", len(decisions), len(linkRules)+len(crawlRules))
+			}
+
+			var rejected []string
+			for _, decision := range decisions {
+				if !decision.Accepted {
+					rejected = append(rejected, decision.Rule)
+				}
+			}
+			if fmt.Sprint(rejected) != fmt.Sprint(test.expectedRejected) {
+				t.Errorf("rejections mismatch.\n- received: %v\n- expected: %v", rejected, test.expectedRejected)
+			}
+		})
+	}
+
+	if _, e := NewCrawlSession(3).Explain("http://%zz", 0); !errors.Is(e, ErrParse) {
+		t.Errorf("unexpected result.\n- received: %v\n- expected: parse error", e)
+	}
+}
+
+func TestCrawlRejectionReport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/a">a</a><a href="/a">a again</a><a href="/b">b</a><a href="/style.css">style</a><a href="/c"></a>`)
+		case "/a", "/b":
+			fmt.Fprint(w, `<p>same</p><a href="/deep">deep</a>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	session, _ := crawlFixture(t, server.URL, fixtureConfig(func(conf *config.Config) {
+		conf.MaxDepth = 2
+	}))
+
+	// "/a" and "/b" are accepted as well as the seed, but "/b" has the same content as "/a", and "/deep" is too deep
+	if accepted := session.Decisions.Accepted(StageCrawl); accepted != 3 {
+		t.Errorf("accepted pages mismatch.\n- received: %d\n- expected: %d", accepted, 3)
+	}
+	if accepted := session.Decisions.Accepted(StageLink); accepted != 3 {
+		t.Errorf("accepted links mismatch.\n- received: %d\n- expected: %d", accepted, 3)
+	}
+
+	counts := map[string]int{}
+	for _, rejections := range session.Decisions.Rejections() {
+		counts[rejections.Stage+"/"+rejections.Rule] = rejections.Count
+		if len(rejections.Examples) != rejections.Count {
+			t.Errorf("examples mismatch for [%s] - %v", rejections.Rule, rejections.Examples)
+		}
+	}
+	expected := map[string]int{
+		"link/" + RuleDuplicateLink:    1,
+		"link/" + RuleIgnoreIfContains: 1,
+		"link/" + RuleNoLinkText:       1,
+		"crawl/" + RuleMaxDepth:        1,
+		"crawl/" + RuleContentSeen:     1,
+	}
+	if fmt.Sprint(counts) != fmt.Sprint(expected) {
+		t.Errorf("rejections mismatch.\n- received: %v\n- expected: %v", counts, expected)
+	}
+
+	// pages rejected for crawling keep the rule that rejected them
+	rules := map[string]string{}
+	session.walkPages(func(page *Page) {
+		if page.Error != nil {
+			rules[strings.TrimPrefix(page.URL, server.URL)] = RejectionRule(page.Error)
+		}
+	})
+	expectedRules := map[string]string{"/deep": RuleMaxDepth, "/b": RuleContentSeen}
+	if fmt.Sprint(rules) != fmt.Sprint(expectedRules) {
+		t.Errorf("page rules mismatch.\n- received: %v\n- expected: %v", rules, expectedRules)
+	}
+}
@@ -0,0 +1,162 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	logger "webcrawler/logger"
+)
+
+// kinds of error a page can fail with, matched with errors.Is against a CrawlError
+var (
+	ErrNetwork     = errors.New("network error")
+	ErrTimeout     = errors.New("timeout")
+	ErrHTTPStatus  = errors.New("http status error")
+	ErrContentType = errors.New("content type rejected")
+	ErrTooLarge    = errors.New("body too large")
+	ErrRedirect    = errors.New("redirect error")
+	ErrParse       = errors.New("parse error")
+	ErrPolicy      = errors.New("rejected by policy")
+)
+
+// errorKinds names each kind of error, for summaries and checkpoints
+var errorKinds = []struct {
+	kind error
+	name string
+}{
+	{kind: ErrNetwork, name: "network"},
+	{kind: ErrTimeout, name: "timeout"},
+	{kind: ErrHTTPStatus, name: "http_status"},
+	{kind: ErrContentType, name: "content_type"},
+	{kind: ErrTooLarge, name: "too_large"},
+	{kind: ErrRedirect, name: "redirect"},
+	{kind: ErrParse, name: "parse"},
+	{kind: ErrPolicy, name: "policy"},
+}
+
+// CrawlError is why a page, or a url, couldn't be crawled - its kind, one of the Err* kinds above, and
+// the error that caused it, which errors.Is and errors.As both see through to, e.g. a net.Error
+type CrawlError struct {
+	Kind error
+	URL  string
+	Err  error
+
+	// the rule that rejected the url, e.g. "max_depth", for policy errors
+	Rule string
+
+	// the status code the page was answered with, for http status errors
+	StatusCode int
+}
+
+// Error returns the message of the error that caused the crawl error
+func (e *CrawlError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns both the crawl error's kind and its cause
+func (e *CrawlError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// ErrorKind returns the name of the kind of a crawl error, e.g. "timeout", or "unknown" if it isn't one
+func ErrorKind(e error) string {
+	for _, kind := range errorKinds {
+		if errors.Is(e, kind.kind) {
+			return kind.name
+		}
+	}
+	return "unknown"
+}
+
+// errorKindNamed returns the kind of crawl error of the given name, or nil if there's none
+func errorKindNamed(name string) error {
+	for _, kind := range errorKinds {
+		if kind.name == name {
+			return kind.kind
+		}
+	}
+	return nil
+}
+
+// fetchErrorKind decides the kind of an error met fetching a page - a redirect that couldn't be
+// followed, a timeout, or any other network error
+func fetchErrorKind(e error) error {
+	if errors.Is(e, ErrTooManyRedirects) || errors.Is(e, ErrRedirectLoop) {
+		return ErrRedirect
+	}
+
+	var netError net.Error
+	if errors.Is(e, context.DeadlineExceeded) || errors.As(e, &netError) && netError.Timeout() {
+		return ErrTimeout
+	}
+	return ErrNetwork
+}
+
+// policyError creates and logs the error for why a page was rejected by one of the crawl's rules, e.g. for being too deep
+func policyError(url string, rule string, format string, args ...any) error {
+	e := &CrawlError{Kind: ErrPolicy, Rule: rule, URL: url, Err: fmt.Errorf("page [%s] not crawlable - "+format, append([]any{url}, args...)...)}
+	logger.Info(e.Error())
+	return e
+}
+
+// ErrorSummary counts the pages of the session's trees that failed to be fetched or parsed, or were rejected by the
+// crawl's rules, by the kind of error they ended with - http status errors are counted per status code, e.g. "http_status [404]"
+func (c *CrawlSession) ErrorSummary() map[string][]*Page {
+	summary := map[string][]*Page{}
+	c.walkPages(func(page *Page) {
+		if page.Error == nil {
+			return
+		}
+
+		key := ErrorKind(page.Error)
+		var crawlError *CrawlError
+		if errors.As(page.Error, &crawlError) && crawlError.StatusCode != 0 {
+			key = fmt.Sprintf("%s [%d]", key, crawlError.StatusCode)
+		}
+		summary[key] = append(summary[key], page)
+	})
+	return summary
+}
+
+// LogErrorSummary logs how many pages failed with each kind of error, most common first, with a few examples of each
+func (c *CrawlSession) LogErrorSummary() {
+	summary := c.ErrorSummary()
+
+	keys := make([]string, 0, len(summary))
+	for key := range summary {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(summary[keys[i]]) != len(summary[keys[j]]) {
+			return len(summary[keys[i]]) > len(summary[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	for _, key := range keys {
+		var examples []string
+		for _, page := range summary[key] {
+			if len(examples) == 3 {
+				examples = append(examples, "...")
+				break
+			}
+			examples = append(examples, page.URL)
+		}
+		logger.Infof("[%d] pages %s, [%s] errors - %s", len(summary[key]), summaryOutcome(summary[key][0].Error), key, strings.Join(examples, ", "))
+	}
+}
+
+// summaryOutcome describes what became of the pages that ended with the given kind of error - fetch failures
+// aren't crawled, while policy rejections are never fetched, and parse errors may have been crawled in part
+func summaryOutcome(e error) string {
+	switch {
+	case errors.Is(e, ErrPolicy):
+		return "rejected by the crawl's rules"
+	case errors.Is(e, ErrParse):
+		return "not fully parsed"
+	}
+	return "failed to fetch"
+}
@@ -0,0 +1,146 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	config "webcrawler/config/crawler"
+	testutil "webcrawler/test/util"
+)
+
+func TestFetchPageErrorKinds(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	mux.Handle("/loop", http.RedirectHandler("/loop", http.StatusFound))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name               string
+		url                string
+		expectedKind       error
+		expectedKindName   string
+		expectedCause      error
+		expectedStatusCode int
+	}{
+		{name: "http_status", url: server.URL + "/missing", expectedKind: ErrHTTPStatus, expectedKindName: "http_status", expectedStatusCode: 404},
+		{name: "content_type", url: server.URL + "/image", expectedKind: ErrContentType, expectedKindName: "content_type"},
+		{name: "timeout", url: server.URL + "/slow", expectedKind: ErrTimeout, expectedKindName: "timeout"},
+		{name: "redirect", url: server.URL + "/loop", expectedKind: ErrRedirect, expectedKindName: "redirect", expectedCause: ErrRedirectLoop},
+		{name: "network", url: closed.URL, expectedKind: ErrNetwork, expectedKindName: "network"},
+		{name: "parse", url: "http://%zz", expectedKind: ErrParse, expectedKindName: "parse"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			session := NewCrawlSession(3)
+			session.Client.Timeout = 50 * time.Millisecond
+
+			_, _, e := session.FetchPage(test.url)
+
+			if !errors.Is(e, test.expectedKind) || ErrorKind(e) != test.expectedKindName {
+				t.Errorf("error kind mismatch.\n- received: %s (%v)\n- expected: %s", ErrorKind(e), e, test.expectedKindName)
+			}
+			if test.expectedCause != nil && !errors.Is(e, test.expectedCause) {
+				t.Errorf("cause not wrapped.\n- received: %v\n- expected: %v", e, test.expectedCause)
+			}
+
+			var crawlError *CrawlError
+			if !errors.As(e, &crawlError) || crawlError.URL != test.url || crawlError.StatusCode != test.expectedStatusCode {
+				t.Errorf("crawl error mismatch - %+v", crawlError)
+			}
+		})
+	}
+
+	// the network error behind a failed fetch can still be inspected
+	_, _, e := NewCrawlSession(3).FetchPage(closed.URL)
+	var opError *net.OpError
+	if !errors.As(e, &opError) {
+		t.Errorf("network cause not wrapped - %v", e)
+	}
+}
+
+func TestCheckCrawlablePolicyError(t *testing.T) {
+	session := NewCrawlSession(3)
+	page := NewPage("https://www.google.com", "google", config.Get().MaxDepth, nil)
+
+	e := page.CheckCrawlable(session.VisitedURLs, session.SeenContent, nil)
+	if !errors.Is(e, ErrPolicy) || !strings.Contains(e.Error(), "max depth reached") {
+		t.Errorf("unexpected result.\n- received: %v\n- expected: max depth policy error", e)
+	}
+
+	page = NewPage("https://www.google.com", "google", 0, nil)
+	if e = page.CheckCrawlable(session.VisitedURLs, session.SeenContent, nil); e != nil {
+		t.Errorf("unexpected error - %s", e)
+	}
+}
+
+func TestErrorSummary(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/a">a</a><a href="/b">b</a><a href="/gone">gone</a><a href="/also-gone">also gone</a><a href="/broken">broken</a><a href="/image">image</a>`)
+		case "/a", "/b":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<a href="%s/deep">too deep</a>`, r.URL.Path)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	session, _ := crawlFixture(t, server.URL, fixtureConfig(func(conf *config.Config) {
+		conf.MaxDepth = 2
+		conf.Retry.MaxAttempts = 1
+	}))
+
+	counts := map[string]int{}
+	for key, pages := range session.ErrorSummary() {
+		counts[key] = len(pages)
+	}
+	expected := map[string]int{"http_status [404]": 2, "http_status [500]": 1, "content_type": 1, "policy": 2}
+	if fmt.Sprint(counts) != fmt.Sprint(expected) {
+		t.Errorf("summary mismatch.\n- received: %v\n- expected: %v", counts, expected)
+	}
+
+	// fetch failures and policy rejections are logged as such
+	logBuffer := testutil.GetLogBuffer()
+	session.LogErrorSummary()
+	for _, line := range []string{"[2] pages failed to fetch, [http_status [404]] errors", "[2] pages rejected by the crawl's rules, [policy] errors"} {
+		if !strings.Contains(logBuffer.String(), line) {
+			t.Errorf("error summary log missing [%s] - %s", line, logBuffer.String())
+		}
+	}
+
+	// the errors are kept through a checkpoint, by kind
+	restored := NewCrawlSession(3)
+	if _, e := restored.Restore(session.Snapshot()); e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+	restoredCounts := map[string]int{}
+	for key, pages := range restored.ErrorSummary() {
+		restoredCounts[key] = len(pages)
+	}
+	if fmt.Sprint(restoredCounts) != fmt.Sprint(expected) {
+		t.Errorf("restored summary mismatch.\n- received: %v\n- expected: %v", restoredCounts, expected)
+	}
+}
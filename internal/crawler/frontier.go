@@ -0,0 +1,289 @@
+package crawler
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// frontier strategies that can be configured
+const (
+	FrontierBFS       = "bfs"
+	FrontierDFS       = "dfs"
+	FrontierBestFirst = "best_first"
+)
+
+// Frontier holds the pages waiting to be crawled on a host and decides the order they're crawled in.
+// Pushing never blocks, so routing is never held up by a slow host
+type Frontier interface {
+	// Push adds a page to the frontier
+	Push(page *Page) error
+
+	// Pop removes and returns the next page to crawl, or nil if the frontier is empty
+	Pop() *Page
+
+	// Peek returns the next page to crawl without removing it, or nil if the frontier is empty
+	Peek() *Page
+
+	// Len returns the number of pages in the frontier
+	Len() int
+
+	// Ready returns a channel that receives after pages are pushed, for waiting on an empty frontier
+	Ready() <-chan struct{}
+}
+
+// ScoreFunc rates how soon a page should be crawled by a best-first frontier - the higher the score, the sooner
+type ScoreFunc func(page *Page) float64
+
+// DefaultScore prefers shallower pages, and then those with shorter URLs
+func DefaultScore(page *Page) float64 {
+	return -float64(page.Depth*1000 + len(page.URL))
+}
+
+// NewFrontier creates a frontier for the given strategy. The score func is only used for best-first frontiers
+func NewFrontier(strategy string, score ScoreFunc) (Frontier, error) {
+	switch strategy {
+	case FrontierBFS, "":
+		return NewFIFOFrontier(), nil
+	case FrontierDFS:
+		return NewLIFOFrontier(), nil
+	case FrontierBestFirst:
+		if score == nil {
+			score = DefaultScore
+		}
+		return NewPriorityFrontier(score), nil
+	}
+	return nil, fmt.Errorf("unknown frontier strategy [%s]", strategy)
+}
+
+// WaitForPage blocks until the given frontier holds at least one page. Returns the
+// context's error if it's cancelled while waiting
+func WaitForPage(ctx context.Context, frontier Frontier) error {
+	for frontier.Len() == 0 {
+		select {
+		case <-frontier.Ready():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// frontierSignal wakes whoever is waiting on an empty frontier
+type frontierSignal struct {
+	ready chan struct{}
+}
+
+func newFrontierSignal() frontierSignal {
+	return frontierSignal{ready: make(chan struct{}, 1)}
+}
+
+func (s frontierSignal) notify() {
+	// a wake-up already pending covers this push too
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Ready returns a channel that receives after pages are pushed
+func (s frontierSignal) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// FIFOFrontier crawls pages in the order they were found, i.e. breadth first
+type FIFOFrontier struct {
+	frontierSignal
+	mutex sync.Mutex
+	pages []*Page
+}
+
+// NewFIFOFrontier creates, inits and returns a new FIFOFrontier struct
+func NewFIFOFrontier() *FIFOFrontier {
+	return &FIFOFrontier{frontierSignal: newFrontierSignal()}
+}
+
+// Push adds a page to the back of the queue
+func (f *FIFOFrontier) Push(page *Page) error {
+	f.mutex.Lock()
+	f.pages = append(f.pages, page)
+	f.mutex.Unlock()
+	f.notify()
+	return nil
+}
+
+// Pop removes and returns the page at the front of the queue
+func (f *FIFOFrontier) Pop() *Page {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if len(f.pages) == 0 {
+		return nil
+	}
+	page := f.pages[0]
+	f.pages[0] = nil
+	f.pages = f.pages[1:]
+	return page
+}
+
+// Peek returns the page at the front of the queue
+func (f *FIFOFrontier) Peek() *Page {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if len(f.pages) == 0 {
+		return nil
+	}
+	return f.pages[0]
+}
+
+// Len returns the number of pages in the queue
+func (f *FIFOFrontier) Len() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.pages)
+}
+
+// LIFOFrontier crawls the most recently found pages first, i.e. depth first
+type LIFOFrontier struct {
+	frontierSignal
+	mutex sync.Mutex
+	pages []*Page
+}
+
+// NewLIFOFrontier creates, inits and returns a new LIFOFrontier struct
+func NewLIFOFrontier() *LIFOFrontier {
+	return &LIFOFrontier{frontierSignal: newFrontierSignal()}
+}
+
+// Push adds a page to the top of the stack
+func (f *LIFOFrontier) Push(page *Page) error {
+	f.mutex.Lock()
+	f.pages = append(f.pages, page)
+	f.mutex.Unlock()
+	f.notify()
+	return nil
+}
+
+// Pop removes and returns the page at the top of the stack
+func (f *LIFOFrontier) Pop() *Page {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if len(f.pages) == 0 {
+		return nil
+	}
+	last := len(f.pages) - 1
+	page := f.pages[last]
+	f.pages[last] = nil
+	f.pages = f.pages[:last]
+	return page
+}
+
+// Peek returns the page at the top of the stack
+func (f *LIFOFrontier) Peek() *Page {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if len(f.pages) == 0 {
+		return nil
+	}
+	return f.pages[len(f.pages)-1]
+}
+
+// Len returns the number of pages in the stack
+func (f *LIFOFrontier) Len() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.pages)
+}
+
+// PriorityFrontier crawls the highest scoring page first, i.e. best first.
+// Pages with equal scores are crawled in the order they were found
+type PriorityFrontier struct {
+	frontierSignal
+	mutex sync.Mutex
+	score ScoreFunc
+	pages scoredPages
+	count uint64
+}
+
+// NewPriorityFrontier creates, inits and returns a new PriorityFrontier struct
+func NewPriorityFrontier(score ScoreFunc) *PriorityFrontier {
+	return &PriorityFrontier{
+		frontierSignal: newFrontierSignal(),
+		score:          score}
+}
+
+// Push scores a page and adds it to the frontier
+func (f *PriorityFrontier) Push(page *Page) error {
+	scored := &scoredPage{page: page, score: f.score(page)}
+
+	f.mutex.Lock()
+	scored.order = f.count
+	f.count++
+	heap.Push(&f.pages, scored)
+	f.mutex.Unlock()
+	f.notify()
+	return nil
+}
+
+// Pop removes and returns the highest scoring page
+func (f *PriorityFrontier) Pop() *Page {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if len(f.pages) == 0 {
+		return nil
+	}
+	return heap.Pop(&f.pages).(*scoredPage).page
+}
+
+// Peek returns the highest scoring page
+func (f *PriorityFrontier) Peek() *Page {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if len(f.pages) == 0 {
+		return nil
+	}
+	return f.pages[0].page
+}
+
+// Len returns the number of pages in the frontier
+func (f *PriorityFrontier) Len() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.pages)
+}
+
+type scoredPage struct {
+	page  *Page
+	score float64
+	order uint64
+}
+
+// scoredPages implements heap.Interface, highest score first
+type scoredPages []*scoredPage
+
+func (s scoredPages) Len() int { return len(s) }
+
+func (s scoredPages) Less(i, j int) bool {
+	if s[i].score != s[j].score {
+		return s[i].score > s[j].score
+	}
+	return s[i].order < s[j].order
+}
+
+func (s scoredPages) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s *scoredPages) Push(x any) { *s = append(*s, x.(*scoredPage)) }
+
+func (s *scoredPages) Pop() any {
+	old := *s
+	last := old[len(old)-1]
+	old[len(old)-1] = nil
+	*s = old[:len(old)-1]
+	return last
+}
@@ -0,0 +1,241 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	config "webcrawler/config/crawler"
+	testutil "webcrawler/test/util"
+)
+
+func TestFrontierOrder(t *testing.T) {
+	// pushed in this order, scored by the number in the url
+	urls := []string{"https://a.com/2", "https://a.com/1", "https://a.com/3", "https://a.com/1"}
+	score := func(page *Page) float64 {
+		return float64(page.URL[len(page.URL)-1] - '0')
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		expected []string
+	}{
+		{
+			name:     "bfs",
+			strategy: FrontierBFS,
+			expected: []string{"https://a.com/2", "https://a.com/1", "https://a.com/3", "https://a.com/1"},
+		},
+		{
+			name:     "dfs",
+			strategy: FrontierDFS,
+			expected: []string{"https://a.com/1", "https://a.com/3", "https://a.com/1", "https://a.com/2"},
+		},
+		{
+			name:     "best_first",
+			strategy: FrontierBestFirst,
+			expected: []string{"https://a.com/3", "https://a.com/2", "https://a.com/1", "https://a.com/1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frontier, e := NewFrontier(test.strategy, score)
+			if e != nil {
+				t.Fatalf("unexpected error - %s", e)
+			}
+
+			pushed := make([]*Page, len(urls))
+			for i, url := range urls {
+				pushed[i] = NewPage(url, url, 0, nil)
+				frontier.Push(pushed[i])
+			}
+
+			if frontier.Len() != len(urls) {
+				t.Errorf("frontier length mismatch.\n- received: %d\n- expected: %d", frontier.Len(), len(urls))
+			}
+
+			var popped []string
+			for frontier.Len() > 0 {
+				next := frontier.Peek()
+				page := frontier.Pop()
+				if page != next {
+					t.Errorf("peeked page [%s] not the one popped [%s]", next.URL, page.URL)
+				}
+				popped = append(popped, page.URL)
+			}
+
+			if strings.Join(popped, " ") != strings.Join(test.expected, " ") {
+				t.Errorf("order mismatch.\n- received: %v\n- expected: %v", popped, test.expected)
+			}
+
+			if frontier.Pop() != nil || frontier.Peek() != nil {
+				t.Error("empty frontier returned a page")
+			}
+		})
+	}
+}
+
+func TestNewFrontier(t *testing.T) {
+	if _, e := NewFrontier("random", nil); e == nil {
+		t.Error("expected error for unknown strategy")
+	}
+
+	// best first falls back to the default score
+	frontier, e := NewFrontier(FrontierBestFirst, nil)
+	if e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+	frontier.Push(NewPage("https://a.com/deep/page", "deep", 2, nil))
+	frontier.Push(NewPage("https://a.com/page/longer", "longer", 1, nil))
+	frontier.Push(NewPage("https://a.com/page", "page", 1, nil))
+
+	if page := frontier.Pop(); page.URL != "https://a.com/page" {
+		t.Errorf("default score mismatch.\n- received: %s\n- expected: https://a.com/page", page.URL)
+	}
+}
+
+func TestCrawlOrder(t *testing.T) {
+	// a binary tree of 15 pages - "/" is page 0, and page n links to pages 2n+1 and 2n+2
+	pageCount := 15
+	fixture := testutil.GetFixtureSite(pageCount, 2)
+	defer fixture.Close()
+
+	// prefers even pages, and then lower numbers
+	evenFirst := func(page *Page) float64 {
+		n := 0
+		fmt.Sscanf(page.URL[strings.LastIndex(page.URL, "/")+1:], "%d", &n)
+		if n%2 == 0 {
+			return float64(100 - n)
+		}
+		return float64(-n)
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		score    ScoreFunc
+		expected []int
+	}{
+		{
+			name:     "bfs",
+			strategy: FrontierBFS,
+			expected: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14},
+		},
+		{
+			name:     "dfs",
+			strategy: FrontierDFS,
+			expected: []int{0, 2, 6, 14, 13, 5, 12, 11, 1, 4, 10, 9, 3, 8, 7},
+		},
+		{
+			name:     "best_first",
+			strategy: FrontierBestFirst,
+			score:    evenFirst,
+			expected: []int{0, 2, 6, 14, 1, 4, 10, 3, 8, 5, 12, 7, 9, 11, 13},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var hitsMutex sync.Mutex
+			var hits []int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := 0
+				fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/page/"), "%d", &n)
+				hitsMutex.Lock()
+				hits = append(hits, n)
+				hitsMutex.Unlock()
+				fixture.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			crawlFixture(t, server.URL,
+				fixtureConfig(func(conf *config.Config) {
+					conf.Frontier = test.strategy
+				}),
+				fixtureSession(func(session *CrawlSession) {
+					session.Score = test.score
+				}))
+
+			if fmt.Sprint(hits) != fmt.Sprint(test.expected) {
+				t.Errorf("visit order mismatch.\n- received: %v\n- expected: %v", hits, test.expected)
+			}
+		})
+	}
+}
+
+// staleFrontier answers its first peek with a page other than the one popped next,
+// as a frontier would if a page were pushed between the two
+type staleFrontier struct {
+	*FIFOFrontier
+	peeked *Page
+	stale  bool
+}
+
+func (f *staleFrontier) Peek() *Page {
+	if f.stale {
+		f.stale = false
+		return f.peeked
+	}
+	return f.FIFOFrontier.Peek()
+}
+
+func TestCrawlDomainURLsPopsAnotherPage(t *testing.T) {
+	server := testutil.GetTestServer("/", http.StatusOK, "<html>page</html>", map[string]string{"Content-Type": "text/html"})
+	defer server.Close()
+
+	tests := []struct {
+		name             string
+		peekedURL        string
+		attempts         int
+		hostSpent        bool
+		expectedAttempts int
+	}{
+		// a page that couldn't be read back was peeked at, so it isn't the page popped and dropped
+		{name: "success_unreadable_peeked", expectedAttempts: 1},
+		// the host's budget is spent, but the page popped is a retry, which its first attempt paid for
+		{name: "success_retry_popped", peekedURL: server.URL + "/other", attempts: 1, hostSpent: true, expectedAttempts: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withFixtureConfig(t, func(conf *config.Config) {
+				conf.MaxPagesPerHost = 1
+			})
+
+			session := NewCrawlSession(3)
+			session.Start()
+			defer session.Stop()
+
+			domain, _ := GetURLDomain(server.URL)
+			if test.hostSpent {
+				session.Budget.reservePage(domain)
+			}
+
+			queue := &staleFrontier{FIFOFrontier: NewFIFOFrontier(), stale: true}
+			if test.peekedURL != "" {
+				queue.peeked = NewPage(test.peekedURL, test.peekedURL, 0, nil)
+			}
+			page := NewPage(server.URL+"/", server.URL, 0, nil)
+			page.Attempts = test.attempts
+			session.PendingURLs.Add(1)
+			queue.Push(page)
+
+			go session.CrawlDomainURLs(domain, queue)
+
+			select {
+			case <-session.DoneChan:
+			case <-time.After(5 * time.Second):
+				t.Fatal("crawl never finished")
+			}
+
+			if page.Error != nil || page.Attempts != test.expectedAttempts {
+				t.Errorf("popped page not crawled.\n- received: %d attempts, error %v\n- expected: %d attempts, no error",
+					page.Attempts, page.Error, test.expectedAttempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package crawler
+
+import (
+	"strings"
+	crawlerConfig "webcrawler/config/crawler"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// kinds of link that can be extracted from a page, configured to be followed or only recorded
+const (
+	LinkAnchor      = "a"
+	LinkArea        = "area"
+	LinkIframe      = "iframe"
+	LinkFrame       = "frame"
+	LinkMetaRefresh = "meta_refresh"
+	LinkNext        = "link_next"
+	LinkPrev        = "link_prev"
+	LinkAlternate   = "link_alternate"
+	LinkCanonical   = "canonical"
+)
+
+// foundLink is a link found in an element of a page, before it's resolved against the page's url
+type foundLink struct {
+	kind      string
+	element   string
+	attribute string
+	link      string
+
+	// the relations given in the element's rel attribute, e.g. "nofollow"
+	rel []string
+
+	// text to describe the link by when its element has none of its own
+	text string
+}
+
+// extractLink finds the link in a start tag of any kind except anchors, whose text
+// has to be collected up to their end tag. Returns false if the tag holds no link
+func extractLink(token *html.Token) (found foundLink, ok bool) {
+	found.element = token.Data
+	found.rel = getRel(token)
+
+	switch token.DataAtom {
+	case atom.Area:
+		found.kind, found.attribute = LinkArea, "href"
+		found.text = firstNonEmpty(getAttribute(token, "alt"), getAttribute(token, "aria-label"), getAttribute(token, "title"))
+
+	case atom.Iframe, atom.Frame:
+		found.kind, found.attribute = LinkIframe, "src"
+		if token.DataAtom == atom.Frame {
+			found.kind = LinkFrame
+		}
+		found.text = getAttribute(token, "title")
+		if found.text == "" {
+			found.text = getAttribute(token, "name")
+		}
+
+	case atom.Link:
+		found.attribute = "href"
+		found.text = getAttribute(token, "title")
+
+		// a link may have several relations, the first recognised here decides its kind
+		for _, kind := range []struct {
+			rel  string
+			kind string
+		}{
+			{rel: "canonical", kind: LinkCanonical},
+			{rel: "next", kind: LinkNext},
+			{rel: "prev", kind: LinkPrev},
+			{rel: "previous", kind: LinkPrev},
+			{rel: "alternate", kind: LinkAlternate},
+		} {
+			if containsString(found.rel, kind.rel) {
+				found.kind = kind.kind
+				break
+			}
+		}
+		if found.kind == "" {
+			return found, false
+		}
+
+	case atom.Meta:
+		if !strings.EqualFold(getAttribute(token, "http-equiv"), "refresh") {
+			return found, false
+		}
+		found.kind, found.attribute = LinkMetaRefresh, "content"
+		found.link = parseMetaRefresh(getAttribute(token, "content"))
+		return found, found.link != ""
+
+	default:
+		return found, false
+	}
+
+	found.link = getAttribute(token, found.attribute)
+	return found, found.link != ""
+}
+
+// parseMetaRefresh returns the url of a meta refresh's content, e.g. "/next" from "5; url='/next'"
+func parseMetaRefresh(content string) string {
+	_, link, found := strings.Cut(content, ";")
+	if !found {
+		_, link, found = strings.Cut(content, ",")
+	}
+	if !found {
+		return ""
+	}
+
+	link = strings.TrimSpace(link)
+	if len(link) > 3 && strings.EqualFold(link[:3], "url") {
+		if rest := strings.TrimSpace(link[3:]); strings.HasPrefix(rest, "=") {
+			link = strings.TrimSpace(rest[1:])
+		}
+	}
+	return strings.Trim(link, `"'`)
+}
+
+// anchorText decides the text of an anchor - the text it holds or, for links without any, e.g. logos
+// and icon buttons, the alt text of the images it holds, or failing that its aria-label or title
+func anchorText(token *html.Token, text string, imageAlts []string) string {
+	return firstNonEmpty(
+		normalizeLinkText(text),
+		normalizeLinkText(strings.Join(imageAlts, " ")),
+		normalizeLinkText(getAttribute(token, "aria-label")),
+		normalizeLinkText(getAttribute(token, "title")))
+}
+
+// normalizeLinkText collapses each run of whitespace in a link's text to a single space, and trims it
+func normalizeLinkText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// LinkPolicy decides whether links of a kind are followed, only recorded in the site tree, or ignored
+func LinkPolicy(kind string) (follow bool, record bool) {
+	config := crawlerConfig.Get()
+	if containsString(config.FollowLinks, kind) {
+		return true, true
+	}
+	return false, containsString(config.RecordLinks, kind)
+}
+
+func getAttribute(token *html.Token, key string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return strings.TrimSpace(attr.Val)
+		}
+	}
+	return ""
+}
+
+// getRel returns the lowercased relations given in a tag's rel attribute
+func getRel(token *html.Token) []string {
+	return strings.Fields(strings.ToLower(getAttribute(token, "rel")))
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
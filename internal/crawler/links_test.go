@@ -0,0 +1,247 @@
+package crawler
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	config "webcrawler/config/crawler"
+)
+
+func TestParseMetaRefresh(t *testing.T) {
+	tests := []struct {
+		content        string
+		expectedResult string
+	}{
+		{content: "5; url=/next", expectedResult: "/next"},
+		{content: "0;URL='https://example.com/'", expectedResult: "https://example.com/"},
+		{content: `0; Url = "/quoted"`, expectedResult: "/quoted"},
+		{content: "0, url=/comma", expectedResult: "/comma"},
+		{content: "3; /bare", expectedResult: "/bare"},
+		{content: "urlpath/page", expectedResult: ""},
+		{content: "10", expectedResult: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.content, func(t *testing.T) {
+			if link := parseMetaRefresh(test.content); link != test.expectedResult {
+				t.Errorf("unexpected result.\n- received: %s\n- expected %s", link, test.expectedResult)
+			}
+		})
+	}
+}
+
+func TestGetChildrenLinkKinds(t *testing.T) {
+	body := `<html><head>
+		<link rel="canonical" href="/canonical">
+		<link rel="next" href="/page/3">
+		<link rel="prev" href="/page/1">
+		<link rel="alternate" hreflang="fr" href="/fr/" title="French">
+		<link rel="stylesheet" href="/style">
+		<meta http-equiv="Refresh" content="5; url=/refreshed">
+		<meta name="description" content="0; url=/not-a-refresh">
+	</head><body>
+		<a href="/anchor">Anchor</a>
+		<map><area href="/area" alt="Area"></map>
+		<iframe src="/iframe" title="Frame"></iframe>
+		<frameset><frame src="/frame" name="side"></frameset>
+		<a href="/canonical">Canonical again</a>
+	</body></html>`
+
+	expected := []string{
+		"/canonical canonical link href [canonical]",
+		"/page/3 link_next link href [link_next]",
+		"/page/1 link_prev link href [link_prev]",
+		"/fr/ link_alternate link href French",
+		"/refreshed meta_refresh meta content [meta_refresh]",
+		"/anchor a a href Anchor",
+		"/area area area href Area",
+		"/iframe iframe iframe src Frame",
+		"/frame frame frame src side",
+	}
+
+	tests := []struct {
+		name               string
+		follow             []string
+		record             []string
+		expectedChildren   []string
+		expectedRecordOnly []string
+	}{
+		{
+			name:               "success_follow_and_record",
+			follow:             []string{LinkAnchor, LinkArea, LinkIframe, LinkFrame, LinkMetaRefresh, LinkNext, LinkPrev},
+			record:             []string{LinkAlternate, LinkCanonical},
+			expectedChildren:   expected,
+			expectedRecordOnly: []string{"/fr/"},
+		},
+		{
+			name:               "success_record_only",
+			record:             []string{LinkAnchor, LinkArea, LinkIframe, LinkFrame, LinkMetaRefresh, LinkNext, LinkPrev, LinkAlternate, LinkCanonical},
+			expectedChildren:   expected,
+			expectedRecordOnly: []string{"/canonical", "/page/3", "/page/1", "/fr/", "/refreshed", "/anchor", "/area", "/iframe", "/frame"},
+		},
+		{
+			name:             "success_anchors_only",
+			follow:           []string{LinkAnchor},
+			expectedChildren: []string{"/anchor a a href Anchor", "/canonical a a href Canonical again"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withConfig(t, func(conf *config.Config) {
+				conf.FollowLinks, conf.RecordLinks = test.follow, test.record
+			})
+
+			page := NewPage("https://example.com/page/2", "page", 0, nil)
+			children := page.GetChildren(io.NopCloser(strings.NewReader(body)), 1)
+
+			var received, recordOnly []string
+			for _, child := range children {
+				path := strings.TrimPrefix(child.URL, "https://example.com")
+				received = append(received, fmt.Sprintf("%s %s %s %s %s", path, child.LinkKind, child.Element, child.Attribute, child.LinkText))
+				if child.RecordOnly {
+					recordOnly = append(recordOnly, path)
+				}
+			}
+
+			if strings.Join(received, "\n") != strings.Join(test.expectedChildren, "\n") {
+				t.Errorf("children mismatch.\n- received: %q\n- expected: %q", received, test.expectedChildren)
+			}
+			if fmt.Sprint(recordOnly) != fmt.Sprint(test.expectedRecordOnly) {
+				t.Errorf("record only children mismatch.\n- received: %v\n- expected: %v", recordOnly, test.expectedRecordOnly)
+			}
+		})
+	}
+}
+
+func TestGetChildrenRobotsDirectives(t *testing.T) {
+	tests := []struct {
+		name             string
+		body             string
+		expectedRobots   []string
+		expectedNoIndex  bool
+		expectedNoFollow bool
+		expectedRel      map[string][]string
+	}{
+		{
+			name: "success_rel",
+			body: `<a href="/plain">plain</a>
+				<a href="/nofollow" rel="NoFollow UGC">nofollow</a>
+				<map><area href="/area" alt="area" rel="nofollow"></map>`,
+			expectedRel: map[string][]string{"/plain": nil, "/nofollow": {"nofollow", "ugc"}, "/area": {"nofollow"}},
+		},
+		{
+			name:             "success_meta_robots",
+			body:             `<head><meta name="Robots" content="noindex, NOFOLLOW"></head><a href="/a">a</a>`,
+			expectedRobots:   []string{"noindex", "nofollow"},
+			expectedNoIndex:  true,
+			expectedNoFollow: true,
+			expectedRel:      map[string][]string{"/a": nil},
+		},
+		{
+			name:             "success_meta_none_for_this_crawler",
+			body:             `<meta name="webcrawler" content="none"><meta name="otherbot" content="noarchive">`,
+			expectedRobots:   []string{"none"},
+			expectedNoIndex:  true,
+			expectedNoFollow: true,
+		},
+		{
+			name:            "success_meta_noindex_only",
+			body:            `<meta name="robots" content="noindex"><meta name="description" content="nofollow">`,
+			expectedRobots:  []string{"noindex"},
+			expectedNoIndex: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := NewPage("https://example.com/", "example", 0, nil)
+			children := page.GetChildren(io.NopCloser(strings.NewReader(test.body)), 1)
+
+			if fmt.Sprint(page.Robots) != fmt.Sprint(test.expectedRobots) {
+				t.Errorf("robots mismatch.\n- received: %v\n- expected: %v", page.Robots, test.expectedRobots)
+			}
+			if page.NoIndex != test.expectedNoIndex || page.NoFollow != test.expectedNoFollow {
+				t.Errorf("directives mismatch.\n- received: noindex %t, nofollow %t\n- expected: noindex %t, nofollow %t",
+					page.NoIndex, page.NoFollow, test.expectedNoIndex, test.expectedNoFollow)
+			}
+
+			if len(children) != len(test.expectedRel) {
+				t.Fatalf("children count mismatch.\n- received: %d\n- expected: %d", len(children), len(test.expectedRel))
+			}
+			for _, child := range children {
+				path := strings.TrimPrefix(child.URL, "https://example.com")
+				if fmt.Sprint(child.Rel) != fmt.Sprint(test.expectedRel[path]) {
+					t.Errorf("rel of [%s] mismatch.\n- received: %v\n- expected: %v", path, child.Rel, test.expectedRel[path])
+				}
+
+				// a page that's nofollow makes every link in it nofollow
+				if nofollow := containsString(test.expectedRel[path], RelNoFollow) || test.expectedNoFollow; child.IsNoFollow() != nofollow {
+					t.Errorf("nofollow of [%s] mismatch.\n- received: %t\n- expected: %t", path, child.IsNoFollow(), nofollow)
+				}
+			}
+		})
+	}
+}
+
+func TestGetChildrenLinkText(t *testing.T) {
+	body := `<html><body>
+		<a href="/text">  Read
+			the   <b>docs</b>
+		</a>
+		<a href="/logo"><img src="/logo.png" alt="Home"></a>
+		<a href="/logos"><img src="/a.png" alt=" Home "><img src="/b.png"><img src="/c.png" alt="Page"/></a>
+		<a href="/aria" aria-label="Open  menu"><svg></svg></a>
+		<a href="/title" title="Search"><i class="icon"></i></a>
+		<a href="/text-wins" title="Title"><img src="/x.png" alt="Alt">Text</a>
+		<a href="/empty-alt" title="Title"><img src="/x.png" alt=""></a>
+		<a href="/nothing"><img src="/x.png"></a>
+		<map><area href="/area" aria-label="Region"></map>
+	</body></html>`
+
+	expected := []string{
+		"/text Read the docs",
+		"/logo Home",
+		"/logos Home Page",
+		"/aria Open menu",
+		"/title Search",
+		"/text-wins Text",
+		"/empty-alt Title",
+	}
+
+	tests := []struct {
+		name             string
+		keepTextless     bool
+		expectedChildren []string
+	}{
+		{
+			name:             "success_textless_dropped",
+			expectedChildren: append(append([]string{}, expected...), "/area Region"),
+		},
+		{
+			name:             "success_textless_kept",
+			keepTextless:     true,
+			expectedChildren: append(append([]string{}, expected...), "/nothing ", "/area Region"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withConfig(t, func(conf *config.Config) {
+				conf.KeepTextlessLinks = test.keepTextless
+			})
+
+			page := NewPage("https://example.com/", "example", 0, nil)
+			children := page.GetChildren(io.NopCloser(strings.NewReader(body)), 1)
+
+			var received []string
+			for _, child := range children {
+				received = append(received, fmt.Sprintf("%s %s", strings.TrimPrefix(child.URL, "https://example.com"), child.LinkText))
+			}
+			if strings.Join(received, "\n") != strings.Join(test.expectedChildren, "\n") {
+				t.Errorf("children mismatch.\n- received: %q\n- expected: %q", received, test.expectedChildren)
+			}
+		})
+	}
+}
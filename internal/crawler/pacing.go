@@ -0,0 +1,171 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	crawlerConfig "webcrawler/config/crawler"
+	logger "webcrawler/logger"
+)
+
+// HostPacer spaces out the hits made to a single host. The gap between hits is the larger of the
+// configured domain delay and the host's robots.txt Crawl-delay, and a host asking us to back off
+// via Retry-After holds all further hits until the time it asked for
+type HostPacer struct {
+	mutex      sync.Mutex
+	crawlDelay time.Duration
+	lastHit    time.Time
+	notBefore  time.Time
+}
+
+// NewHostPacer creates, inits and returns a new HostPacer struct
+func NewHostPacer(crawlDelay time.Duration) *HostPacer {
+	return &HostPacer{
+		crawlDelay: crawlDelay,
+	}
+}
+
+// Delay returns the minimum gap currently enforced between hits to the host
+func (p *HostPacer) Delay() time.Duration {
+	configured := time.Duration(crawlerConfig.Get().DomainHitDelayMS) * time.Millisecond
+	if p.crawlDelay > configured {
+		return p.crawlDelay
+	}
+	return configured
+}
+
+// Wait blocks until the host may be hit again, then records the hit.
+// Returns the context's error if it's cancelled while waiting
+func (p *HostPacer) Wait(ctx context.Context) error {
+	for {
+		p.mutex.Lock()
+		next := p.lastHit.Add(p.Delay())
+		if p.notBefore.After(next) {
+			next = p.notBefore
+		}
+
+		wait := time.Until(next)
+		if wait <= 0 {
+			p.lastHit = time.Now()
+			p.mutex.Unlock()
+			return nil
+		}
+		p.mutex.Unlock()
+
+		// the host may ask us to back off further while we sleep, so check again afterwards
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Hit records a hit to the host made now, pushing back the next allowed hit
+func (p *HostPacer) Hit() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.lastHit = time.Now()
+}
+
+// BackOff holds all further hits to the host for the given duration
+func (p *HostPacer) BackOff(duration time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	until := time.Now().Add(duration)
+	if until.After(p.notBefore) {
+		p.notBefore = until
+	}
+}
+
+// HostPacers holds the pacer of every host seen during a crawl
+type HostPacers struct {
+	mutex sync.Mutex
+	data  map[string]*HostPacer
+}
+
+// NewHostPacers creates, inits and returns a new HostPacers struct
+func NewHostPacers() *HostPacers {
+	return &HostPacers{
+		data: make(map[string]*HostPacer),
+	}
+}
+
+// Get returns the pacer for the given host, calling create to make one if it doesn't exist yet
+func (h *HostPacers) Get(host string, create func() *HostPacer) *HostPacer {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	pacer, ok := h.data[host]
+	if !ok {
+		pacer = create()
+		h.data[host] = pacer
+	}
+	return pacer
+}
+
+// GetHostPacer returns the pacer for the host of the given URL, taking the host's robots.txt Crawl-delay into account
+func (c *CrawlSession) GetHostPacer(urlString string) *HostPacer {
+	parsed, e := url.Parse(urlString)
+	if e != nil {
+		logger.Errorf("could not get host pacer, error parsing url [%s]", urlString)
+		return NewHostPacer(0)
+	}
+
+	return c.Pacers.Get(parsed.Host, func() *HostPacer {
+		crawlDelay := c.GetRobots(urlString).CrawlDelay(crawlerConfig.Get().UserAgent)
+		if crawlDelay > 0 {
+			logger.Infof("host [%s] requests a crawl delay of [%s]", parsed.Host, crawlDelay)
+		}
+		return NewHostPacer(crawlDelay)
+	})
+}
+
+// ParseRetryAfter reads a Retry-After header value, given either as a number of seconds or an HTTP date.
+// Returns zero if the value is missing or can't be understood
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, e := strconv.Atoi(value); e == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, e := http.ParseTime(value); e == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// applyRetryAfter makes the host of the given URL back off when a 429 or 503 response asks it to
+func (c *CrawlSession) applyRetryAfter(urlString string, response *http.Response) {
+	if response.StatusCode != http.StatusTooManyRequests && response.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+
+	retryAfter := ParseRetryAfter(response.Header.Get("Retry-After"), time.Now())
+	if retryAfter <= 0 {
+		return
+	}
+
+	maxRetryAfter := time.Duration(crawlerConfig.Get().MaxRetryAfterSecs) * time.Second
+	if maxRetryAfter > 0 && retryAfter > maxRetryAfter {
+		logger.Warnf("retry-after [%s] for url [%s] capped at [%s]", retryAfter, urlString, maxRetryAfter)
+		retryAfter = maxRetryAfter
+	}
+
+	logger.Infof("host of url [%s] asked to back off for [%s]", urlString, retryAfter)
+	c.GetHostPacer(urlString).BackOff(retryAfter)
+}
@@ -0,0 +1,144 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+	config "webcrawler/config/crawler"
+	testutil "webcrawler/test/util"
+)
+
+func TestHostPacerDelay(t *testing.T) {
+	tests := []struct {
+		name          string
+		configuredMS  int
+		crawlDelay    time.Duration
+		expectedDelay time.Duration
+	}{
+		{
+			name:          "success_configured_larger",
+			configuredMS:  500,
+			crawlDelay:    100 * time.Millisecond,
+			expectedDelay: 500 * time.Millisecond,
+		},
+		{
+			name:          "success_crawl_delay_larger",
+			configuredMS:  100,
+			crawlDelay:    2 * time.Second,
+			expectedDelay: 2 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withConfig(t, func(conf *config.Config) {
+				conf.DomainHitDelayMS = test.configuredMS
+			})
+			pacer := NewHostPacer(test.crawlDelay)
+
+			if pacer.Delay() != test.expectedDelay {
+				t.Errorf("delay mismatch.\n- received: %s\n- expected: %s", pacer.Delay(), test.expectedDelay)
+			}
+		})
+	}
+}
+
+func TestHostPacerWait(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.DomainHitDelayMS = 100
+	})
+
+	pacer := NewHostPacer(0)
+
+	start := time.Now()
+	pacer.Wait(context.Background())
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("first hit should not wait, waited [%s]", elapsed)
+	}
+
+	start = time.Now()
+	pacer.Wait(context.Background())
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("second hit should wait for the domain delay, waited [%s]", elapsed)
+	}
+
+	pacer.BackOff(300 * time.Millisecond)
+	start = time.Now()
+	pacer.Wait(context.Background())
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("hit after back off should wait for the retry-after, waited [%s]", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 11, 7, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		value          string
+		expectedResult time.Duration
+	}{
+		{
+			name:           "success_seconds",
+			value:          "120",
+			expectedResult: 2 * time.Minute,
+		},
+		{
+			name:           "success_http_date",
+			value:          "Thu, 07 Nov 2024 12:00:30 GMT",
+			expectedResult: 30 * time.Second,
+		},
+		{
+			name:           "success_past_date",
+			value:          "Thu, 07 Nov 2024 11:00:00 GMT",
+			expectedResult: 0,
+		},
+		{
+			name:           "success_empty",
+			value:          "",
+			expectedResult: 0,
+		},
+		{
+			name:           "success_negative",
+			value:          "-5",
+			expectedResult: 0,
+		},
+		{
+			name:           "success_garbage",
+			value:          "soon",
+			expectedResult: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			result := ParseRetryAfter(test.value, now)
+
+			if result != test.expectedResult {
+				t.Errorf("result mismatch.\n- received: %s\n- expected: %s", result, test.expectedResult)
+			}
+		})
+	}
+}
+
+func TestFetchPageBodyRetryAfter(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.IgnoreRobots = true
+	})
+
+	server := testutil.GetTestServer("/", http.StatusTooManyRequests, "", map[string]string{"Retry-After": "2"})
+	defer server.Close()
+
+	session := NewCrawlSession(3)
+	if _, e := session.FetchPageBody(server.URL); e == nil {
+		t.Error("missing expected error")
+	}
+
+	pacer := session.GetHostPacer(server.URL)
+	remaining := time.Until(pacer.notBefore)
+	if remaining <= time.Second || remaining > 2*time.Second {
+		t.Errorf("host not backed off as requested, remaining [%s]", remaining)
+	}
+}
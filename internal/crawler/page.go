@@ -1,9 +1,12 @@
 package crawler
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
+	"time"
 	"webcrawler/internal/util"
 
 	"golang.org/x/net/html"
@@ -13,6 +16,14 @@ import (
 	logger "webcrawler/logger"
 )
 
+// documentURL returns the url a fetched page's links resolve against - the one it was redirected to if it was
+func (fetch *FetchInfo) documentURL(pageURL string) string {
+	if fetch != nil && fetch.FinalURL != "" {
+		return fetch.FinalURL
+	}
+	return pageURL
+}
+
 // Page holds data pertaining to a page in the site map of a configured seed url
 type Page struct {
 	URL         string
@@ -23,11 +34,80 @@ type Page struct {
 	Parent      *Page
 	Children    []*Page
 	Depth       int
+
+	// where the page's URL was found when not linked from its parent, e.g. a sitemap
+	Source string
+
+	// the kind of link the page was found through, and the element and attribute it was found in, e.g. "a" and "href"
+	LinkKind  string
+	Element   string
+	Attribute string
+
+	// set when links of the page's kind are recorded in the site tree, but not followed
+	RecordOnly bool
+
+	// the relations given in the rel attribute of the link the page was found through, e.g. "nofollow"
+	Rel []string
+
+	// the robots directives the page gave in its meta robots tags and X-Robots-Tag headers, e.g. "noindex". A noindex
+	// page asked to be left out of any index, so exporters should skip it, and a nofollow page asked its links not be followed
+	Robots   []string
+	NoIndex  bool
+	NoFollow bool
+
+	// what happened the last time the page was fetched, nil if it never was, and how many times it's been fetched
+	Fetch    *FetchInfo
+	Attempts int
+
+	// why the page wasn't crawled, or was only partly parsed, a *CrawlError - nil if it was crawled without trouble
+	Error error
+
+	// set once the page has been either rejected or crawled
+	Processed bool
+
+	// signalled once the page has been rejected or routed, when the crawl that found it is waiting for that
+	queued chan<- struct{}
+
+	// the page's id, given it once it has children waiting in a disk frontier, and its index among
+	// its parent's children, or among the seeds - together they find a page saved in a disk frontier
+	id    uint64
+	index int
+}
+
+// FetchInfo describes the fetch of a page, whether it succeeded or not
+type FetchInfo struct {
+	StatusCode  int         `json:"status_code,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	ContentType string      `json:"content_type,omitempty"`
+
+	// bytes of the body read, or the length the response declared if its body wasn't read, -1 if unknown
+	Size int64 `json:"size"`
+
+	// whether the body was larger than the max body size, and only read up to it
+	Truncated bool `json:"truncated,omitempty"`
+
+	// how long the response, including any redirects, took to arrive - the body may take longer to read
+	Latency   time.Duration `json:"latency"`
+	FetchedAt time.Time     `json:"fetched_at"`
+
+	// the urls redirected from, in order, before the response was got from FinalURL
+	RedirectChain []string `json:"redirect_chain,omitempty"`
+	FinalURL      string   `json:"final_url,omitempty"`
+
+	// why the page couldn't be crawled, empty if it could
+	Error string `json:"error,omitempty"`
 }
 
 // NewPage creates and returns a new page struct
 func NewPage(url string, linkText string, depth int, parent *Page) *Page {
-	url = TrimLinkVars(url)
+	// equivalent urls must hash the same, or the same page would be crawled under each of them
+	canonical, e := CanonicalizeURL(url)
+	if e != nil {
+		logger.Warnf("keeping url as is - %s", e)
+	} else {
+		url = canonical
+	}
+
 	newPage := Page{
 		URL:      url,
 		LinkText: linkText,
@@ -39,14 +119,86 @@ func NewPage(url string, linkText string, depth int, parent *Page) *Page {
 }
 
 // GetChildren finds the links in a page, uses them to contruct new
-// Page structs relating to the parent, and returns those new Page structs
+// Page structs relating to the parent, and returns those new Page structs.
+// Which kinds of link are followed, only recorded, or ignored is configured.
+// Any robots directives in the page's meta tags are recorded on the page
 func (page *Page) GetChildren(pageBody io.ReadCloser, depth int) (children []*Page) {
+	children, directives, e := page.parseChildren(pageBody, depth, page.Fetch.documentURL(page.URL), nil)
+	page.AddRobotsDirectives(directives)
+	if e != nil {
+		page.Error = e
+	}
+	return
+}
+
+// checkChild decides whether a link is valid to be added to the page tree as a child of the page - links out of
+// the seed's scope are only kept, as leaves that are never fetched, if configured
+func (page *Page) checkChild(link string, linkText string, children []*Page) error {
+	invalid := CheckValidLink(link, linkText, children)
+	if invalid == nil && !crawlerConfig.Get().RecordOutOfScope {
+		if e := CheckSeedScope(page.Seed().URL, link); e != nil {
+			invalid = linkError(link, RuleOutOfScope, e)
+		}
+	}
+	return invalid
+}
+
+// parseChildren finds the links in a page, like GetChildren, returning the robots directives of the page's
+// meta tags, and any error that cut its parsing short, rather than recording them, so the caller can choose when to.
+// Links resolve against documentURL, and the decision on each link found is passed to decide, if given
+func (page *Page) parseChildren(pageBody io.ReadCloser, depth int, documentURL string, decide func(Decision)) (children []*Page, directives []string, e error) {
 	logger.Infof("parsing page at [%s], finding children links", page.URL)
 	// split page into tokens
 	tokeniser := html.NewTokenizer(pageBody)
 
 	var linkTagStart *html.Token
 	linkTagText := ""
+	var linkTagImageAlts []string
+
+	// relative links resolve against the document's url unless a <base href> says otherwise
+	baseURL := documentURL
+	baseFound := false
+
+	addChild := func(found foundLink, linkText string) {
+		follow, record := LinkPolicy(found.kind)
+		if !record {
+			return
+		}
+
+		// improve link form if necessary
+		link, e := FixLinkForm(baseURL, found.link)
+		if e != nil {
+			logger.Errorf("problem with link [%s] - %s", link, e)
+			return
+		}
+
+		// normalized before checking for duplicates, so e.g. "/about" and "/about#team" are only added once
+		link, e = CanonicalizeURL(link)
+		if e != nil {
+			logger.Errorf("problem with link [%s] - %s", link, e)
+			return
+		}
+
+		// a link only recorded so far is followed after all if it's found again in a followed kind
+		for _, child := range children {
+			if child.URL == link && child.RecordOnly && follow {
+				child.RecordOnly = false
+			}
+		}
+
+		invalid := page.checkChild(link, linkText, children)
+		if decide != nil {
+			decide(decisionFor(StageLink, link, invalid))
+		}
+		if invalid == nil {
+			child := NewPage(link, linkText, depth, page)
+			child.LinkKind, child.Element, child.Attribute = found.kind, found.element, found.attribute
+			child.RecordOnly = !follow
+			child.Rel = found.rel
+			children = append(children, child)
+			logger.Infof("link found [%v] in [%s %s] of page [%s]", link, found.element, found.attribute, page.URL)
+		}
+	}
 
 	// scan page content and collect subpages
 	// loop until we find and error, which could also represent the end of the page stream
@@ -54,9 +206,9 @@ func (page *Page) GetChildren(pageBody io.ReadCloser, depth int) (children []*Pa
 		tokenType := tokeniser.Next()
 
 		if tokenType == html.ErrorToken {
-			e := tokeniser.Err()
-			if e != io.EOF {
-				logger.Warnf("error parsing html of page [%s] - %s", page.URL, e.Error())
+			if tokeniser.Err() != io.EOF {
+				e = &CrawlError{Kind: ErrParse, URL: page.URL, Err: fmt.Errorf("error parsing html of page [%s] - %w", page.URL, tokeniser.Err())}
+				logger.Warn(e)
 			}
 			break
 		}
@@ -67,6 +219,31 @@ func (page *Page) GetChildren(pageBody io.ReadCloser, depth int) (children []*Pa
 			linkTagText = fmt.Sprintf("%s%s", linkTagText, token.Data)
 		}
 
+		// images in a link describe it when it has no text of its own, e.g. a logo linking home
+		if linkTagStart != nil && token.DataAtom == atom.Img &&
+			(token.Type == html.StartTagToken || token.Type == html.SelfClosingTagToken) {
+			linkTagImageAlts = append(linkTagImageAlts, getAttribute(&token, "alt"))
+		}
+
+		// only the first <base> element with an href counts
+		if token.DataAtom == atom.Base && !baseFound &&
+			(token.Type == html.StartTagToken || token.Type == html.SelfClosingTagToken) {
+			if href := getLinkFromToken(&token); href != "" {
+				baseFound = true
+				if resolved, e := FixLinkForm(documentURL, href); e == nil {
+					baseURL = resolved
+					logger.Infof("base url [%s] found in page [%s]", baseURL, page.URL)
+				}
+			}
+		}
+
+		// <meta name="robots"> tags, or those naming this crawler, give the page's robots directives
+		if token.DataAtom == atom.Meta && (token.Type == html.StartTagToken || token.Type == html.SelfClosingTagToken) {
+			if name := getAttribute(&token, "name"); name != "" && robotsAgentMatches(name, crawlerConfig.Get().UserAgent) {
+				directives = append(directives, ParseRobotsDirectives(getAttribute(&token, "content"))...)
+			}
+		}
+
 		// find <a> (link) tags and extract the link & text from them
 		if token.DataAtom == atom.A {
 			switch token.Type {
@@ -83,29 +260,70 @@ func (page *Page) GetChildren(pageBody io.ReadCloser, depth int) (children []*Pa
 				}
 
 				// get link from start tag token
-				link := getLinkFromToken(linkTagStart)
-
-				// improve link form if necessary
-				link, e := FixLinkForm(page.URL, link)
-				if e != nil {
-					logger.Errorf("problem with link [%s] - %s", link, e)
-					continue
-				}
-
-				// check if link is valid to be added to the link tree
-				if IsValidLink(link, linkTagText, children) {
-					children = append(children, NewPage(link, linkTagText, depth, page))
-					logger.Infof("link found [%v] in page [%s]", link, page.URL)
-				}
+				addChild(foundLink{
+					kind:      LinkAnchor,
+					element:   "a",
+					attribute: "href",
+					link:      getLinkFromToken(linkTagStart),
+					rel:       getRel(linkTagStart)}, anchorText(linkTagStart, linkTagText, linkTagImageAlts))
 
 				linkTagStart = nil
 				linkTagText = ""
+				linkTagImageAlts = nil
+			}
+			continue
+		}
+
+		// links in other elements are taken straight from their start tags
+		if token.Type == html.StartTagToken || token.Type == html.SelfClosingTagToken {
+			if found, ok := extractLink(&token); ok {
+				linkText := normalizeLinkText(found.text)
+				if linkText == "" {
+					linkText = fmt.Sprintf("[%s]", found.kind)
+				}
+				addChild(found, linkText)
 			}
 		}
 	}
 	return
 }
 
+// AddRobotsDirectives records robots directives the page gave, marking it noindex or nofollow if they say so
+func (page *Page) AddRobotsDirectives(directives []string) {
+	for _, directive := range directives {
+		if !containsString(page.Robots, directive) {
+			page.Robots = append(page.Robots, directive)
+		}
+
+		switch directive {
+		case RobotsNoIndex:
+			page.NoIndex = true
+		case RobotsNoFollow:
+			page.NoFollow = true
+		case RobotsNone:
+			page.NoIndex, page.NoFollow = true, true
+		}
+	}
+}
+
+// Seed returns the seed of the site tree the page is in, the page itself if it's a seed
+func (page *Page) Seed() *Page {
+	seed := page
+	for seed.Parent != nil {
+		seed = seed.Parent
+	}
+	return seed
+}
+
+// IsNoFollow reports whether the page was linked as nofollow, either by the rel of its link or by the robots
+// directives of the page it was linked from. Pages taken from elsewhere, e.g. a sitemap, weren't linked at all
+func (page *Page) IsNoFollow() bool {
+	if containsString(page.Rel, RelNoFollow) {
+		return true
+	}
+	return page.Source == "" && page.Parent != nil && page.Parent.NoFollow
+}
+
 func getLinkFromToken(token *html.Token) (link string) {
 	for i := range token.Attr {
 		if token.Attr[i].Key == "href" {
@@ -116,47 +334,139 @@ func getLinkFromToken(token *html.Token) (link string) {
 }
 
 // IsCrawlable decides whether to parse a page (i.e. crawl further)
-func (page *Page) IsCrawlable(visitedURLs *ConcurrentMap, seenContent *ConcurrentMap) bool {
-	config := crawlerConfig.Get()
+func (page *Page) IsCrawlable(visitedURLs VisitedStore, seenContent VisitedStore, robots *Robots) bool {
+	return page.CheckCrawlable(visitedURLs, seenContent, robots) == nil
+}
 
-	// check max depth has not yet been reached
-	if page.Depth >= config.MaxDepth {
-		logger.Infof("page [%s] not crawlable - max depth reached", page.URL)
-		return false
+// CheckCrawlable decides whether to parse a page, like IsCrawlable, returning why not if it can't be - an
+// ErrPolicy CrawlError naming the first of the crawl rules to reject it, or an ErrParse one if its url is unreadable
+func (page *Page) CheckCrawlable(visitedURLs VisitedStore, seenContent VisitedStore, robots *Robots) error {
+	for _, rule := range crawlRules {
+		if e := page.checkRule(rule, visitedURLs, seenContent, robots); e != nil {
+			return e
+		}
 	}
+	return nil
+}
 
-	urlHost, e := GetURLDomain(page.URL)
-	if e != nil {
-		logger.Errorf("unexpected error checking page is crawlable, could not parse URL - [%s]", e)
-		return false
+// EvaluateCrawlRules runs every crawl rule against a page, in order, and returns the decision of each - the
+// page is crawlable if all of them accept it, and otherwise rejected by the first that doesn't
+func (page *Page) EvaluateCrawlRules(visitedURLs VisitedStore, seenContent VisitedStore, robots *Robots) (decisions []Decision) {
+	for _, rule := range crawlRules {
+		decisions = append(decisions, newDecision(StageCrawl, rule.name, page.URL,
+			page.checkRule(rule, visitedURLs, seenContent, robots)))
 	}
+	return
+}
 
-	// check site is not in configured blacklist
-	for _, blacklisted := range config.BlacklistedURLs {
-		blacklistedHost, e := GetURLDomain(blacklisted)
-		if e != nil {
-			logger.Errorf("could not parse blacklist URL [%s] - [%s]", blacklisted, e)
-			continue
-		}
-		if urlHost == blacklistedHost {
-			logger.Infof("page [%s] not crawlable - site blacklisted", page.URL)
-			return false
-		}
+// checkRule runs one crawl rule against the page, turning a rejection into a policy error naming the rule
+func (page *Page) checkRule(rule crawlRule, visitedURLs VisitedStore, seenContent VisitedStore, robots *Robots) error {
+	e := rule.check(page, visitedURLs, seenContent, robots)
+	if e == nil {
+		return nil
 	}
 
-	// check url has not yet been crawled
-	if visitedURLs.KeyExists(page.URLHash) {
-		logger.Infof("page [%s] not crawlable - url already visited", page.URL)
-		return false
+	// e.g. the page's url couldn't be parsed
+	var crawlError *CrawlError
+	if errors.As(e, &crawlError) {
+		return e
 	}
+	return policyError(page.URL, rule.name, "%s", e)
+}
 
-	// check if page content has already been seen, perhaps via a different URL
-	if seenContent.KeyExists(page.ContentHash) {
-		logger.Infof("page [%s] not crawlable - content already seen", page.URL)
-		return false
-	}
+// a crawlRule is one of the checks a page must pass to be crawled, returning why not if it fails
+type crawlRule struct {
+	name  string
+	check func(page *Page, visitedURLs VisitedStore, seenContent VisitedStore, robots *Robots) error
+}
+
+// crawlRules are the checks deciding whether a page is crawled, in the order they're made
+var crawlRules = []crawlRule{
+	// check the page's url can be parsed, with a host to fetch it from, before any rule that reads it - an
+	// unreadable url stays a parse error, but one naming this rule
+	{name: RuleInvalidURL, check: func(page *Page, _ VisitedStore, _ VisitedStore, _ *Robots) error {
+		_, e := GetURLDomain(page.URL)
+		if e == nil {
+			return nil
+		}
+		logger.Errorf("unexpected error checking page is crawlable, could not parse URL - [%s]", e)
+		var crawlError *CrawlError
+		if errors.As(e, &crawlError) {
+			crawlError.Rule = RuleInvalidURL
+		}
+		return e
+	}},
+
+	// check the page was found through a kind of link that's followed
+	{name: RuleRecordOnly, check: func(page *Page, _ VisitedStore, _ VisitedStore, _ *Robots) error {
+		if page.RecordOnly {
+			return fmt.Errorf("[%s] links are recorded, not followed", page.LinkKind)
+		}
+		return nil
+	}},
+
+	// check the page wasn't linked as nofollow, if those links are honoured
+	{name: RuleNoFollow, check: func(page *Page, _ VisitedStore, _ VisitedStore, _ *Robots) error {
+		if crawlerConfig.Get().HonorNofollow && page.IsNoFollow() {
+			return errors.New("linked as nofollow")
+		}
+		return nil
+	}},
+
+	// check max depth has not yet been reached
+	{name: RuleMaxDepth, check: func(page *Page, _ VisitedStore, _ VisitedStore, _ *Robots) error {
+		if page.Depth >= crawlerConfig.Get().MaxDepth {
+			return errors.New("max depth reached")
+		}
+		return nil
+	}},
+
+	// check site is not in configured blacklist
+	{name: RuleBlacklist, check: func(page *Page, _ VisitedStore, _ VisitedStore, _ *Robots) error {
+		return CheckBlacklist(page.URL)
+	}},
+
+	// check the page is within its seed's scope, e.g. on the seed's own host
+	{name: RuleOutOfScope, check: func(page *Page, _ VisitedStore, _ VisitedStore, _ *Robots) error {
+		return CheckSeedScope(page.Seed().URL, page.URL)
+	}},
+
+	// check the page is in scope, by the configured include and exclude rules - pages
+	// that didn't come from links, e.g. seeds and those listed in sitemaps, are checked here
+	{name: RuleScope, check: func(page *Page, _ VisitedStore, _ VisitedStore, _ *Robots) error {
+		return CheckScope(page.URL)
+	}},
+
+	// check the host's robots.txt allows the page to be fetched
+	{name: RuleRobots, check: func(page *Page, _ VisitedStore, _ VisitedStore, robots *Robots) error {
+		if allowed, rule := robots.IsAllowed(crawlerConfig.Get().UserAgent, page.URL); !allowed {
+			if rule != nil {
+				return fmt.Errorf("disallowed by robots.txt rule [%s]", rule)
+			}
+			return errors.New("robots.txt unreachable, host disallowed")
+		}
+		return nil
+	}},
 
-	return true
+	// check url has not yet been crawled
+	{name: RuleVisited, check: func(page *Page, visitedURLs VisitedStore, _ VisitedStore, _ *Robots) error {
+		if visitedURLs.KeyExists(page.URLHash) {
+			return errors.New("url already visited")
+		}
+		return nil
+	}},
+
+	// check if page content has already been seen, perhaps via a different URL - a page not fetched yet has no content
+	// to check, and isn't checked for "", which a bloom filter may wrongly take as seen, rejecting every such page
+	{name: RuleContentSeen, check: func(page *Page, _ VisitedStore, seenContent VisitedStore, _ *Robots) error {
+		if page.ContentHash == "" {
+			return nil
+		}
+		if seenContent.KeyExists(page.ContentHash) {
+			return errors.New("content already seen")
+		}
+		return nil
+	}},
 }
 
 // PrintTree prints the site map to the console
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,13 +13,18 @@ import (
 )
 
 func TestIsCrawlable(t *testing.T) {
+	nofollowParent := &Page{URL: "https://www.google.com", NoFollow: true}
+
 	tests := []struct {
 		name           string
 		page           Page
+		ignoreNofollow bool
 		blacklist      []string
+		robots         string
 		pageVisited    bool
 		contentSeen    bool
 		expectedResult bool
+		expectedError  bool
 	}{
 		{
 			name: "success_true",
@@ -28,6 +34,54 @@ func TestIsCrawlable(t *testing.T) {
 			},
 			expectedResult: true,
 		},
+		{
+			name: "success_false_record_only",
+			page: Page{
+				URL:        "https://www.google.com",
+				Depth:      0,
+				LinkKind:   LinkCanonical,
+				RecordOnly: true,
+			},
+			expectedResult: false,
+		},
+		{
+			name: "success_false_rel_nofollow",
+			page: Page{
+				URL:   "https://www.google.com",
+				Depth: 0,
+				Rel:   []string{"nofollow"},
+			},
+			expectedResult: false,
+		},
+		{
+			name: "success_false_parent_nofollow",
+			page: Page{
+				URL:    "https://www.google.com",
+				Depth:  0,
+				Parent: nofollowParent,
+			},
+			expectedResult: false,
+		},
+		{
+			name: "success_true_sitemap_page_of_nofollow_parent",
+			page: Page{
+				URL:    "https://www.google.com",
+				Depth:  0,
+				Parent: nofollowParent,
+				Source: "https://www.google.com/sitemap.xml",
+			},
+			expectedResult: true,
+		},
+		{
+			name: "success_true_nofollow_not_honored",
+			page: Page{
+				URL:   "https://www.google.com",
+				Depth: 0,
+				Rel:   []string{"nofollow"},
+			},
+			ignoreNofollow: true,
+			expectedResult: true,
+		},
 		{
 			name: "success_false_maxdepth",
 			page: Page{
@@ -45,6 +99,24 @@ func TestIsCrawlable(t *testing.T) {
 			blacklist:      []string{"https://www.google.com"},
 			expectedResult: false,
 		},
+		{
+			name: "success_true_robots_allowed",
+			page: Page{
+				URL:   "https://www.example.com/public",
+				Depth: 0,
+			},
+			robots:         "User-agent: *\nDisallow: /private",
+			expectedResult: true,
+		},
+		{
+			name: "success_false_robots_disallowed",
+			page: Page{
+				URL:   "https://www.example.com/private/page",
+				Depth: 0,
+			},
+			robots:         "User-agent: *\nDisallow: /private",
+			expectedResult: false,
+		},
 		{
 			name: "success_false_page_visited",
 			page: Page{
@@ -65,6 +137,16 @@ func TestIsCrawlable(t *testing.T) {
 			contentSeen:    true,
 			expectedResult: false,
 		},
+		{
+			// e.g. a bloom filter wrongly taking "" as seen
+			name: "success_true_no_content_yet",
+			page: Page{
+				URL:   "https://www.google.com",
+				Depth: 0,
+			},
+			contentSeen:    true,
+			expectedResult: true,
+		},
 		{
 			name: "fail_url_form",
 			page: Page{
@@ -79,8 +161,8 @@ func TestIsCrawlable(t *testing.T) {
 				URL:   "https://www.google.com",
 				Depth: 0,
 			},
-			blacklist:      []string{"https://www.google.com/"},
-			expectedResult: false,
+			blacklist:     []string{"https://www.google.com/"},
+			expectedError: true,
 		},
 	}
 
@@ -89,17 +171,33 @@ func TestIsCrawlable(t *testing.T) {
 
 			session := NewCrawlSession(3)
 
+			// a blacklist entry that can't be parsed makes the config invalid, rather than being skipped
+			if test.expectedError {
+				invalid := *config.Get()
+				invalid.BlacklistedURLs = test.blacklist
+				if e := invalid.Validate(); e == nil {
+					t.Errorf("invalid blacklist accepted - %v", test.blacklist)
+				}
+				return
+			}
+			withConfig(t, func(conf *config.Config) {
+				conf.HonorNofollow = !test.ignoreNofollow
+				conf.BlacklistedURLs = test.blacklist
+			})
+
 			if test.pageVisited {
 				session.VisitedURLs.Add(test.page.URLHash, 1)
 			}
 			if test.contentSeen {
 				session.SeenContent.Add(test.page.ContentHash, 1)
 			}
-			if test.blacklist != nil && len(test.blacklist) > 0 {
-				config.Get().BlacklistedURLs = append(config.Get().BlacklistedURLs, test.blacklist...)
+
+			var robots *Robots
+			if test.robots != "" {
+				robots = ParseRobots(strings.NewReader(test.robots))
 			}
 
-			result := test.page.IsCrawlable(session.VisitedURLs, session.SeenContent)
+			result := test.page.IsCrawlable(session.VisitedURLs, session.SeenContent, robots)
 
 			if test.expectedResult != result {
 				t.Errorf("result mismatch.\n- received: %t\n- expected: %t", result, test.expectedResult)
@@ -112,8 +210,8 @@ func TestIsCrawlable(t *testing.T) {
 func TestPrintTree(t *testing.T) {
 	expected := `
 
-[0] https://www.google.com                                      [1] https://images.google.com                                   
-                                                                [1] https://news.google.com                                     `
+[0] https://www.google.com/                                     [1] https://images.google.com/                                  
+                                                                [1] https://news.google.com/                                    `
 
 	parent := NewPage("https://www.google.com", "google", 0, nil)
 	children := []*Page{
@@ -136,3 +234,83 @@ func TestPrintTree(t *testing.T) {
 		t.Errorf("output mismatch.\n- received:||%s||\n- expected:||%s||", out, expected)
 	}
 }
+
+func TestGetChildren(t *testing.T) {
+	tests := []struct {
+		name             string
+		pageURL          string
+		body             string
+		expectedChildren []string
+	}{
+		{
+			name:    "success_relative_to_page",
+			pageURL: "https://example.com/docs/guide/index.html",
+			body: `<html><body>
+				<a href="../img/a.html">a</a>
+				<a href="sub/page.html">page</a>
+				<a href="//other.example.com/x">other</a>
+				<a href="/about">about</a>
+			</body></html>`,
+			expectedChildren: []string{
+				"https://example.com/docs/img/a.html",
+				"https://example.com/docs/guide/sub/page.html",
+				"https://other.example.com/x",
+				"https://example.com/about"},
+		},
+		{
+			name:    "success_base_href",
+			pageURL: "https://example.com/docs/index.html",
+			body: `<html><head><base href="https://mirror.example.com/v2/"></head><body>
+				<a href="page.html">page</a>
+				<a href="../up.html">up</a>
+				<a href="/root.html">root</a>
+			</body></html>`,
+			expectedChildren: []string{
+				"https://mirror.example.com/v2/page.html",
+				"https://mirror.example.com/up.html",
+				"https://mirror.example.com/root.html"},
+		},
+		{
+			name:    "success_relative_base_href",
+			pageURL: "https://example.com/docs/index.html",
+			body: `<html><head><base href="/v2/"/></head><body>
+				<a href="page.html">page</a>
+			</body></html>`,
+			expectedChildren: []string{"https://example.com/v2/page.html"},
+		},
+		{
+			name:    "success_first_base_href_only",
+			pageURL: "https://example.com/docs/index.html",
+			body: `<html><head><base target="_blank"><base href="/v2/"><base href="/v3/"></head><body>
+				<a href="page.html">page</a>
+			</body></html>`,
+			expectedChildren: []string{"https://example.com/v2/page.html"},
+		},
+		{
+			name:    "success_equivalent_links_added_once",
+			pageURL: "https://example.com/",
+			body: `<html><body>
+				<a href="/about">about</a>
+				<a href="/about#team">team</a>
+				<a href="HTTPS://EXAMPLE.COM:443/about">about again</a>
+			</body></html>`,
+			expectedChildren: []string{"https://example.com/about"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := NewPage(test.pageURL, test.pageURL, 0, nil)
+
+			children := page.GetChildren(io.NopCloser(strings.NewReader(test.body)), 1)
+
+			var received []string
+			for _, child := range children {
+				received = append(received, child.URL)
+			}
+			if strings.Join(received, " ") != strings.Join(test.expectedChildren, " ") {
+				t.Errorf("children mismatch.\n- received: %v\n- expected: %v", received, test.expectedChildren)
+			}
+		})
+	}
+}
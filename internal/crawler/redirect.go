@@ -0,0 +1,120 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	crawlerConfig "webcrawler/config/crawler"
+	"webcrawler/internal/util"
+	logger "webcrawler/logger"
+)
+
+// errors a fetch fails with when its redirects can't be followed to the end
+var (
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrRedirectLoop     = errors.New("redirect loop")
+)
+
+// redirectSeedKey is the context key of the url of the seed whose page is being fetched, for its redirects to be checked against
+type redirectSeedKey struct{}
+
+// withRedirectSeed returns a context for fetching a page of the given seed, so its redirects are held to the same rules as its links
+func withRedirectSeed(ctx context.Context, seedURL string) context.Context {
+	return context.WithValue(ctx, redirectSeedKey{}, seedURL)
+}
+
+// checkRedirect decides whether the client follows a redirect - never beyond the configured
+// number of hops, and never back to a url already requested earlier in the same chain. A page's
+// redirect is only followed to where its links could have led, i.e. a url that's not blacklisted,
+// in its seed's scope and allowed by robots.txt - otherwise it's refused with a policy error
+func (c *CrawlSession) checkRedirect(req *http.Request, via []*http.Request) error {
+	for _, previous := range via {
+		if previous.URL.String() == req.URL.String() {
+			return fmt.Errorf("%w - [%s] redirected back to [%s]", ErrRedirectLoop, via[len(via)-1].URL, req.URL)
+		}
+	}
+
+	if maxRedirects := crawlerConfig.Get().MaxRedirects; len(via) > maxRedirects {
+		return fmt.Errorf("%w - stopped after [%d]", ErrTooManyRedirects, maxRedirects)
+	}
+
+	// e.g. robots.txt and sitemaps, which aren't pages of a seed
+	seedURL, ok := req.Context().Value(redirectSeedKey{}).(string)
+	if !ok {
+		return nil
+	}
+
+	pageURL, target := via[0].URL.String(), req.URL.String()
+	if e := CheckBlacklist(target); e != nil {
+		return policyError(pageURL, RuleBlacklist, "redirected to [%s], %s", target, e)
+	}
+	if e := CheckSeedScope(seedURL, target); e != nil {
+		return policyError(pageURL, RuleOutOfScope, "redirected to [%s], %s", target, e)
+	}
+	if e := CheckScope(target); e != nil {
+		return policyError(pageURL, RuleScope, "redirected to [%s], %s", target, e)
+	}
+	if allowed, rule := c.GetRobots(target).IsAllowed(crawlerConfig.Get().UserAgent, target); !allowed {
+		if rule != nil {
+			return policyError(pageURL, RuleRobots, "redirected to [%s], disallowed by robots.txt rule [%s]", target, rule)
+		}
+		return policyError(pageURL, RuleRobots, "redirected to [%s], robots.txt unreachable, host disallowed", target)
+	}
+	return nil
+}
+
+// finalHash returns the hash of the canonical form of the url the fetch's response finally came from
+func (fetch *FetchInfo) finalHash() string {
+	final, e := CanonicalizeURL(fetch.FinalURL)
+	if e != nil {
+		logger.Warnf("keeping final url as is - %s", e)
+	}
+	return util.Hash(final)
+}
+
+// RedirectReport returns the pages whose fetch was redirected more than the given number
+// of times, those with the longest chains first, e.g. to find links that need updating
+func (c *CrawlSession) RedirectReport(minHops int) (pages []*Page) {
+	c.walkPages(func(page *Page) {
+		if page.Fetch != nil && len(page.Fetch.RedirectChain) > minHops {
+			pages = append(pages, page)
+		}
+	})
+
+	sort.SliceStable(pages, func(i, j int) bool {
+		return len(pages[i].Fetch.RedirectChain) > len(pages[j].Fetch.RedirectChain)
+	})
+	return
+}
+
+// LogRedirectReport logs the pages whose redirect chains are longer than configured
+func (c *CrawlSession) LogRedirectReport() {
+	hops := crawlerConfig.Get().RedirectReportHops
+	pages := c.RedirectReport(hops)
+	if len(pages) == 0 {
+		return
+	}
+
+	logger.Warnf("[%d] pages redirected more than [%d] times", len(pages), hops)
+	for _, page := range pages {
+		logger.Warnf("page [%s] redirected [%d] times - %v -> [%s]",
+			page.URL, len(page.Fetch.RedirectChain), page.Fetch.RedirectChain, page.Fetch.FinalURL)
+	}
+}
+
+// walkPages visits every page of the session's trees, breadth first, holding the tree lock throughout
+func (c *CrawlSession) walkPages(visit func(page *Page)) {
+	c.treeMutex.Lock()
+	defer c.treeMutex.Unlock()
+
+	queue := append([]*Page{}, c.Seeds...)
+	for len(queue) > 0 {
+		page := queue[0]
+		queue = queue[1:]
+
+		visit(page)
+		queue = append(queue, page.Children...)
+	}
+}
@@ -0,0 +1,255 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	config "webcrawler/config/crawler"
+)
+
+func TestFetchPageRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusMovedPermanently))
+	mux.Handle("/b", http.RedirectHandler("/c", http.StatusFound))
+	mux.Handle("/c", http.RedirectHandler("/page", http.StatusTemporaryRedirect))
+	mux.Handle("/loop", http.RedirectHandler("/loop-back", http.StatusFound))
+	mux.Handle("/loop-back", http.RedirectHandler("/loop", http.StatusFound))
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html>page</html>")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name              string
+		path              string
+		maxRedirects      int
+		expectedChain     []string
+		expectedFinalPath string
+		expectedError     error
+	}{
+		{
+			name:              "success_followed",
+			path:              "/a",
+			maxRedirects:      3,
+			expectedChain:     []string{"/a", "/b", "/c"},
+			expectedFinalPath: "/page",
+		},
+		{
+			name:              "fail_too_many_redirects",
+			path:              "/a",
+			maxRedirects:      2,
+			expectedChain:     []string{"/a", "/b"},
+			expectedFinalPath: "/c",
+			expectedError:     ErrTooManyRedirects,
+		},
+		{
+			name:              "fail_no_redirects_allowed",
+			path:              "/a",
+			maxRedirects:      0,
+			expectedFinalPath: "/a",
+			expectedError:     ErrTooManyRedirects,
+		},
+		{
+			name:              "fail_loop",
+			path:              "/loop",
+			maxRedirects:      10,
+			expectedChain:     []string{"/loop"},
+			expectedFinalPath: "/loop-back",
+			expectedError:     ErrRedirectLoop,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withConfig(t, func(conf *config.Config) {
+				conf.MaxRedirects = test.maxRedirects
+			})
+
+			session := NewCrawlSession(3)
+			_, fetch, e := session.FetchPage(server.URL + test.path)
+
+			if !errors.Is(e, test.expectedError) {
+				t.Errorf("error mismatch.\n- received: %v\n- expected: %v", e, test.expectedError)
+			}
+
+			var chain []string
+			for _, link := range fetch.RedirectChain {
+				chain = append(chain, strings.TrimPrefix(link, server.URL))
+			}
+			if fmt.Sprint(chain) != fmt.Sprint(test.expectedChain) || fetch.FinalURL != server.URL+test.expectedFinalPath {
+				t.Errorf("redirects mismatch.\n- received: %v %s\n- expected: %v %s", chain, fetch.FinalURL, test.expectedChain, test.expectedFinalPath)
+			}
+		})
+	}
+}
+
+func TestCrawlRedirects(t *testing.T) {
+	var hitsMutex sync.Mutex
+	var hits []string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<a href="/old">old</a><a href="/dir/new">new</a><a href="/other">other</a><a href="/chain">chain</a>`)
+	})
+	mux.Handle("/old", http.RedirectHandler("/dir/new", http.StatusMovedPermanently))
+	mux.Handle("/other", http.RedirectHandler("/dir/new", http.StatusFound))
+	mux.Handle("/chain", http.RedirectHandler("/chain/1", http.StatusFound))
+	mux.Handle("/chain/1", http.RedirectHandler("/chain/2", http.StatusFound))
+	mux.Handle("/chain/2", http.RedirectHandler("/dir/new", http.StatusFound))
+	mux.HandleFunc("/dir/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<a href="sub">relative to the page redirected to</a>`)
+	})
+	mux.HandleFunc("/dir/sub", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html>sub</html>")
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitsMutex.Lock()
+		hits = append(hits, r.URL.Path)
+		hitsMutex.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	session, seed := crawlFixture(t, server.URL, fixtureConfig(func(conf *config.Config) {
+		conf.MaxRedirects = 10
+	}))
+
+	// the page redirected to is only crawled once, through the first link to reach it, and isn't fetched
+	// again under its own url - the other links to it are only fetched as far as finding where they redirect
+	expected := []string{"/", "/old", "/dir/new", "/other", "/dir/new", "/chain", "/chain/1", "/chain/2", "/dir/new", "/dir/sub"}
+	if fmt.Sprint(hits) != fmt.Sprint(expected) {
+		t.Errorf("hits mismatch.\n- received: %v\n- expected: %v", hits, expected)
+	}
+
+	// the links of the page redirected to resolve against the url redirected to, and its duplicates aren't read at all
+	tests := []struct {
+		page             *Page
+		expectedFinalURL string
+		expectedRule     string
+		expectedChildren []string
+	}{
+		{page: seed.Children[0], expectedFinalURL: server.URL + "/dir/new", expectedChildren: []string{server.URL + "/dir/sub"}},
+		{page: seed.Children[1], expectedRule: RuleVisited},
+		{page: seed.Children[2], expectedFinalURL: server.URL + "/dir/new", expectedRule: RuleRedirectVisited},
+		{page: seed.Children[3], expectedFinalURL: server.URL + "/dir/new", expectedRule: RuleRedirectVisited},
+	}
+
+	for _, test := range tests {
+		t.Run(test.page.URL, func(t *testing.T) {
+			if test.expectedRule == "" && test.page.Error != nil || test.expectedRule != "" && RejectionRule(test.page.Error) != test.expectedRule {
+				t.Errorf("error mismatch.\n- received: %v\n- expected rule: %s", test.page.Error, test.expectedRule)
+			}
+			if test.expectedFinalURL != "" && (test.page.Fetch == nil || test.page.Fetch.FinalURL != test.expectedFinalURL) {
+				t.Errorf("final url mismatch.\n- received: %+v\n- expected: %s", test.page.Fetch, test.expectedFinalURL)
+			}
+			if test.expectedRule == RuleRedirectVisited && test.page.Fetch.Size != 0 {
+				t.Errorf("body of duplicate of redirected page read - %d bytes", test.page.Fetch.Size)
+			}
+			var children []string
+			for _, child := range test.page.Children {
+				children = append(children, child.URL)
+			}
+			if fmt.Sprint(children) != fmt.Sprint(test.expectedChildren) {
+				t.Errorf("children mismatch.\n- received: %v\n- expected: %v", children, test.expectedChildren)
+			}
+		})
+	}
+
+	var report []string
+	for _, page := range session.RedirectReport(0) {
+		report = append(report, fmt.Sprintf("%s %d", strings.TrimPrefix(page.URL, server.URL), len(page.Fetch.RedirectChain)))
+	}
+	if expectedReport := []string{"/chain 3", "/old 1", "/other 1"}; fmt.Sprint(report) != fmt.Sprint(expectedReport) {
+		t.Errorf("redirect report mismatch.\n- received: %v\n- expected: %v", report, expectedReport)
+	}
+	if long := session.RedirectReport(2); len(long) != 1 || long[0] != seed.Children[3] {
+		t.Errorf("long redirect report mismatch - %v", long)
+	}
+}
+
+func TestCrawlRedirectPolicy(t *testing.T) {
+	var hitsMutex sync.Mutex
+	var hits []string
+	var target string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitsMutex.Lock()
+		hits = append(hits, r.URL.Path)
+		hitsMutex.Unlock()
+
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private/\n")
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/moved">moved</a>`)
+		case "/moved":
+			http.Redirect(w, r, target, http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html>redirected to</html>")
+		}
+	}))
+	defer server.Close()
+
+	// the same server, under a different host
+	otherHost := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	tests := []struct {
+		name         string
+		target       string
+		configure    func(conf *config.Config)
+		expectedRule string
+	}{
+		{name: "success_followed", target: "/page", configure: func(conf *config.Config) {}},
+		{name: "fail_blacklist", target: otherHost + "/page", expectedRule: RuleBlacklist, configure: func(conf *config.Config) {
+			conf.BlacklistedURLs = []string{"localhost"}
+		}},
+		{name: "fail_out_of_scope", target: otherHost + "/page", expectedRule: RuleOutOfScope, configure: func(conf *config.Config) {
+			conf.SeedScope = config.SeedScope{Mode: SeedScopeHost}
+		}},
+		{name: "fail_robots", target: "/private/page", expectedRule: RuleRobots, configure: func(conf *config.Config) {
+			conf.IgnoreRobots = false
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target, hits = test.target, nil
+			_, seed := crawlFixture(t, server.URL, fixtureConfig(func(conf *config.Config) {
+				conf.MaxRedirects = 10
+				test.configure(conf)
+			}))
+
+			moved := seed.Children[0]
+			if rule := RejectionRule(moved.Error); test.expectedRule != "" && rule != test.expectedRule {
+				t.Errorf("rule mismatch.\n- received: %s\n- expected: %s", rule, test.expectedRule)
+			}
+			if test.expectedRule == "" && moved.Error != nil {
+				t.Errorf("unexpected error - %s", moved.Error)
+			}
+
+			targetFetched := false
+			for _, hit := range hits {
+				targetFetched = targetFetched || strings.HasSuffix(hit, "/page")
+			}
+			if targetFetched != (test.expectedRule == "") {
+				t.Errorf("redirect target fetch mismatch.\n- received: %t\n- expected: %t - %v", targetFetched, test.expectedRule == "", hits)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	crawlerConfig "webcrawler/config/crawler"
+	logger "webcrawler/logger"
+)
+
+// RetryPolicy decides which failed fetches are tried again, and how long to wait before each retry
+type RetryPolicy crawlerConfig.RetryPolicy
+
+// RetryPolicyFor returns the retry policy configured for the most specific domain
+// the given host falls under, or the default policy if there isn't one
+func RetryPolicyFor(host string) RetryPolicy {
+	config := crawlerConfig.Get()
+
+	policy, matched := config.Retry, ""
+	for domain, domainPolicy := range config.DomainRetryPolicies {
+		domain = strings.ToLower(domain)
+		if (host == domain || strings.HasSuffix(host, "."+domain)) && len(domain) > len(matched) {
+			policy, matched = domainPolicy, domain
+		}
+	}
+	return RetryPolicy(policy)
+}
+
+// Retryable decides whether a failed fetch is worth trying again - when the response had one of the
+// policy's status codes, or when no response, or only part of a successful one, came back because of
+// a transient network error
+func (p RetryPolicy) Retryable(fetch *FetchInfo, e error) bool {
+	if e == nil {
+		return false
+	}
+	if fetch != nil && fetch.StatusCode != 0 {
+		for _, status := range p.Statuses {
+			if fetch.StatusCode == status {
+				return true
+			}
+		}
+		// a successful response may still fail while its body's read, e.g. by the connection being reset
+		if fetch.StatusCode < 200 || fetch.StatusCode >= 300 {
+			return false
+		}
+	}
+	return p.NetworkErrors && isTransient(e)
+}
+
+// Backoff returns how long to wait before retrying a page that's been fetched the given number of times.
+// The delay doubles with each attempt, up to the policy's maximum, less a random share of up to its jitter
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	delay := time.Duration(p.BaseDelayMS) * time.Millisecond
+	maxDelay := time.Duration(p.MaxDelayMS) * time.Millisecond
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay - time.Duration(rand.Float64()*p.Jitter*float64(delay))
+}
+
+// isTransient reports whether a fetch error might not happen again - timeouts, refused or reset
+// connections and responses cut short - as opposed to e.g. a bad url or a redirect loop
+func isTransient(e error) bool {
+	if errors.Is(e, context.Canceled) || errors.Is(e, ErrRedirectLoop) || errors.Is(e, ErrTooManyRedirects) {
+		return false
+	}
+
+	var dnsError *net.DNSError
+	if errors.As(e, &dnsError) {
+		return dnsError.IsTimeout || dnsError.IsTemporary
+	}
+
+	var netError net.Error
+	if errors.As(e, &netError) && netError.Timeout() {
+		return true
+	}
+
+	return errors.Is(e, syscall.ECONNREFUSED) || errors.Is(e, syscall.ECONNRESET) ||
+		errors.Is(e, io.ErrUnexpectedEOF) || errors.Is(e, io.EOF)
+}
+
+// retry sends a page whose fetch failed back to its host's frontier once its backoff has passed,
+// so the retry waits its turn behind the host's politeness delay like any other page. Returns
+// false if the page has had all the attempts its policy allows, or its failure isn't retryable
+func (c *CrawlSession) retry(page *Page, e error) bool {
+	host := ""
+	if parsed, e := url.Parse(page.URL); e == nil {
+		host = parsed.Hostname()
+	}
+	policy := RetryPolicyFor(host)
+
+	if page.Attempts >= policy.MaxAttempts || !policy.Retryable(page.Fetch, e) {
+		return false
+	}
+
+	delay := policy.Backoff(page.Attempts)
+	logger.Warnf("retrying page [%s] in [%s], attempt [%d] of [%d] failed - %s", page.URL, delay, page.Attempts, policy.MaxAttempts, e)
+
+	c.goroutines.Add(1)
+	go func() {
+		defer c.goroutines.Done()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			// the page's queued signal was taken when it was first routed, before it could be popped and crawled,
+			// so routing it again only pushes it back onto its frontier and never signals the page that found it twice
+			c.route(page)
+		case <-c.Context.Done():
+			c.finish(page)
+		}
+	}()
+	return true
+}
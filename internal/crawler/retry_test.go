@@ -0,0 +1,168 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	config "webcrawler/config/crawler"
+)
+
+func TestRetryPolicyFor(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.Retry = config.RetryPolicy{MaxAttempts: 3}
+		conf.DomainRetryPolicies = map[string]config.RetryPolicy{
+			"example.com":     {MaxAttempts: 5},
+			"api.example.com": {MaxAttempts: 1},
+		}
+	})
+
+	tests := []struct {
+		host                string
+		expectedMaxAttempts int
+	}{
+		{host: "example.com", expectedMaxAttempts: 5},
+		{host: "www.example.com", expectedMaxAttempts: 5},
+		{host: "api.example.com", expectedMaxAttempts: 1},
+		{host: "v2.api.example.com", expectedMaxAttempts: 1},
+		{host: "notexample.com", expectedMaxAttempts: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			if policy := RetryPolicyFor(test.host); policy.MaxAttempts != test.expectedMaxAttempts {
+				t.Errorf("unexpected result.\n- received: %d\n- expected %d", policy.MaxAttempts, test.expectedMaxAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	policy := RetryPolicy{Statuses: []int{429, 503}, NetworkErrors: true}
+
+	timeout := &url.Error{Op: "Get", URL: "https://example.com", Err: os.ErrDeadlineExceeded}
+	refused := &url.Error{Op: "Get", URL: "https://example.com", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}
+	notFound := &url.Error{Op: "Get", URL: "https://example.com", Err: &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}}
+
+	tests := []struct {
+		name           string
+		policy         RetryPolicy
+		fetch          *FetchInfo
+		e              error
+		expectedResult bool
+	}{
+		{name: "success_no_error", policy: policy, fetch: &FetchInfo{StatusCode: 503}, expectedResult: false},
+		{name: "success_retryable_status", policy: policy, fetch: &FetchInfo{StatusCode: 503}, e: fmt.Errorf("status"), expectedResult: true},
+		{name: "success_other_status", policy: policy, fetch: &FetchInfo{StatusCode: 404}, e: fmt.Errorf("status"), expectedResult: false},
+		{name: "success_timeout", policy: policy, fetch: &FetchInfo{}, e: timeout, expectedResult: true},
+		{name: "success_refused", policy: policy, fetch: &FetchInfo{}, e: refused, expectedResult: true},
+		{name: "success_unknown_host", policy: policy, fetch: &FetchInfo{}, e: notFound, expectedResult: false},
+		{name: "success_redirect_loop", policy: policy, fetch: &FetchInfo{}, e: fmt.Errorf("fetch - %w", ErrRedirectLoop), expectedResult: false},
+		{name: "success_cancelled", policy: policy, fetch: &FetchInfo{}, e: fmt.Errorf("fetch - %w", context.Canceled), expectedResult: false},
+		{name: "success_network_errors_off", policy: RetryPolicy{Statuses: []int{503}}, fetch: &FetchInfo{}, e: timeout, expectedResult: false},
+		{name: "success_body_read_timeout", policy: policy, fetch: &FetchInfo{StatusCode: 200}, e: &CrawlError{Kind: ErrTimeout, Err: fmt.Errorf("reading - %w", os.ErrDeadlineExceeded)}, expectedResult: true},
+		{name: "success_body_read_reset", policy: policy, fetch: &FetchInfo{StatusCode: 200}, e: &CrawlError{Kind: ErrNetwork, Err: fmt.Errorf("reading - %w", syscall.ECONNRESET)}, expectedResult: true},
+		{name: "success_body_too_large", policy: policy, fetch: &FetchInfo{StatusCode: 200}, e: &CrawlError{Kind: ErrTooLarge, Err: fmt.Errorf("too large")}, expectedResult: false},
+		{name: "success_body_read_network_errors_off", policy: RetryPolicy{Statuses: []int{503}}, fetch: &FetchInfo{StatusCode: 200}, e: &CrawlError{Kind: ErrNetwork, Err: syscall.ECONNRESET}, expectedResult: false},
+		{name: "success_other_status_timeout", policy: policy, fetch: &FetchInfo{StatusCode: 404}, e: timeout, expectedResult: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := test.policy.Retryable(test.fetch, test.e); result != test.expectedResult {
+				t.Errorf("unexpected result.\n- received: %t\n- expected %t", result, test.expectedResult)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelayMS: 100, MaxDelayMS: 1000}
+	for attempts, expected := range []time.Duration{100, 100, 200, 400, 800, 1000, 1000} {
+		if delay := policy.Backoff(attempts); delay != expected*time.Millisecond {
+			t.Errorf("delay after [%d] attempts mismatch.\n- received: %s\n- expected: %s", attempts, delay, expected*time.Millisecond)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := policy.Backoff(3); delay < 200*time.Millisecond || delay > 400*time.Millisecond {
+			t.Fatalf("jittered delay out of range - %s", delay)
+		}
+	}
+}
+
+func TestCrawlRetries(t *testing.T) {
+	var hitsMutex sync.Mutex
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hitsMutex.Lock()
+		hits[r.URL.Path]++
+		count := hits[r.URL.Path]
+		hitsMutex.Unlock()
+
+		switch {
+		case r.URL.Path == "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/flaky">flaky</a><a href="/down">down</a><a href="/gone">gone</a><a href="/cut">cut</a>`)
+		case r.URL.Path == "/flaky" && count > 2:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html>back up</html>")
+		case r.URL.Path == "/flaky":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/down":
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.URL.Path == "/cut" && count == 1:
+			// the connection's closed part way through the body
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Length", "100")
+			fmt.Fprint(w, "<html>cut")
+		case r.URL.Path == "/cut":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html>whole</html>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	_, seed := crawlFixture(t, server.URL, fixtureConfig(func(conf *config.Config) {
+		conf.Retry = config.RetryPolicy{MaxAttempts: 3, BaseDelayMS: 10, MaxDelayMS: 50, Statuses: []int{500, 503}, NetworkErrors: true}
+	}))
+
+	tests := []struct {
+		page             *Page
+		expectedHits     int
+		expectedAttempts int
+		expectedStatus   int
+		expectedCrawled  bool
+	}{
+		{page: seed.Children[0], expectedHits: 3, expectedAttempts: 3, expectedStatus: http.StatusOK, expectedCrawled: true},
+		{page: seed.Children[1], expectedHits: 3, expectedAttempts: 3, expectedStatus: http.StatusInternalServerError},
+		{page: seed.Children[2], expectedHits: 1, expectedAttempts: 1, expectedStatus: http.StatusNotFound},
+		{page: seed.Children[3], expectedHits: 2, expectedAttempts: 2, expectedStatus: http.StatusOK, expectedCrawled: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.page.URL, func(t *testing.T) {
+			u, _ := url.Parse(test.page.URL)
+			if hits[u.Path] != test.expectedHits || test.page.Attempts != test.expectedAttempts {
+				t.Errorf("attempts mismatch.\n- received: %d hits, %d attempts\n- expected: %d hits, %d attempts",
+					hits[u.Path], test.page.Attempts, test.expectedHits, test.expectedAttempts)
+			}
+			if test.page.Fetch == nil || test.page.Fetch.StatusCode != test.expectedStatus {
+				t.Errorf("last fetch mismatch - %+v", test.page.Fetch)
+			}
+			if crawled := test.page.ContentHash != ""; crawled != test.expectedCrawled || !test.page.Processed {
+				t.Errorf("crawl mismatch.\n- received: crawled %t, processed %t\n- expected: crawled %t", crawled, test.page.Processed, test.expectedCrawled)
+			}
+		})
+	}
+}
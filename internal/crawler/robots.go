@@ -0,0 +1,366 @@
+package crawler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	crawlerConfig "webcrawler/config/crawler"
+	logger "webcrawler/logger"
+)
+
+// robotsMaxBytes is the most of a robots.txt file that will be parsed, as suggested by RFC 9309
+const robotsMaxBytes = 500 * 1024
+
+// Robots holds the rules parsed from a host's robots.txt file
+type Robots struct {
+	Groups   []*RobotsGroup
+	Sitemaps []string
+
+	// set when robots.txt could not be reached, in which case the whole host is off limits
+	DisallowAll bool
+}
+
+// RobotsGroup holds the rules that apply to one or more user agents
+type RobotsGroup struct {
+	UserAgents []string
+	Rules      []*RobotsRule
+	CrawlDelay time.Duration
+}
+
+// RobotsRule is a single Allow or Disallow line from a robots.txt group
+type RobotsRule struct {
+	Allow   bool
+	Pattern string
+	matcher *regexp.Regexp
+}
+
+// String returns the rule as it would appear in a robots.txt file
+func (r *RobotsRule) String() string {
+	if r.Allow {
+		return fmt.Sprintf("Allow: %s", r.Pattern)
+	}
+	return fmt.Sprintf("Disallow: %s", r.Pattern)
+}
+
+// ParseRobots reads the contents of a robots.txt file and returns the groups, rules and sitemaps it contains.
+// Lines that can't be understood are skipped, as robots.txt files in the wild are often malformed
+func ParseRobots(body io.Reader) *Robots {
+	robots := &Robots{}
+
+	var group *RobotsGroup
+	groupHasRules := false
+
+	scanner := bufio.NewScanner(io.LimitReader(body, robotsMaxBytes))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// consecutive user-agent lines share a group, any other line closes it
+			if group == nil || groupHasRules {
+				group = &RobotsGroup{}
+				groupHasRules = false
+				robots.Groups = append(robots.Groups, group)
+			}
+			group.UserAgents = append(group.UserAgents, strings.ToLower(value))
+
+		case "allow", "disallow":
+			if group == nil {
+				continue
+			}
+			groupHasRules = true
+
+			// an empty pattern matches nothing
+			if value == "" {
+				continue
+			}
+			group.Rules = append(group.Rules, newRobotsRule(key == "allow", value))
+
+		case "crawl-delay":
+			if group == nil {
+				continue
+			}
+			groupHasRules = true
+
+			seconds, e := strconv.ParseFloat(value, 64)
+			if e != nil || seconds < 0 {
+				logger.Warnf("ignoring invalid robots.txt crawl-delay [%s]", value)
+				continue
+			}
+			group.CrawlDelay = time.Duration(seconds * float64(time.Second))
+
+		case "sitemap":
+			// sitemap lines aren't tied to any group
+			if value != "" {
+				robots.Sitemaps = append(robots.Sitemaps, value)
+			}
+		}
+	}
+	return robots
+}
+
+func newRobotsRule(allow bool, pattern string) *RobotsRule {
+	// '*' matches any sequence of characters, and a trailing '$' anchors the pattern to the end of the path
+	anchored := strings.HasSuffix(pattern, "$")
+	expression := strings.TrimSuffix(pattern, "$")
+	expression = strings.ReplaceAll(regexp.QuoteMeta(expression), `\*`, `.*`)
+	expression = "^" + expression
+	if anchored {
+		expression += "$"
+	}
+
+	return &RobotsRule{
+		Allow:   allow,
+		Pattern: pattern,
+		matcher: regexp.MustCompile(expression)}
+}
+
+// group returns the group of rules that applies to the given user agent. Groups naming the
+// longest agent token contained in the user agent are merged and used, falling back to the
+// '*' groups. Returns nil when no group applies
+func (r *Robots) group(userAgent string) *RobotsGroup {
+	userAgent = strings.ToLower(userAgent)
+
+	var specific, wildcard *RobotsGroup
+	specificToken := ""
+
+	for _, group := range r.Groups {
+		for _, token := range group.UserAgents {
+			switch {
+			// an empty user-agent names no crawler, rather than matching every one as a specific group
+			case token == "":
+			case token == "*":
+				wildcard = mergeRobotsGroups(wildcard, group)
+			case token == specificToken:
+				specific = mergeRobotsGroups(specific, group)
+			case strings.Contains(userAgent, token) && len(token) > len(specificToken):
+				specific = mergeRobotsGroups(nil, group)
+				specificToken = token
+			}
+		}
+	}
+
+	if specific != nil {
+		return specific
+	}
+	return wildcard
+}
+
+func mergeRobotsGroups(current *RobotsGroup, next *RobotsGroup) *RobotsGroup {
+	if current == nil {
+		current = &RobotsGroup{}
+	}
+
+	merged := &RobotsGroup{
+		UserAgents: append(append([]string{}, current.UserAgents...), next.UserAgents...),
+		Rules:      append(append([]*RobotsRule{}, current.Rules...), next.Rules...),
+		CrawlDelay: current.CrawlDelay}
+	if next.CrawlDelay > merged.CrawlDelay {
+		merged.CrawlDelay = next.CrawlDelay
+	}
+	return merged
+}
+
+// IsAllowed reports whether the given user agent may fetch the given URL, along with the
+// rule that made the decision (nil if no rule matched). The longest matching pattern wins,
+// and Allow wins when an Allow and a Disallow pattern are the same length
+func (r *Robots) IsAllowed(userAgent string, urlString string) (allowed bool, rule *RobotsRule) {
+	if r == nil {
+		return true, nil
+	}
+	if r.DisallowAll {
+		return false, nil
+	}
+
+	group := r.group(userAgent)
+	if group == nil {
+		return true, nil
+	}
+
+	path := "/"
+	if parsed, e := url.Parse(urlString); e == nil {
+		path = parsed.RequestURI()
+	}
+
+	for _, candidate := range group.Rules {
+		if !candidate.matcher.MatchString(path) {
+			continue
+		}
+		if rule == nil ||
+			len(candidate.Pattern) > len(rule.Pattern) ||
+			(len(candidate.Pattern) == len(rule.Pattern) && candidate.Allow) {
+			rule = candidate
+		}
+	}
+
+	if rule == nil {
+		return true, nil
+	}
+	return rule.Allow, rule
+}
+
+// CrawlDelay returns the Crawl-delay the host has requested for the given user agent, or zero if none
+func (r *Robots) CrawlDelay(userAgent string) time.Duration {
+	if r == nil {
+		return 0
+	}
+	group := r.group(userAgent)
+	if group == nil {
+		return 0
+	}
+	return group.CrawlDelay
+}
+
+// robots directives a page can give in its meta robots tags and X-Robots-Tag headers, and the link relation asking a link not be followed
+const (
+	RobotsNoIndex  = "noindex"
+	RobotsNoFollow = "nofollow"
+	RobotsNone     = "none"
+	RelNoFollow    = "nofollow"
+)
+
+// robotsValueDirectives are directives given a value after a colon, e.g. "max-snippet: 20", which
+// mustn't be mistaken for the user agent an X-Robots-Tag header is addressed to, e.g. "otherbot: noindex"
+var robotsValueDirectives = []string{"unavailable_after", "max-snippet", "max-image-preview", "max-video-preview"}
+
+// ParseRobotsDirectives splits the content of a meta robots tag, e.g. "noindex, nofollow", into its lowercased directives
+func ParseRobotsDirectives(content string) (directives []string) {
+	for _, directive := range strings.Split(content, ",") {
+		if directive = strings.ToLower(strings.TrimSpace(directive)); directive != "" {
+			directives = append(directives, directive)
+		}
+	}
+	return
+}
+
+// ParseRobotsTag returns the directives of an X-Robots-Tag header that apply to the given user agent - a header
+// addressed to a user agent, e.g. "otherbot: noindex", only applies to agents containing that token
+func ParseRobotsTag(value string, userAgent string) []string {
+	if agent, rest, found := strings.Cut(value, ":"); found {
+		agent = strings.ToLower(strings.TrimSpace(agent))
+		if !strings.Contains(agent, ",") && !containsString(robotsValueDirectives, agent) {
+			if !robotsAgentMatches(agent, userAgent) {
+				return nil
+			}
+			value = rest
+		}
+	}
+	return ParseRobotsDirectives(value)
+}
+
+// robotsAgentMatches reports whether robots directives addressed to the given name, as in a
+// meta tag's name or an X-Robots-Tag's prefix, apply to the given user agent
+func robotsAgentMatches(name string, userAgent string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return name == "robots" || name != "" && strings.Contains(strings.ToLower(userAgent), name)
+}
+
+// RobotsCache holds the parsed robots.txt of every origin - scheme and host - seen during a crawl, as
+// http and https may serve different files, making sure each is only fetched once even when requested concurrently
+type RobotsCache struct {
+	mutex sync.Mutex
+	data  map[string]*robotsEntry
+}
+
+type robotsEntry struct {
+	once   sync.Once
+	robots *Robots
+}
+
+// NewRobotsCache creates, inits and returns a new RobotsCache struct
+func NewRobotsCache() *RobotsCache {
+	return &RobotsCache{
+		data: make(map[string]*robotsEntry),
+	}
+}
+
+// Get returns the cached robots.txt for the given origin, calling fetch to populate the cache if it's not yet there
+func (r *RobotsCache) Get(origin string, fetch func() *Robots) *Robots {
+	r.mutex.Lock()
+	entry, ok := r.data[origin]
+	if !ok {
+		entry = &robotsEntry{}
+		r.data[origin] = entry
+	}
+	r.mutex.Unlock()
+
+	entry.once.Do(func() {
+		entry.robots = fetch()
+	})
+	return entry.robots
+}
+
+// GetRobots returns the robots.txt rules for the host of the given URL, fetching them if they haven't been yet.
+// Returns nil, i.e. no restrictions, when robots.txt is configured to be ignored
+func (c *CrawlSession) GetRobots(urlString string) *Robots {
+	if crawlerConfig.Get().IgnoreRobots {
+		return nil
+	}
+
+	parsed, e := url.Parse(urlString)
+	if e != nil || parsed.Host == "" {
+		logger.Errorf("could not get robots.txt, error parsing url [%s]", urlString)
+		return nil
+	}
+
+	origin := fmt.Sprintf("%s://%s", parsed.Scheme, parsed.Host)
+	return c.Robots.Get(origin, func() *Robots {
+		return c.FetchRobots(origin + "/robots.txt")
+	})
+}
+
+// FetchRobots fetches and parses the robots.txt file at the given URL. Following RFC 9309, a
+// missing file (4xx) places no restrictions on the host, while an unreachable one (5xx or
+// network error) means nothing on the host may be crawled
+func (c *CrawlSession) FetchRobots(robotsURL string) *Robots {
+	logger.Infof("fetching robots.txt [%s]", robotsURL)
+
+	req, e := http.NewRequestWithContext(c.Context, http.MethodGet, robotsURL, nil)
+	if e != nil {
+		logger.Errorf("error creating GET request for robots.txt [%s] - %s", robotsURL, e)
+		return &Robots{}
+	}
+	if userAgent := crawlerConfig.Get().UserAgent; userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	response, e := c.Client.Do(req)
+	if e != nil {
+		logger.Warnf("robots.txt [%s] unreachable, disallowing host - %s", robotsURL, e)
+		return &Robots{DisallowAll: true}
+	}
+	defer response.Body.Close()
+
+	status := response.StatusCode
+	switch {
+	case status >= 200 && status <= 299:
+		robots := ParseRobots(response.Body)
+		logger.Infof("robots.txt [%s] parsed - [%d] groups, [%d] sitemaps", robotsURL, len(robots.Groups), len(robots.Sitemaps))
+		return robots
+
+	case status >= 500:
+		logger.Warnf("robots.txt [%s] unreachable, status code [%d], disallowing host", robotsURL, status)
+		return &Robots{DisallowAll: true}
+
+	default:
+		logger.Infof("no robots.txt at [%s], status code [%d], host unrestricted", robotsURL, status)
+		return &Robots{}
+	}
+}
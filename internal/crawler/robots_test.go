@@ -0,0 +1,279 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	testutil "webcrawler/test/util"
+)
+
+func TestParseRobots(t *testing.T) {
+	tests := []struct {
+		name             string
+		content          string
+		expectedGroups   int
+		expectedRules    []int
+		expectedSitemaps []string
+	}{
+		{
+			name: "success_groups",
+			content: `
+# comment
+User-agent: webcrawler
+User-agent: otherbot
+Disallow: /private # trailing comment
+Allow: /private/open
+
+User-agent: *
+Disallow: /
+Crawl-delay: 2`,
+			expectedGroups: 2,
+			expectedRules:  []int{2, 1},
+		},
+		{
+			name: "success_sitemaps",
+			content: `
+Sitemap: https://www.google.com/sitemap.xml
+User-agent: *
+Disallow:
+sitemap: https://www.google.com/news-sitemap.xml`,
+			expectedGroups:   1,
+			expectedRules:    []int{0},
+			expectedSitemaps: []string{"https://www.google.com/sitemap.xml", "https://www.google.com/news-sitemap.xml"},
+		},
+		{
+			name:           "success_rules_without_group",
+			content:        "Disallow: /\nnot a robots line",
+			expectedGroups: 0,
+		},
+		{
+			name:           "success_empty",
+			content:        "",
+			expectedGroups: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			robots := ParseRobots(strings.NewReader(test.content))
+
+			if len(robots.Groups) != test.expectedGroups {
+				t.Fatalf("group count mismatch.\n- received: %d\n- expected: %d", len(robots.Groups), test.expectedGroups)
+			}
+			for i, expected := range test.expectedRules {
+				if len(robots.Groups[i].Rules) != expected {
+					t.Errorf("rule count mismatch for group [%d].\n- received: %d\n- expected: %d", i, len(robots.Groups[i].Rules), expected)
+				}
+			}
+			if strings.Join(robots.Sitemaps, ",") != strings.Join(test.expectedSitemaps, ",") {
+				t.Errorf("sitemaps mismatch.\n- received: %v\n- expected: %v", robots.Sitemaps, test.expectedSitemaps)
+			}
+		})
+	}
+}
+
+func TestRobotsIsAllowed(t *testing.T) {
+	content := `
+User-agent: webcrawler
+Disallow: /private
+Allow: /private/open
+Disallow: /*.php$
+Disallow: /search*q=
+Allow: /page
+Disallow: /page
+
+User-agent:
+Allow: /
+
+User-agent: *
+Disallow: /`
+
+	tests := []struct {
+		name           string
+		userAgent      string
+		url            string
+		expectedResult bool
+	}{
+		{
+			name:           "success_allowed_no_rule",
+			userAgent:      "webcrawler",
+			url:            "https://www.google.com/about",
+			expectedResult: true,
+		},
+		{
+			name:           "success_disallowed_prefix",
+			userAgent:      "webcrawler",
+			url:            "https://www.google.com/private/secret",
+			expectedResult: false,
+		},
+		{
+			name:           "success_allowed_longest_match",
+			userAgent:      "webcrawler",
+			url:            "https://www.google.com/private/open/doc",
+			expectedResult: true,
+		},
+		{
+			name:           "success_disallowed_end_anchor",
+			userAgent:      "webcrawler",
+			url:            "https://www.google.com/index.php",
+			expectedResult: false,
+		},
+		{
+			name:           "success_allowed_end_anchor",
+			userAgent:      "webcrawler",
+			url:            "https://www.google.com/index.php5",
+			expectedResult: true,
+		},
+		{
+			name:           "success_disallowed_wildcard_query",
+			userAgent:      "webcrawler",
+			url:            "https://www.google.com/search?lang=en&q=test",
+			expectedResult: false,
+		},
+		{
+			name:           "success_allowed_tie",
+			userAgent:      "webcrawler",
+			url:            "https://www.google.com/page",
+			expectedResult: true,
+		},
+		{
+			name:           "success_agent_case_and_version",
+			userAgent:      "WebCrawler/1.0",
+			url:            "https://www.google.com/about",
+			expectedResult: true,
+		},
+		{
+			name:           "success_wildcard_group",
+			userAgent:      "otherbot",
+			url:            "https://www.google.com/about",
+			expectedResult: false,
+		},
+		{
+			// the group with an empty user-agent isn't taken as one specific to every crawler
+			name:           "success_empty_agent_ignored",
+			userAgent:      "otherbot",
+			url:            "https://www.google.com/",
+			expectedResult: false,
+		},
+	}
+
+	robots := ParseRobots(strings.NewReader(content))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			allowed, _ := robots.IsAllowed(test.userAgent, test.url)
+
+			if allowed != test.expectedResult {
+				t.Errorf("result mismatch.\n- received: %t\n- expected: %t", allowed, test.expectedResult)
+			}
+		})
+	}
+}
+
+func TestRobotsCrawlDelay(t *testing.T) {
+	robots := ParseRobots(strings.NewReader("User-agent: *\nCrawl-delay: 1.5\n\nUser-agent: webcrawler\nCrawl-delay: 3"))
+
+	if delay := robots.CrawlDelay("webcrawler"); delay != 3*time.Second {
+		t.Errorf("crawl delay mismatch.\n- received: %s\n- expected: %s", delay, 3*time.Second)
+	}
+	if delay := robots.CrawlDelay("otherbot"); delay != 1500*time.Millisecond {
+		t.Errorf("crawl delay mismatch.\n- received: %s\n- expected: %s", delay, 1500*time.Millisecond)
+	}
+
+	var missing *Robots
+	if delay := missing.CrawlDelay("webcrawler"); delay != 0 {
+		t.Errorf("crawl delay mismatch.\n- received: %s\n- expected: 0s", delay)
+	}
+}
+
+func TestParseRobotsTag(t *testing.T) {
+	tests := []struct {
+		value          string
+		expectedResult []string
+	}{
+		{value: "noindex", expectedResult: []string{"noindex"}},
+		{value: "NoIndex, NoFollow", expectedResult: []string{"noindex", "nofollow"}},
+		{value: "webcrawler: none", expectedResult: []string{"none"}},
+		{value: "otherbot: noindex, nofollow", expectedResult: nil},
+		{value: "max-snippet: 20, noindex", expectedResult: []string{"max-snippet: 20", "noindex"}},
+		{value: "unavailable_after: 25 Jun 2010 15:00:00 PST", expectedResult: []string{"unavailable_after: 25 jun 2010 15:00:00 pst"}},
+		{value: " , ", expectedResult: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			directives := ParseRobotsTag(test.value, "Mozilla/5.0 (compatible; webcrawler/1.0)")
+			if fmt.Sprint(directives) != fmt.Sprint(test.expectedResult) {
+				t.Errorf("unexpected result.\n- received: %q\n- expected %q", directives, test.expectedResult)
+			}
+		})
+	}
+}
+
+func TestFetchRobots(t *testing.T) {
+	tests := []struct {
+		name                string
+		statusCode          int
+		response            string
+		expectedDisallowAll bool
+		expectedAllowed     bool
+	}{
+		{
+			name:            "success_parsed",
+			statusCode:      http.StatusOK,
+			response:        "User-agent: *\nDisallow: /test",
+			expectedAllowed: false,
+		},
+		{
+			name:            "success_missing",
+			statusCode:      http.StatusNotFound,
+			expectedAllowed: true,
+		},
+		{
+			name:                "success_unreachable",
+			statusCode:          http.StatusServiceUnavailable,
+			expectedDisallowAll: true,
+			expectedAllowed:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			logBuffer := testutil.GetLogBuffer()
+
+			server := testutil.GetTestServer("/robots.txt", test.statusCode, test.response, map[string]string{"Content-Type": "text/plain"})
+			defer server.Close()
+
+			session := NewCrawlSession(3)
+			robots := session.GetRobots(server.URL + "/test")
+
+			t.Log(logBuffer.String())
+
+			if robots == nil {
+				t.Fatal("missing expected robots")
+			}
+			if robots.DisallowAll != test.expectedDisallowAll {
+				t.Errorf("disallow all mismatch.\n- received: %t\n- expected: %t", robots.DisallowAll, test.expectedDisallowAll)
+			}
+			if allowed, _ := robots.IsAllowed("webcrawler", server.URL+"/test"); allowed != test.expectedAllowed {
+				t.Errorf("allowed mismatch.\n- received: %t\n- expected: %t", allowed, test.expectedAllowed)
+			}
+
+			// cached - a second lookup must not refetch
+			server.Close()
+			if cached := session.GetRobots(server.URL + "/other"); cached != robots {
+				t.Error("robots.txt not cached for host")
+			}
+
+			// cached by scheme as well as host, as the same host may serve a different file over https
+			if other := session.GetRobots(strings.Replace(server.URL, "http://", "https://", 1) + "/test"); other == robots {
+				t.Error("robots.txt cached for host shared by another scheme")
+			}
+		})
+	}
+}
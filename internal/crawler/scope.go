@@ -0,0 +1,202 @@
+package crawler
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	crawlerConfig "webcrawler/config/crawler"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// scope rule actions and matchers that can be configured
+const (
+	ScopeInclude = "include"
+	ScopeExclude = "exclude"
+
+	ScopeMatchRegex  = "regex"
+	ScopeMatchGlob   = "glob"
+	ScopeMatchPrefix = "prefix"
+)
+
+// how far from its seed a crawl reaches - see crawlerConfig.SeedScope
+const (
+	SeedScopeHost   = "host"
+	SeedScopeDomain = "domain"
+	SeedScopeHosts  = "hosts"
+	SeedScopeAny    = "any"
+)
+
+// SeedScope decides which urls a seed's crawl reaches
+type SeedScope crawlerConfig.SeedScope
+
+// SeedScopeFor returns the scope of the given seed - its own if configured, otherwise the default
+func SeedScopeFor(seedURL string) SeedScope {
+	config := crawlerConfig.Get()
+
+	seed, e := CanonicalizeURL(seedURL)
+	if e != nil {
+		return SeedScope(config.SeedScope)
+	}
+	for configured, scope := range config.SeedScopes {
+		if canonical, e := CanonicalizeURL(configured); e == nil && canonical == seed {
+			return SeedScope(scope)
+		}
+	}
+	return SeedScope(config.SeedScope)
+}
+
+// CheckSeedScope decides whether a url is within the scope of the given seed, returning why not if it isn't
+func CheckSeedScope(seedURL string, link string) error {
+	scope := SeedScopeFor(seedURL)
+	if scope.Mode == SeedScopeAny {
+		return nil
+	}
+
+	seedHost, e := urlHostname(seedURL)
+	if e != nil {
+		return e
+	}
+	host, e := urlHostname(link)
+	if e != nil {
+		return e
+	}
+
+	switch scope.Mode {
+	case SeedScopeHost:
+		if host == seedHost {
+			return nil
+		}
+	case SeedScopeDomain:
+		if registrableDomain(host) == registrableDomain(seedHost) {
+			return nil
+		}
+	case SeedScopeHosts:
+		if host == seedHost || hostListed(host, scope.Hosts) {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return fmt.Errorf("host [%s] out of [%s] scope of seed [%s]", host, scope.Mode, seedURL)
+}
+
+// registrableDomain returns the domain of a host that can be registered, by the public suffix list - e.g.
+// "example.co.uk" for "www.example.co.uk" - or the host itself if it has none, e.g. an ip address or "localhost"
+func registrableDomain(host string) string {
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return host
+	}
+	domain, e := publicsuffix.EffectiveTLDPlusOne(host)
+	if e != nil {
+		return host
+	}
+	return domain
+}
+
+// urlHostname returns the lowercased hostname of a url, without its port
+func urlHostname(link string) (string, error) {
+	parsed, e := url.Parse(link)
+	if e != nil {
+		return "", &CrawlError{Kind: ErrParse, URL: link, Err: fmt.Errorf("could not get host, error parsing url [%s] - [%w]", link, e)}
+	}
+	return strings.ToLower(parsed.Hostname()), nil
+}
+
+// hostListed checks whether a host is one of the given hosts, or a subdomain of one
+func hostListed(host string, hosts []string) bool {
+	for _, domain := range hosts {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckBlacklist decides whether a url is blacklisted by any of the configured blacklist entries, returning which if it is
+func CheckBlacklist(link string) error {
+	blacklist := crawlerConfig.Get().Blacklist()
+	if len(blacklist) == 0 {
+		return nil
+	}
+
+	parsed, e := url.Parse(link)
+	if e != nil {
+		return &CrawlError{Kind: ErrParse, URL: link, Err: fmt.Errorf("could not check blacklist, error parsing url [%s] - [%w]", link, e)}
+	}
+	host := strings.ToLower(parsed.Hostname())
+
+	for _, entry := range blacklist {
+		if blacklistMatches(entry, host, parsed.Path) {
+			return fmt.Errorf("site blacklisted by [%s]", entry.Entry)
+		}
+	}
+	return nil
+}
+
+// blacklistMatches checks whether a blacklist entry covers the url of the given host and path
+func blacklistMatches(entry crawlerConfig.BlacklistEntry, host string, urlPath string) bool {
+	switch {
+	case entry.Network != nil:
+		ip := net.ParseIP(host)
+		return ip != nil && entry.Network.Contains(ip)
+	case entry.Wildcard:
+		if !strings.HasSuffix(host, "."+entry.Host) {
+			return false
+		}
+	case !hostListed(host, []string{entry.Host}):
+		return false
+	}
+
+	// path scoped entries cover their path and those below it, so "/private" doesn't cover "/privateer"
+	prefix := strings.TrimSuffix(entry.Path, "/")
+	return prefix == "" || urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/")
+}
+
+// CheckScope decides whether a url is in the crawl's scope - the first of the configured scope rules to match it
+// includes or excludes it, and a url matched by none is included. Returns why not if it's out of scope
+func CheckScope(link string) error {
+	rules := crawlerConfig.Get().ScopeRules
+	if len(rules) == 0 {
+		return nil
+	}
+
+	parsed, e := url.Parse(link)
+	if e != nil {
+		return &CrawlError{Kind: ErrParse, URL: link, Err: fmt.Errorf("could not check scope, error parsing url [%s] - [%w]", link, e)}
+	}
+	host := strings.ToLower(parsed.Hostname())
+	urlPath := parsed.EscapedPath()
+	if urlPath == "" {
+		urlPath = "/"
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if !scopeRuleApplies(rule, host) {
+			continue
+		}
+
+		subject := urlPath
+		if rule.Match == ScopeMatchRegex {
+			subject = link
+		}
+		if pattern := rule.Regexp(); pattern == nil || !pattern.MatchString(subject) {
+			continue
+		}
+
+		if rule.Action == ScopeExclude {
+			return fmt.Errorf("excluded by scope rule [%d] - %s [%s]", i, rule.Match, rule.Pattern)
+		}
+		return nil
+	}
+	return nil
+}
+
+// scopeRuleApplies checks whether a scope rule applies to urls of the given host - rules
+// without hosts apply to every url, others to those of their hosts and their subdomains
+func scopeRuleApplies(rule *crawlerConfig.ScopeRule, host string) bool {
+	return len(rule.Hosts) == 0 || hostListed(host, rule.Hosts)
+}
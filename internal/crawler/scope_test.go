@@ -0,0 +1,423 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	config "webcrawler/config/crawler"
+)
+
+func TestCheckScope(t *testing.T) {
+	blogOnly := []config.ScopeRule{
+		{Action: ScopeInclude, Match: ScopeMatchPrefix, Pattern: "/blog/", Hosts: []string{"example.com"}},
+		{Action: ScopeExclude, Match: ScopeMatchPrefix, Pattern: "/", Hosts: []string{"example.com"}},
+	}
+
+	tests := []struct {
+		name            string
+		rules           []config.ScopeRule
+		url             string
+		expectedInScope bool
+		expectedError   bool
+	}{
+		{
+			name:            "success_no_rules",
+			url:             "https://www.example.com/app.js",
+			expectedInScope: true,
+		},
+		{
+			name:            "success_glob_excluded",
+			rules:           []config.ScopeRule{{Action: ScopeExclude, Match: ScopeMatchGlob, Pattern: "**.js"}},
+			url:             "https://www.example.com/static/app.js",
+			expectedInScope: false,
+		},
+		{
+			name:            "success_glob_not_matched",
+			rules:           []config.ScopeRule{{Action: ScopeExclude, Match: ScopeMatchGlob, Pattern: "**.js"}},
+			url:             "https://www.example.com/docs/.jsonschema",
+			expectedInScope: true,
+		},
+		{
+			name:            "success_glob_star_within_segment",
+			rules:           []config.ScopeRule{{Action: ScopeExclude, Match: ScopeMatchGlob, Pattern: "/*/secret"}},
+			url:             "https://www.example.com/a/b/secret",
+			expectedInScope: true,
+		},
+		{
+			name:            "success_glob_star_segment_matched",
+			rules:           []config.ScopeRule{{Action: ScopeExclude, Match: ScopeMatchGlob, Pattern: "/*/secret"}},
+			url:             "https://www.example.com/a/secret",
+			expectedInScope: false,
+		},
+		{
+			name:            "success_prefix_included",
+			rules:           blogOnly,
+			url:             "https://example.com/blog/first-post",
+			expectedInScope: true,
+		},
+		{
+			name:            "success_prefix_excluded",
+			rules:           blogOnly,
+			url:             "https://example.com/about",
+			expectedInScope: false,
+		},
+		{
+			name:            "success_subdomain_excluded",
+			rules:           blogOnly,
+			url:             "https://shop.example.com/cart",
+			expectedInScope: false,
+		},
+		{
+			name:            "success_other_host_unaffected",
+			rules:           blogOnly,
+			url:             "https://www.google.com/about",
+			expectedInScope: true,
+		},
+		{
+			name:            "success_similar_host_unaffected",
+			rules:           blogOnly,
+			url:             "https://notexample.com/about",
+			expectedInScope: true,
+		},
+		{
+			name:            "success_regex_whole_url",
+			rules:           []config.ScopeRule{{Action: ScopeExclude, Match: ScopeMatchRegex, Pattern: `[?&]sort=`}},
+			url:             "https://www.example.com/products?page=2&sort=price",
+			expectedInScope: false,
+		},
+		{
+			name: "success_first_match_wins",
+			rules: []config.ScopeRule{
+				{Action: ScopeInclude, Match: ScopeMatchGlob, Pattern: "/docs/**"},
+				{Action: ScopeExclude, Match: ScopeMatchGlob, Pattern: "**.pdf"},
+			},
+			url:             "https://www.example.com/docs/guide.pdf",
+			expectedInScope: true,
+		},
+		{
+			name:          "fail_invalid_pattern",
+			rules:         []config.ScopeRule{{Action: ScopeExclude, Match: ScopeMatchRegex, Pattern: "(unclosed"}},
+			url:           "https://www.example.com/(unclosed",
+			expectedError: true,
+		},
+		{
+			name:            "fail_url_form",
+			rules:           blogOnly,
+			url:             "http://%zz",
+			expectedInScope: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// a rule that can't be compiled makes the config invalid, rather than being skipped
+			if test.expectedError {
+				invalid := *config.Get()
+				invalid.ScopeRules = test.rules
+				if e := invalid.Validate(); e == nil {
+					t.Errorf("invalid scope rules accepted - %v", test.rules)
+				}
+				return
+			}
+			withConfig(t, func(conf *config.Config) {
+				conf.ScopeRules = test.rules
+			})
+
+			e := CheckScope(test.url)
+
+			if inScope := e == nil; inScope != test.expectedInScope {
+				t.Errorf("result mismatch.\n- received: %t (%v)\n- expected: %t", inScope, e, test.expectedInScope)
+			}
+		})
+	}
+}
+
+func TestScopeRulesApplied(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.ScopeRules = []config.ScopeRule{{Action: ScopeExclude, Match: ScopeMatchPrefix, Pattern: "/private/"}}
+	})
+
+	if e := CheckValidLink("https://www.example.com/private/a", "a", nil); RejectionRule(e) != RuleScope {
+		t.Errorf("link rule mismatch.\n- received: %s (%v)\n- expected: %s", RejectionRule(e), e, RuleScope)
+	}
+
+	session := NewCrawlSession(3)
+	page := NewPage("https://www.example.com/private/a", "a", 0, nil)
+	if e := page.CheckCrawlable(session.VisitedURLs, session.SeenContent, nil); RejectionRule(e) != RuleScope {
+		t.Errorf("crawl rule mismatch.\n- received: %s (%v)\n- expected: %s", RejectionRule(e), e, RuleScope)
+	}
+
+	page = NewPage("https://www.example.com/public/a", "a", 0, nil)
+	if e := page.CheckCrawlable(session.VisitedURLs, session.SeenContent, nil); e != nil {
+		t.Errorf("unexpected error - %s", e)
+	}
+}
+
+func TestCheckSeedScope(t *testing.T) {
+	tests := []struct {
+		name            string
+		scope           config.SeedScope
+		seedScopes      map[string]config.SeedScope
+		url             string
+		expectedInScope bool
+		expectedError   bool
+	}{
+		{
+			name:            "success_any",
+			scope:           config.SeedScope{Mode: SeedScopeAny},
+			url:             "https://www.google.com/",
+			expectedInScope: true,
+		},
+		{
+			name:            "success_host_same",
+			scope:           config.SeedScope{Mode: SeedScopeHost},
+			url:             "https://WWW.example.co.uk:443/about",
+			expectedInScope: true,
+		},
+		{
+			name:            "success_host_subdomain",
+			scope:           config.SeedScope{Mode: SeedScopeHost},
+			url:             "https://shop.example.co.uk/",
+			expectedInScope: false,
+		},
+		{
+			name:            "success_domain_subdomain",
+			scope:           config.SeedScope{Mode: SeedScopeDomain},
+			url:             "https://shop.example.co.uk/",
+			expectedInScope: true,
+		},
+		{
+			name:            "success_domain_apex",
+			scope:           config.SeedScope{Mode: SeedScopeDomain},
+			url:             "https://example.co.uk/",
+			expectedInScope: true,
+		},
+		{
+			name:            "success_domain_other_under_same_suffix",
+			scope:           config.SeedScope{Mode: SeedScopeDomain},
+			url:             "https://www.other.co.uk/",
+			expectedInScope: false,
+		},
+		{
+			name:            "success_hosts_listed",
+			scope:           config.SeedScope{Mode: SeedScopeHosts, Hosts: []string{"partner.org"}},
+			url:             "https://docs.partner.org/",
+			expectedInScope: true,
+		},
+		{
+			name:            "success_hosts_own_host",
+			scope:           config.SeedScope{Mode: SeedScopeHosts, Hosts: []string{"partner.org"}},
+			url:             "https://www.example.co.uk/about",
+			expectedInScope: true,
+		},
+		{
+			name:            "success_hosts_unlisted",
+			scope:           config.SeedScope{Mode: SeedScopeHosts, Hosts: []string{"partner.org"}},
+			url:             "https://shop.example.co.uk/",
+			expectedInScope: false,
+		},
+		{
+			name:            "success_seed_override",
+			scope:           config.SeedScope{Mode: SeedScopeAny},
+			seedScopes:      map[string]config.SeedScope{"https://WWW.EXAMPLE.CO.UK": {Mode: SeedScopeHost}},
+			url:             "https://www.google.com/",
+			expectedInScope: false,
+		},
+		{
+			name:            "fail_url_form",
+			scope:           config.SeedScope{Mode: SeedScopeHost},
+			url:             "http://%zz",
+			expectedInScope: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withConfig(t, func(conf *config.Config) {
+				conf.SeedScope, conf.SeedScopes = test.scope, test.seedScopes
+			})
+
+			e := CheckSeedScope("https://www.example.co.uk/", test.url)
+
+			if inScope := e == nil; inScope != test.expectedInScope {
+				t.Errorf("result mismatch.\n- received: %t (%v)\n- expected: %t", inScope, e, test.expectedInScope)
+			}
+		})
+	}
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := map[string]string{
+		"www.example.com":        "example.com",
+		"a.b.example.co.uk":      "example.co.uk",
+		"example.com":            "example.com",
+		"127.0.0.1":              "127.0.0.1",
+		"localhost":              "localhost",
+		"::1":                    "::1",
+		"user.github.io":         "user.github.io",
+		"project.user.github.io": "user.github.io",
+	}
+
+	for host, expected := range tests {
+		if received := registrableDomain(host); received != expected {
+			t.Errorf("domain mismatch for [%s].\n- received: %s\n- expected: %s", host, received, expected)
+		}
+	}
+}
+
+func TestCrawlSeedScope(t *testing.T) {
+	var externalHits atomic.Int32
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		externalHits.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<p>external</p>`)
+	}))
+	defer external.Close()
+
+	// the external site is reached through "localhost", a different host to the seed's "127.0.0.1"
+	externalURL := strings.Replace(external.URL, "127.0.0.1", "localhost", 1) + "/page"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintf(w, `<a href="/about">about</a><a href="%s">external</a>`, externalURL)
+		case "/about":
+			fmt.Fprint(w, `<p>about</p>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name                 string
+		mode                 string
+		recordOutOfScope     bool
+		expectedChildren     int
+		expectedExternalHits int32
+	}{
+		{name: "success_any", mode: SeedScopeAny, expectedChildren: 2, expectedExternalHits: 1},
+		{name: "success_host_dropped", mode: SeedScopeHost, expectedChildren: 1},
+		{name: "success_host_recorded", mode: SeedScopeHost, recordOutOfScope: true, expectedChildren: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			externalHits.Store(0)
+			_, seed := crawlFixture(t, server.URL, fixtureConfig(func(conf *config.Config) {
+				conf.SeedScope = config.SeedScope{Mode: test.mode}
+				conf.RecordOutOfScope = test.recordOutOfScope
+			}))
+
+			if len(seed.Children) != test.expectedChildren {
+				t.Errorf("children mismatch.\n- received: %d\n- expected: %d", len(seed.Children), test.expectedChildren)
+			}
+			if hits := externalHits.Load(); hits != test.expectedExternalHits {
+				t.Errorf("external hits mismatch.\n- received: %d\n- expected: %d", hits, test.expectedExternalHits)
+			}
+
+			// an out of scope link that's recorded is a leaf, rejected by its rule without being fetched
+			for _, child := range seed.Children {
+				if child.URL != externalURL || test.mode == SeedScopeAny {
+					continue
+				}
+				if RejectionRule(child.Error) != RuleOutOfScope || child.Fetch != nil {
+					t.Errorf("out of scope page mismatch - rule [%s], fetch [%v]", RejectionRule(child.Error), child.Fetch)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckBlacklist(t *testing.T) {
+	tests := []struct {
+		name                string
+		blacklist           []string
+		url                 string
+		expectedBlacklisted bool
+		expectedError       bool
+	}{
+		{name: "success_url_entry", blacklist: []string{"https://ads.example.com"}, url: "https://ads.example.com/banner", expectedBlacklisted: true},
+		{name: "success_url_entry_subdomain", blacklist: []string{"https://ads.example.com"}, url: "https://eu.ads.example.com/banner", expectedBlacklisted: true},
+		{name: "success_bare_host", blacklist: []string{"ads.example.com"}, url: "http://eu.ads.example.com:8080/", expectedBlacklisted: true},
+		{name: "success_bare_host_case", blacklist: []string{"ADS.Example.com"}, url: "https://ads.example.com/", expectedBlacklisted: true},
+		{name: "success_bare_host_parent_allowed", blacklist: []string{"ads.example.com"}, url: "https://example.com/", expectedBlacklisted: false},
+		{name: "success_similar_host_allowed", blacklist: []string{"example.com"}, url: "https://badexample.com/", expectedBlacklisted: false},
+		{name: "success_wildcard_subdomain", blacklist: []string{"*.example.com"}, url: "https://a.b.example.com/", expectedBlacklisted: true},
+		{name: "success_wildcard_apex_allowed", blacklist: []string{"*.example.com"}, url: "https://example.com/", expectedBlacklisted: false},
+		{name: "success_cidr", blacklist: []string{"10.0.0.0/8"}, url: "http://10.1.2.3/admin", expectedBlacklisted: true},
+		{name: "success_cidr_outside", blacklist: []string{"10.0.0.0/8"}, url: "http://11.1.2.3/", expectedBlacklisted: false},
+		{name: "success_cidr_named_host", blacklist: []string{"10.0.0.0/8"}, url: "http://ten.example.com/", expectedBlacklisted: false},
+		{name: "success_cidr_ipv6", blacklist: []string{"fd00::/8"}, url: "http://[fd12::1]:8080/", expectedBlacklisted: true},
+		{name: "success_path_scoped", blacklist: []string{"example.com/private"}, url: "https://www.example.com/private/page", expectedBlacklisted: true},
+		{name: "success_path_scoped_exact", blacklist: []string{"https://example.com/private/"}, url: "https://example.com/private", expectedBlacklisted: true},
+		{name: "success_path_scoped_other_path", blacklist: []string{"example.com/private"}, url: "https://example.com/privateer", expectedBlacklisted: false},
+		{name: "success_wildcard_path_scoped", blacklist: []string{"*.example.com/admin"}, url: "https://shop.example.com/admin/users", expectedBlacklisted: true},
+		{name: "fail_invalid_entry", blacklist: []string{"*", "example.com"}, url: "https://example.com/", expectedError: true},
+		{name: "success_no_blacklist", url: "https://example.com/", expectedBlacklisted: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// an entry that can't be parsed makes the config invalid, rather than being skipped
+			if test.expectedError {
+				invalid := *config.Get()
+				invalid.BlacklistedURLs = test.blacklist
+				if e := invalid.Validate(); e == nil {
+					t.Errorf("invalid blacklist accepted - %v", test.blacklist)
+				}
+				return
+			}
+			withConfig(t, func(conf *config.Config) {
+				conf.BlacklistedURLs = test.blacklist
+			})
+
+			e := CheckBlacklist(test.url)
+
+			if blacklisted := e != nil; blacklisted != test.expectedBlacklisted {
+				t.Errorf("result mismatch.\n- received: %t (%v)\n- expected: %t", blacklisted, e, test.expectedBlacklisted)
+			}
+		})
+	}
+}
+
+func TestParseBlacklistEntry(t *testing.T) {
+	tests := []struct {
+		entry         string
+		expected      string
+		expectedError bool
+	}{
+		{entry: "https://www.example.com", expected: "host [www.example.com] wildcard [false] path []"},
+		{entry: " example.com/private/ ", expected: "host [example.com] wildcard [false] path [/private/]"},
+		{entry: "*.example.com", expected: "host [example.com] wildcard [true] path []"},
+		{entry: "192.168.0.0/16", expected: "network [192.168.0.0/16]"},
+		{entry: "*", expectedError: true},
+		{entry: "https://", expectedError: true},
+		{entry: "10.0.0.0/33", expectedError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.entry, func(t *testing.T) {
+			parsed, e := config.ParseBlacklistEntry(test.entry)
+			if (e != nil) != test.expectedError {
+				t.Fatalf("error mismatch.\n- received: %v\n- expected error: %t", e, test.expectedError)
+			}
+			if e != nil {
+				return
+			}
+
+			received := fmt.Sprintf("host [%s] wildcard [%t] path [%s]", parsed.Host, parsed.Wildcard, parsed.Path)
+			if parsed.Network != nil {
+				received = fmt.Sprintf("network [%s]", parsed.Network)
+			}
+			if received != test.expected {
+				t.Errorf("entry mismatch.\n- received: %s\n- expected: %s", received, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	crawlerConfig "webcrawler/config/crawler"
+	logger "webcrawler/logger"
+)
+
+const (
+	// sitemapMaxBytes is the largest uncompressed sitemap allowed by the sitemaps protocol
+	sitemapMaxBytes = 50 * 1024 * 1024
+
+	// sitemapMaxNesting limits how many levels of sitemap index files are followed
+	sitemapMaxNesting = 3
+)
+
+// sitemapXML covers both sitemap files (<urlset>) and sitemap index files (<sitemapindex>)
+type sitemapXML struct {
+	XMLName  xml.Name
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// ParseSitemap reads a sitemap or sitemap index file, gzipped or not, and returns
+// the page URLs and nested sitemap URLs it lists
+func ParseSitemap(body io.Reader) (pageURLs []string, sitemapURLs []string, e error) {
+	buffered := bufio.NewReader(body)
+
+	// gzipped sitemaps are often served without a content encoding, so sniff for the gzip header
+	reader := io.Reader(buffered)
+	if header, _ := buffered.Peek(2); len(header) == 2 && header[0] == 0x1f && header[1] == 0x8b {
+		gzipReader, e := gzip.NewReader(buffered)
+		if e != nil {
+			return nil, nil, fmt.Errorf("could not decompress sitemap - %s", e)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	var parsed sitemapXML
+	if e = xml.NewDecoder(io.LimitReader(reader, sitemapMaxBytes)).Decode(&parsed); e != nil {
+		return nil, nil, fmt.Errorf("could not parse sitemap - %s", e)
+	}
+
+	switch parsed.XMLName.Local {
+	case "urlset", "sitemapindex":
+	default:
+		return nil, nil, fmt.Errorf("could not parse sitemap, unexpected root element [%s]", parsed.XMLName.Local)
+	}
+
+	for _, entry := range parsed.URLs {
+		if loc := strings.TrimSpace(entry.Loc); loc != "" {
+			pageURLs = append(pageURLs, loc)
+		}
+	}
+	for _, entry := range parsed.Sitemaps {
+		if loc := strings.TrimSpace(entry.Loc); loc != "" {
+			sitemapURLs = append(sitemapURLs, loc)
+		}
+	}
+	return
+}
+
+// FindSitemaps returns the sitemaps of the site the given seed URL belongs to - those listed
+// in its robots.txt, plus the conventional /sitemap.xml
+func (c *CrawlSession) FindSitemaps(seedURL string) (sitemapURLs []string) {
+	parsed, e := url.Parse(seedURL)
+	if e != nil || parsed.Host == "" {
+		logger.Errorf("could not find sitemaps, error parsing url [%s]", seedURL)
+		return
+	}
+
+	if robots := c.GetRobots(seedURL); robots != nil {
+		sitemapURLs = append(sitemapURLs, robots.Sitemaps...)
+	}
+
+	conventional := fmt.Sprintf("%s://%s/sitemap.xml", parsed.Scheme, parsed.Host)
+	for _, sitemapURL := range sitemapURLs {
+		if sitemapURL == conventional {
+			return
+		}
+	}
+	return append(sitemapURLs, conventional)
+}
+
+// GetSitemapPages creates depth-1 pages, children of the given seed page, for every URL listed in the seed site's
+// sitemaps that passes the same link rules as a link found in the seed, skipping any already present in existing.
+// Each page records the sitemap it came from
+func (c *CrawlSession) GetSitemapPages(seed *Page, existing []*Page) (pages []*Page) {
+	seen := map[string]bool{seed.URLHash: true}
+	for _, page := range existing {
+		seen[page.URLHash] = true
+	}
+
+	queue := c.FindSitemaps(seed.URL)
+	fetched := make(map[string]bool)
+
+	for nesting := 0; nesting < sitemapMaxNesting && len(queue) > 0; nesting++ {
+		var nested []string
+
+		for _, sitemapURL := range queue {
+			if fetched[sitemapURL] {
+				continue
+			}
+			fetched[sitemapURL] = true
+
+			if c.Context.Err() != nil {
+				return
+			}
+
+			pageURLs, sitemapURLs, e := c.FetchSitemap(sitemapURL)
+			if e != nil {
+				logger.Warnf("could not read sitemap [%s] - %s", sitemapURL, e)
+				continue
+			}
+
+			for _, pageURL := range pageURLs {
+				// the same as a link found in the seed - resolved, normalized, and checked by the link rules
+				link, e := FixLinkForm(seed.URL, pageURL)
+				if e == nil {
+					link, e = CanonicalizeURL(link)
+				}
+				if e != nil {
+					logger.Errorf("problem with link [%s] in sitemap [%s] - %s", pageURL, sitemapURL, e)
+					continue
+				}
+
+				// duplicates are found by hash rather than by the link rules, as a sitemap may list thousands of pages
+				page := NewPage(link, link, seed.Depth+1, seed)
+				if seen[page.URLHash] {
+					continue
+				}
+				seen[page.URLHash] = true
+
+				invalid := seed.checkChild(link, link, nil)
+				c.Decisions.Record(decisionFor(StageLink, link, invalid))
+				if invalid != nil {
+					continue
+				}
+				page.Source = sitemapURL
+				pages = append(pages, page)
+			}
+			nested = append(nested, sitemapURLs...)
+		}
+		queue = nested
+	}
+
+	logger.Infof("found [%d] new pages in sitemaps of seed [%s]", len(pages), seed.URL)
+	return
+}
+
+// FetchSitemap fetches and parses the sitemap at the given URL, pacing the hit like any other to its host, and
+// counting its bytes against the crawl's budget. A sitemap its host's robots.txt disallows isn't fetched,
+// whether found there or listed in a sitemap index
+func (c *CrawlSession) FetchSitemap(sitemapURL string) (pageURLs []string, sitemapURLs []string, e error) {
+	logger.Infof("fetching sitemap [%s]", sitemapURL)
+
+	if allowed, rule := c.GetRobots(sitemapURL).IsAllowed(crawlerConfig.Get().UserAgent, sitemapURL); !allowed {
+		if rule != nil {
+			return nil, nil, fmt.Errorf("sitemap [%s] disallowed by robots.txt rule [%s]", sitemapURL, rule)
+		}
+		return nil, nil, fmt.Errorf("sitemap [%s] not fetched, robots.txt unreachable", sitemapURL)
+	}
+
+	req, e := http.NewRequestWithContext(c.Context, http.MethodGet, sitemapURL, nil)
+	if e != nil {
+		return nil, nil, fmt.Errorf("error creating GET request for sitemap [%s] - %s", sitemapURL, e)
+	}
+	if userAgent := crawlerConfig.Get().UserAgent; userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	pacer := c.GetHostPacer(sitemapURL)
+	if e = pacer.Wait(c.Context); e != nil {
+		return nil, nil, fmt.Errorf("sitemap [%s] not fetched - %s", sitemapURL, e)
+	}
+	pacer.Hit()
+
+	response, e := c.Client.Do(req)
+	if e != nil {
+		return nil, nil, fmt.Errorf("error fetching sitemap [%s] - %s", sitemapURL, e)
+	}
+	defer response.Body.Close()
+
+	c.applyRetryAfter(sitemapURL, response)
+
+	status := response.StatusCode
+	if status < 200 || status > 299 {
+		return nil, nil, fmt.Errorf("could not fetch sitemap [%s], status code [%d]", sitemapURL, status)
+	}
+
+	var read byteCounter
+	defer func() { c.Budget.spendBytes(int64(read)) }()
+	return ParseSitemap(io.TeeReader(response.Body, &read))
+}
+
+// byteCounter counts the bytes written to it, e.g. those of a sitemap as it's read
+type byteCounter int64
+
+func (n *byteCounter) Write(p []byte) (int, error) {
+	*n += byteCounter(len(p))
+	return len(p), nil
+}
+
+// addSitemapPages adds the pages listed in a seed's sitemaps to its children and submits them, in the background so
+// fetching them doesn't hold up a crawl slot - the seed is only finished once they're submitted, so the crawl isn't
+// done before they are
+func (c *CrawlSession) addSitemapPages(seed *Page) {
+	c.goroutines.Add(1)
+	go func() {
+		defer c.goroutines.Done()
+		defer c.finish(seed)
+
+		c.treeMutex.Lock()
+		existing := seed.Children
+		c.treeMutex.Unlock()
+
+		pages := c.GetSitemapPages(seed, existing)
+
+		c.treeMutex.Lock()
+		for _, page := range pages {
+			page.index = len(seed.Children)
+			seed.Children = append(seed.Children, page)
+		}
+		c.treeMutex.Unlock()
+
+		for _, page := range pages {
+			if !c.Submit(page) {
+				return
+			}
+		}
+	}()
+}
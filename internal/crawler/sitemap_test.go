@@ -0,0 +1,211 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	config "webcrawler/config/crawler"
+	testutil "webcrawler/test/util"
+)
+
+func gzipString(content string) string {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	writer.Write([]byte(content))
+	writer.Close()
+	return buffer.String()
+}
+
+func TestParseSitemap(t *testing.T) {
+	urlset := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>https://www.google.com/a</loc><lastmod>2024-01-01</lastmod></url>
+	<url><loc> https://www.google.com/b </loc></url>
+	<url><loc></loc></url>
+</urlset>`
+
+	tests := []struct {
+		name                string
+		content             string
+		expectedPageURLs    []string
+		expectedSitemapURLs []string
+		expectedError       bool
+	}{
+		{
+			name:             "success_urlset",
+			content:          urlset,
+			expectedPageURLs: []string{"https://www.google.com/a", "https://www.google.com/b"},
+		},
+		{
+			name:             "success_gzipped",
+			content:          gzipString(urlset),
+			expectedPageURLs: []string{"https://www.google.com/a", "https://www.google.com/b"},
+		},
+		{
+			name: "success_index",
+			content: `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>https://www.google.com/sitemap1.xml</loc></sitemap>
+	<sitemap><loc>https://www.google.com/sitemap2.xml.gz</loc></sitemap>
+</sitemapindex>`,
+			expectedSitemapURLs: []string{"https://www.google.com/sitemap1.xml", "https://www.google.com/sitemap2.xml.gz"},
+		},
+		{
+			name:          "fail_not_sitemap",
+			content:       `<html><body>not a sitemap</body></html>`,
+			expectedError: true,
+		},
+		{
+			name:          "fail_not_xml",
+			content:       `User-agent: *`,
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			pageURLs, sitemapURLs, e := ParseSitemap(strings.NewReader(test.content))
+
+			if test.expectedError != (e != nil) {
+				t.Errorf("error mismatch.\n- received: %v\n- expected error: %t", e, test.expectedError)
+			}
+			if strings.Join(pageURLs, ",") != strings.Join(test.expectedPageURLs, ",") {
+				t.Errorf("page urls mismatch.\n- received: %v\n- expected: %v", pageURLs, test.expectedPageURLs)
+			}
+			if strings.Join(sitemapURLs, ",") != strings.Join(test.expectedSitemapURLs, ",") {
+				t.Errorf("sitemap urls mismatch.\n- received: %v\n- expected: %v", sitemapURLs, test.expectedSitemapURLs)
+			}
+		})
+	}
+}
+
+func TestGetSitemapPages(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.DomainHitDelayMS = 0
+	})
+
+	logBuffer := testutil.GetLogBuffer()
+
+	pages := make(map[string]testutil.TestPage)
+	server := testutil.GetTestSite(pages)
+	defer server.Close()
+
+	pages["/robots.txt"] = testutil.TestPage{Body: "User-agent: *\nAllow: /\nDisallow: /private/\nSitemap: " + server.URL + "/sitemap_index.xml"}
+	pages["/sitemap_index.xml"] = testutil.TestPage{Body: `<sitemapindex>
+		<sitemap><loc>` + server.URL + `/posts.xml.gz</loc></sitemap>
+		<sitemap><loc>` + server.URL + `/missing.xml</loc></sitemap>
+		<sitemap><loc>` + server.URL + `/private/sitemap.xml</loc></sitemap>
+	</sitemapindex>`}
+	pages["/private/sitemap.xml"] = testutil.TestPage{Body: `<urlset><url><loc>` + server.URL + `/private/1</loc></url></urlset>`}
+	pages["/posts.xml.gz"] = testutil.TestPage{
+		Body:    gzipString(`<urlset><url><loc>` + server.URL + `/posts/1</loc></url><url><loc>` + server.URL + `/posts/2</loc></url><url><loc>` + server.URL + `/linked</loc></url></urlset>`),
+		Headers: map[string]string{"Content-Type": "application/x-gzip"}}
+	pages["/sitemap.xml"] = testutil.TestPage{Body: `<urlset>
+		<url><loc>` + server.URL + `/</loc></url>
+		<url><loc>` + server.URL + `/about</loc></url>
+		<url><loc>` + server.URL + `/posts/1</loc></url>
+		<url><loc>` + server.URL + `/about#team</loc></url>
+		<url><loc>` + server.URL + `/logo.png</loc></url>
+	</urlset>`}
+
+	session := NewCrawlSession(3)
+	seed := NewPage(server.URL+"/", server.URL, 0, nil)
+	linked := []*Page{NewPage(server.URL+"/linked", "linked", 1, seed)}
+
+	found := session.GetSitemapPages(seed, linked)
+
+	t.Log(logBuffer.String())
+
+	// sitemaps disallowed by robots.txt aren't fetched, even when listed in a sitemap index
+	if strings.Contains(logBuffer.String(), "test site hit - path [/private/sitemap.xml]") {
+		t.Error("sitemap disallowed by robots.txt was fetched")
+	}
+
+	expected := map[string]string{
+		server.URL + "/posts/1": server.URL + "/sitemap.xml",
+		server.URL + "/posts/2": server.URL + "/posts.xml.gz",
+		server.URL + "/about":   server.URL + "/sitemap.xml",
+	}
+	// sitemap pages go through the same link rules as links found in the seed, so e.g. ignore_if_contains applies
+	if rejections := session.Decisions.Rejections(); len(rejections) != 1 || rejections[0].Rule != RuleIgnoreIfContains {
+		t.Errorf("sitemap link rejections mismatch - %+v", rejections)
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("sitemap page count mismatch.\n- received: %d\n- expected: %d", len(found), len(expected))
+	}
+	for _, page := range found {
+		source, ok := expected[page.URL]
+		if !ok {
+			t.Errorf("unexpected sitemap page [%s]", page.URL)
+			continue
+		}
+		if page.Source != source {
+			t.Errorf("source mismatch for [%s].\n- received: %s\n- expected: %s", page.URL, page.Source, source)
+		}
+		if page.Depth != 1 || page.Parent != seed {
+			t.Errorf("sitemap page [%s] not a depth-1 child of the seed", page.URL)
+		}
+	}
+}
+
+func TestCrawlSitemaps(t *testing.T) {
+	tests := []struct {
+		name             string
+		useSitemaps      bool
+		expectedChildren []string
+	}{
+		{name: "success_sitemaps", useSitemaps: true, expectedChildren: []string{"/linked", "/listed"}},
+		{name: "success_no_sitemaps", expectedChildren: []string{"/linked"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the sitemap only answers once the page linked from the seed has been crawled, which
+			// with a single crawl slot can only happen if the sitemap isn't fetched in the seed's crawl
+			linkedCrawled := make(chan bool)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/":
+					w.Header().Set("Content-Type", "text/html")
+					fmt.Fprint(w, `<a href="/linked">linked</a>`)
+				case "/linked":
+					w.Header().Set("Content-Type", "text/html")
+					fmt.Fprint(w, "<html>linked</html>")
+					close(linkedCrawled)
+				case "/sitemap.xml":
+					select {
+					case <-linkedCrawled:
+						fmt.Fprintf(w, `<urlset><url><loc>http://%s/listed</loc></url></urlset>`, r.Host)
+					case <-time.After(2 * time.Second):
+						http.NotFound(w, r)
+					}
+				default:
+					w.Header().Set("Content-Type", "text/html")
+					fmt.Fprint(w, "<html>listed</html>")
+				}
+			}))
+			defer server.Close()
+
+			_, seed := crawlFixture(t, server.URL, fixtureConfig(func(conf *config.Config) {
+				conf.UseSitemaps = test.useSitemaps
+			}))
+
+			var children []string
+			for _, child := range seed.Children {
+				children = append(children, strings.TrimPrefix(child.URL, server.URL))
+				if child.Fetch == nil {
+					t.Errorf("child [%s] not crawled", child.URL)
+				}
+			}
+			if fmt.Sprint(children) != fmt.Sprint(test.expectedChildren) {
+				t.Errorf("children mismatch.\n- received: %v\n- expected: %v", children, test.expectedChildren)
+			}
+		})
+	}
+}
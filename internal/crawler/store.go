@@ -0,0 +1,535 @@
+package crawler
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	crawlerConfig "webcrawler/config/crawler"
+	"webcrawler/internal/util"
+	logger "webcrawler/logger"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// storeFile is the name of the database file kept within the configured store directory
+const storeFile = "crawl.db"
+
+// buckets of the store - frontier buckets are named per host
+const (
+	bucketVisited  = "visited"
+	bucketSeen     = "seen"
+	bucketBodies   = "bodies"
+	bucketFrontier = "frontier:"
+)
+
+// PageLocator links the pages saved in a disk frontier back to their place in the crawl's site trees,
+// by the id of their parent and their index among its children, without walking the trees to find them
+type PageLocator interface {
+	// PageID returns the id of a page, giving it one the first time it's asked for
+	PageID(page *Page) uint64
+
+	// PageAt returns the page at the given index among the children of the page with the given id, or among
+	// the seeds if the id is 0, along with that parent - either is nil if there's no such page
+	PageAt(parentID uint64, index int) (parent *Page, page *Page)
+}
+
+// DiskStore keeps the state of a crawl in an embedded key-value store on disk, so memory doesn't grow
+// with its frontiers, visited sets or page bodies. It is scratch space for a single crawl - the
+// database is recreated when opened, and resuming relies on checkpoints as usual
+type DiskStore struct {
+	db *bolt.DB
+}
+
+// OpenDiskStore creates a new store database within the given directory, replacing any left by an earlier crawl
+func OpenDiskStore(dir string) (store *DiskStore, e error) {
+	if e = os.MkdirAll(dir, 0755); e != nil {
+		return nil, fmt.Errorf("could not create store directory [%s] - %s", dir, e)
+	}
+
+	path := filepath.Join(dir, storeFile)
+	if e = os.Remove(path); e != nil && !os.IsNotExist(e) {
+		return nil, fmt.Errorf("could not remove old store [%s] - %s", path, e)
+	}
+
+	// the store never outlives the crawl, so there's no need to pay for syncing every write
+	db, e := bolt.Open(path, 0644, &bolt.Options{NoSync: true, NoFreelistSync: true})
+	if e != nil {
+		return nil, fmt.Errorf("could not open store [%s] - %s", path, e)
+	}
+	return &DiskStore{db: db}, nil
+}
+
+// Close closes the store's database
+func (s *DiskStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *DiskStore) createBucket(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists([]byte(name))
+		return e
+	})
+}
+
+// Set returns the named set of keys held in the store
+func (s *DiskStore) Set(name string) (*DiskSet, error) {
+	if e := s.createBucket(name); e != nil {
+		return nil, fmt.Errorf("could not create set [%s] - %s", name, e)
+	}
+	return &DiskSet{db: s.db, bucket: []byte(name)}, nil
+}
+
+// bodyChunkSize is the most of a page's body held in memory as it's written to the store
+const bodyChunkSize = 64 * 1024
+
+// PutBody saves the body of the page with the given url hash
+func (s *DiskStore) PutBody(urlHash string, body []byte) error {
+	writer := s.bodyWriter(urlHash)
+	writer.Write(body)
+	return writer.keep(nil)
+}
+
+// GetBody returns the saved body of the page with the given url hash, or nil if none was saved
+func (s *DiskStore) GetBody(urlHash string) (body []byte, e error) {
+	e = s.db.View(func(tx *bolt.Tx) error {
+		bodies := tx.Bucket([]byte(bucketBodies))
+		if bodies == nil {
+			return nil
+		}
+		chunks := bodies.Bucket([]byte(urlHash))
+		if chunks == nil {
+			return nil
+		}
+		// bolt's slices are only valid within the transaction
+		body = []byte{}
+		return chunks.ForEach(func(_, chunk []byte) error {
+			body = append(body, chunk...)
+			return nil
+		})
+	})
+	return
+}
+
+// bodyWriter streams a page's body into the store as it's read, in chunks kept under the page's url hash
+// in the order they were written, so no more than a chunk of it is ever held in memory
+type bodyWriter struct {
+	store   *DiskStore
+	urlHash []byte
+	chunk   []byte
+	chunks  uint32
+	e       error
+}
+
+func (s *DiskStore) bodyWriter(urlHash string) *bodyWriter {
+	return &bodyWriter{store: s, urlHash: []byte(urlHash), chunk: make([]byte, 0, bodyChunkSize)}
+}
+
+// Write adds to the body, saving each chunk as it fills. A failure to save is kept and returned from then on
+func (w *bodyWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 && w.e == nil {
+		n := min(len(p), bodyChunkSize-len(w.chunk))
+		w.chunk = append(w.chunk, p[:n]...)
+		p = p[n:]
+		if len(w.chunk) == bodyChunkSize {
+			w.flush()
+		}
+	}
+	if w.e != nil {
+		return 0, w.e
+	}
+	return written, nil
+}
+
+// flush saves the chunk written so far, replacing any body saved earlier, e.g. by a failed attempt, with the first
+func (w *bodyWriter) flush() {
+	w.e = w.store.db.Update(func(tx *bolt.Tx) error {
+		bodies, e := tx.CreateBucketIfNotExists([]byte(bucketBodies))
+		if e != nil {
+			return e
+		}
+		if w.chunks == 0 {
+			if e = bodies.DeleteBucket(w.urlHash); e != nil && e != bolt.ErrBucketNotFound {
+				return e
+			}
+		}
+		chunks, e := bodies.CreateBucketIfNotExists(w.urlHash)
+		if e != nil {
+			return e
+		}
+		return chunks.Put(binary.BigEndian.AppendUint32(nil, w.chunks), w.chunk)
+	})
+	w.chunks++
+	w.chunk = w.chunk[:0]
+}
+
+// keep saves the rest of the body - the page itself keeps nothing of it, it being read back from the store when needed
+func (w *bodyWriter) keep(page *Page) error {
+	if w.e == nil && (len(w.chunk) > 0 || w.chunks == 0) {
+		w.flush()
+	}
+	return w.e
+}
+
+// discard removes whatever of the body has been saved already
+func (w *bodyWriter) discard() {
+	if w.chunks == 0 {
+		return
+	}
+	w.store.db.Update(func(tx *bolt.Tx) error {
+		if bodies := tx.Bucket([]byte(bucketBodies)); bodies != nil {
+			bodies.DeleteBucket(w.urlHash)
+		}
+		return nil
+	})
+}
+
+// DiskSet is a VisitedStore held in a bucket of a DiskStore
+type DiskSet struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// Add records a key in the set, keeping the value already recorded if there is one
+func (s *DiskSet) Add(key string, val int) {
+	e := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.bucket)
+		if bucket.Get([]byte(key)) != nil {
+			return nil
+		}
+		return bucket.Put([]byte(key), binary.AppendVarint(nil, int64(val)))
+	})
+	if e != nil {
+		logger.Errorf("could not add key to disk set [%s] - %s", s.bucket, e)
+	}
+}
+
+// KeyExists checks whether a key is in the set, reporting it missing if the set can't be read
+func (s *DiskSet) KeyExists(key string) (ok bool) {
+	e := s.db.View(func(tx *bolt.Tx) error {
+		ok = tx.Bucket(s.bucket).Get([]byte(key)) != nil
+		return nil
+	})
+	if e != nil {
+		logger.Errorf("could not read disk set [%s] - %s", s.bucket, e)
+	}
+	return
+}
+
+// Keys returns a snapshot of the keys in the set
+func (s *DiskSet) Keys() (keys []string) {
+	e := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).ForEach(func(key, _ []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		})
+	})
+	if e != nil {
+		logger.Errorf("could not read disk set [%s] - %s", s.bucket, e)
+	}
+	return
+}
+
+// Len returns the number of keys in the set
+func (s *DiskSet) Len() (count int) {
+	e := s.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(s.bucket).Stats().KeyN
+		return nil
+	})
+	if e != nil {
+		logger.Errorf("could not read disk set [%s] - %s", s.bucket, e)
+	}
+	return
+}
+
+// Frontier returns a frontier for the given host held in the store. Pages are saved whole, as frontierRecords,
+// and ordered by their keys, so the store itself keeps them in the strategy's crawl order
+func (s *DiskStore) Frontier(host string, strategy string, score ScoreFunc, locator PageLocator) (*DiskFrontier, error) {
+	switch strategy {
+	case FrontierBFS, "", FrontierDFS:
+	case FrontierBestFirst:
+		if score == nil {
+			score = DefaultScore
+		}
+	default:
+		return nil, fmt.Errorf("unknown frontier strategy [%s]", strategy)
+	}
+
+	name := bucketFrontier + host
+	if e := s.createBucket(name); e != nil {
+		return nil, fmt.Errorf("could not create frontier for host [%s] - %s", host, e)
+	}
+
+	return &DiskFrontier{
+		frontierSignal: newFrontierSignal(),
+		db:             s.db,
+		bucket:         []byte(name),
+		strategy:       strategy,
+		score:          score,
+		locator:        locator}, nil
+}
+
+// DiskFrontier is a Frontier held in a bucket of a DiskStore
+type DiskFrontier struct {
+	frontierSignal
+	db       *bolt.DB
+	bucket   []byte
+	strategy string
+	score    ScoreFunc
+	locator  PageLocator
+
+	// guards the count, and keeps pops from racing each other for the same page
+	mutex   sync.Mutex
+	count   uint64
+	pending int
+}
+
+// frontierRecord is the form a page takes in a disk frontier - everything needed to crawl it, and
+// where it belongs in the site trees, so it can be rebuilt there if it's no longer to be found
+type frontierRecord struct {
+	ParentID   uint64   `json:"parent_id"`
+	Index      int      `json:"index"`
+	URL        string   `json:"url"`
+	LinkText   string   `json:"link_text"`
+	Depth      int      `json:"depth"`
+	Source     string   `json:"source,omitempty"`
+	LinkKind   string   `json:"link_kind,omitempty"`
+	Element    string   `json:"element,omitempty"`
+	Attribute  string   `json:"attribute,omitempty"`
+	RecordOnly bool     `json:"record_only,omitempty"`
+	Rel        []string `json:"rel,omitempty"`
+	Attempts   int      `json:"attempts,omitempty"`
+}
+
+// page rebuilds the recorded page as a child of the given parent, or as a seed if it's nil
+func (r *frontierRecord) page(parent *Page) *Page {
+	return &Page{
+		URL:        r.URL,
+		LinkText:   r.LinkText,
+		URLHash:    util.Hash(r.URL),
+		Parent:     parent,
+		Depth:      r.Depth,
+		Source:     r.Source,
+		LinkKind:   r.LinkKind,
+		Element:    r.Element,
+		Attribute:  r.Attribute,
+		RecordOnly: r.RecordOnly,
+		Rel:        r.Rel,
+		Attempts:   r.Attempts,
+		index:      r.Index}
+}
+
+// key orders the frontier's pages - by the order they were pushed, or for best-first frontiers, by score and then that order
+func (f *DiskFrontier) key(page *Page, order uint64) []byte {
+	key := make([]byte, 0, 16)
+	if f.strategy == FrontierBestFirst {
+		// flip the score's bits so keys sort highest score first
+		bits := math.Float64bits(f.score(page))
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		key = binary.BigEndian.AppendUint64(key, ^bits)
+	}
+	return binary.BigEndian.AppendUint64(key, order)
+}
+
+// Push saves a page to the frontier
+func (f *DiskFrontier) Push(page *Page) error {
+	record := frontierRecord{
+		Index:      page.index,
+		URL:        page.URL,
+		LinkText:   page.LinkText,
+		Depth:      page.Depth,
+		Source:     page.Source,
+		LinkKind:   page.LinkKind,
+		Element:    page.Element,
+		Attribute:  page.Attribute,
+		RecordOnly: page.RecordOnly,
+		Rel:        page.Rel,
+		Attempts:   page.Attempts}
+	if page.Parent != nil {
+		record.ParentID = f.locator.PageID(page.Parent)
+	}
+	value, e := json.Marshal(record)
+	if e != nil {
+		return fmt.Errorf("could not push page [%s] to disk frontier - %s", page.URL, e)
+	}
+
+	f.mutex.Lock()
+	key := f.key(page, f.count)
+	e = f.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(f.bucket).Put(key, value)
+	})
+	if e == nil {
+		f.count++
+		f.pending++
+	}
+	f.mutex.Unlock()
+
+	if e != nil {
+		return fmt.Errorf("could not push page [%s] to disk frontier - %s", page.URL, e)
+	}
+	f.notify()
+	return nil
+}
+
+// next reads the next page to crawl, removing it from the frontier if asked to. The page is the one at its
+// recorded place in the site trees, or if that's gone, one rebuilt from its record, so it's never lost
+func (f *DiskFrontier) next(remove bool) (page *Page) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	read := f.db.View
+	if remove {
+		read = f.db.Update
+	}
+
+	e := read(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(f.bucket).Cursor()
+
+		key, value := cursor.First()
+		if f.strategy == FrontierDFS {
+			key, value = cursor.Last()
+		}
+		if key == nil {
+			return nil
+		}
+
+		var record frontierRecord
+		if e := json.Unmarshal(value, &record); e != nil {
+			logger.Errorf("could not decode page [%s] saved in disk frontier [%s] - %s", value, f.bucket, e)
+		} else {
+			parent, found := f.locator.PageAt(record.ParentID, record.Index)
+			if found != nil && found.URL == record.URL {
+				page = found
+			} else {
+				logger.Warnf("page [%s] saved in disk frontier [%s] no longer in the site trees, rebuilding it", record.URL, f.bucket)
+				page = record.page(parent)
+			}
+		}
+
+		if remove {
+			if e := cursor.Delete(); e != nil {
+				return e
+			}
+			f.pending--
+		}
+		return nil
+	})
+	if e != nil {
+		logger.Errorf("could not read disk frontier [%s] - %s", f.bucket, e)
+	}
+	return page
+}
+
+// Pop removes and returns the next page to crawl
+func (f *DiskFrontier) Pop() *Page {
+	return f.next(true)
+}
+
+// Peek returns the next page to crawl
+func (f *DiskFrontier) Peek() *Page {
+	return f.next(false)
+}
+
+// Len returns the number of pages in the frontier
+func (f *DiskFrontier) Len() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.pending
+}
+
+// UseDiskStore moves the session's frontiers, visited sets and page bodies into a store in the given
+// directory. It must be called before the session is started, or restored from a checkpoint
+func (c *CrawlSession) UseDiskStore(dir string) error {
+	store, e := OpenDiskStore(dir)
+	if e != nil {
+		return e
+	}
+
+	// bloom filters are already small enough to stay in memory
+	if crawlerConfig.Get().VisitedStore != VisitedBloom {
+		visited, e := store.Set(bucketVisited)
+		if e != nil {
+			store.Close()
+			return e
+		}
+		seen, e := store.Set(bucketSeen)
+		if e != nil {
+			store.Close()
+			return e
+		}
+
+		c.VisitedURLs = visited
+		c.SeenContent = seen
+	}
+
+	c.Store = store
+	return nil
+}
+
+// Close releases the session's disk store, if it has one. The session must be stopped first
+func (c *CrawlSession) Close() error {
+	if c.Store == nil {
+		return nil
+	}
+	return c.Store.Close()
+}
+
+// PageContent returns the body of a crawled page, wherever it's kept
+func (c *CrawlSession) PageContent(page *Page) (string, error) {
+	if c.Store == nil {
+		return page.RawContent, nil
+	}
+
+	body, e := c.Store.GetBody(page.URLHash)
+	if e != nil {
+		return "", fmt.Errorf("could not read body of page [%s] from disk - %s", page.URL, e)
+	}
+	return string(body), nil
+}
+
+// PageID returns the id of a page, giving it one the first time it's asked for - only the pages
+// with children waiting in a disk frontier are given one, so they can be found by it
+func (c *CrawlSession) PageID(page *Page) uint64 {
+	c.pagesMutex.Lock()
+	defer c.pagesMutex.Unlock()
+
+	if page.id == 0 {
+		c.lastPageID++
+		page.id = c.lastPageID
+		c.pagesByID[page.id] = page
+	}
+	return page.id
+}
+
+// PageAt returns the page at the given index among the children of the page with the given id, or
+// among the seeds if the id is 0, along with that parent - either is nil if there's no such page
+func (c *CrawlSession) PageAt(parentID uint64, index int) (parent *Page, page *Page) {
+	if parentID != 0 {
+		c.pagesMutex.Lock()
+		parent = c.pagesByID[parentID]
+		c.pagesMutex.Unlock()
+		if parent == nil {
+			return nil, nil
+		}
+	}
+
+	c.treeMutex.Lock()
+	defer c.treeMutex.Unlock()
+
+	pages := c.Seeds
+	if parent != nil {
+		pages = parent.Children
+	}
+	if index < 0 || index >= len(pages) {
+		return parent, nil
+	}
+	return parent, pages[index]
+}
@@ -0,0 +1,270 @@
+package crawler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	config "webcrawler/config/crawler"
+	testutil "webcrawler/test/util"
+)
+
+func TestDiskSet(t *testing.T) {
+	store, e := OpenDiskStore(t.TempDir())
+	if e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+	defer store.Close()
+
+	set, e := store.Set(bucketVisited)
+	if e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+
+	set.Add("b", 1)
+	set.Add("a", 1)
+	set.Add("a", 2)
+
+	if !set.KeyExists("a") || !set.KeyExists("b") {
+		t.Error("missing expected keys")
+	}
+	if set.KeyExists("c") {
+		t.Error("unexpected key [c]")
+	}
+	if set.Len() != 2 {
+		t.Errorf("length mismatch.\n- received: %d\n- expected: 2", set.Len())
+	}
+
+	keys := set.Keys()
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "a,b" {
+		t.Errorf("keys mismatch.\n- received: %v\n- expected: [a b]", keys)
+	}
+}
+
+func TestOpenDiskStoreReplacesOldStore(t *testing.T) {
+	dir := t.TempDir()
+
+	store, e := OpenDiskStore(dir)
+	if e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+	set, _ := store.Set(bucketVisited)
+	set.Add("a", 1)
+	store.Close()
+
+	store, e = OpenDiskStore(dir)
+	if e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+	defer store.Close()
+
+	set, _ = store.Set(bucketVisited)
+	if set.Len() != 0 {
+		t.Errorf("old crawl's keys kept.\n- received: %v\n- expected: []", set.Keys())
+	}
+}
+
+func TestPageAt(t *testing.T) {
+	session := NewCrawlSession(3)
+
+	first := NewPage("https://a.com", "a", 0, nil)
+	second := NewPage("https://b.com", "b", 0, nil)
+	child := NewPage("https://b.com/1", "1", 1, second)
+	second.Children = []*Page{NewPage("https://b.com/0", "0", 1, second), child}
+	session.Seeds = []*Page{first, second}
+
+	secondID := session.PageID(second)
+	if secondID == 0 || session.PageID(second) != secondID {
+		t.Fatalf("page id not kept.\n- received: %d\n- expected: %d", session.PageID(second), secondID)
+	}
+	if session.PageID(first) == secondID {
+		t.Fatal("pages given the same id")
+	}
+
+	tests := []struct {
+		name           string
+		parentID       uint64
+		index          int
+		expectedParent *Page
+		expectedPage   *Page
+	}{
+		{name: "seed", index: 1, expectedPage: second},
+		{name: "child", parentID: secondID, index: 1, expectedParent: second, expectedPage: child},
+		{name: "missing_index", parentID: secondID, index: 5, expectedParent: second},
+		{name: "missing_parent", parentID: 100, index: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent, page := session.PageAt(test.parentID, test.index)
+			if parent != test.expectedParent {
+				t.Errorf("parent mismatch.\n- received: %v\n- expected: %v", parent, test.expectedParent)
+			}
+			if page != test.expectedPage {
+				t.Errorf("page mismatch.\n- received: %v\n- expected: %v", page, test.expectedPage)
+			}
+		})
+	}
+}
+
+func TestDiskFrontierOrder(t *testing.T) {
+	session := NewCrawlSession(3)
+	seed := NewPage("https://a.com", "a", 0, nil)
+	session.Seeds = []*Page{seed}
+
+	// pushed in this order, scored by the number in the url
+	for i, url := range []string{"https://a.com/2", "https://a.com/1", "https://a.com/3", "https://a.com/1"} {
+		child := NewPage(url, url, 1, seed)
+		child.index = i
+		seed.Children = append(seed.Children, child)
+	}
+	score := func(page *Page) float64 {
+		return float64(page.URL[len(page.URL)-1] - '0')
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		expected []int
+	}{
+		{name: "bfs", strategy: FrontierBFS, expected: []int{0, 1, 2, 3}},
+		{name: "dfs", strategy: FrontierDFS, expected: []int{3, 2, 1, 0}},
+		{name: "best_first", strategy: FrontierBestFirst, expected: []int{2, 0, 1, 3}},
+	}
+
+	store, e := OpenDiskStore(t.TempDir())
+	if e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+	defer store.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frontier, e := store.Frontier(test.name, test.strategy, score, session)
+			if e != nil {
+				t.Fatalf("unexpected error - %s", e)
+			}
+
+			for _, child := range seed.Children {
+				if e := frontier.Push(child); e != nil {
+					t.Fatalf("unexpected error - %s", e)
+				}
+			}
+			if frontier.Len() != len(seed.Children) {
+				t.Errorf("frontier length mismatch.\n- received: %d\n- expected: %d", frontier.Len(), len(seed.Children))
+			}
+
+			var popped []int
+			for frontier.Len() > 0 {
+				next := frontier.Peek()
+				page := frontier.Pop()
+				if page != next {
+					t.Errorf("peeked page [%s] not the one popped [%s]", next.URL, page.URL)
+				}
+				popped = append(popped, page.index)
+			}
+
+			if fmt.Sprint(popped) != fmt.Sprint(test.expected) {
+				t.Errorf("order mismatch.\n- received: %v\n- expected: %v", popped, test.expected)
+			}
+			if frontier.Pop() != nil {
+				t.Error("empty frontier returned a page")
+			}
+		})
+	}
+
+	if _, e := store.Frontier("random", "random", nil, session); e == nil {
+		t.Error("expected error for unknown strategy")
+	}
+
+	// a page no longer at its place in the site trees is rebuilt from what the frontier saved of it
+	frontier, e := store.Frontier("rebuilt", FrontierBFS, nil, session)
+	if e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+	missing := NewPage("https://a.com/missing", "missing", 1, seed)
+	missing.index, missing.LinkKind, missing.Rel, missing.Attempts = len(seed.Children), LinkIframe, []string{RelNoFollow}, 1
+	if e := frontier.Push(missing); e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+
+	rebuilt := frontier.Pop()
+	if rebuilt == nil || rebuilt == missing {
+		t.Fatalf("page not rebuilt - %v", rebuilt)
+	}
+	if rebuilt.URL != missing.URL || rebuilt.URLHash != missing.URLHash || rebuilt.Parent != seed || rebuilt.Depth != 1 ||
+		rebuilt.LinkKind != LinkIframe || fmt.Sprint(rebuilt.Rel) != fmt.Sprint(missing.Rel) || rebuilt.Attempts != 1 {
+		t.Errorf("rebuilt page mismatch.\n- received: %+v\n- expected: %+v", rebuilt, missing)
+	}
+}
+
+func TestDiskStoreCrawl(t *testing.T) {
+	pageCount := 15
+	fixture := testutil.GetFixtureSite(pageCount, 2)
+	defer fixture.Close()
+
+	var hitsMutex sync.Mutex
+	var hits []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := 0
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/page/"), "%d", &n)
+		hitsMutex.Lock()
+		hits = append(hits, n)
+		hitsMutex.Unlock()
+		fixture.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	// the disk frontier keeps the same order as the in-memory one
+	tests := []struct {
+		name     string
+		strategy string
+		expected []int
+	}{
+		{name: "bfs", strategy: FrontierBFS, expected: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}},
+		{name: "dfs", strategy: FrontierDFS, expected: []int{0, 2, 6, 14, 13, 5, 12, 11, 1, 4, 10, 9, 3, 8, 7}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hits = nil
+			session, seed := crawlFixture(t, server.URL,
+				fixtureConfig(func(conf *config.Config) {
+					conf.Frontier = test.strategy
+				}),
+				fixtureSession(func(session *CrawlSession) {
+					if e := session.UseDiskStore(t.TempDir()); e != nil {
+						t.Fatalf("unexpected error - %s", e)
+					}
+				}))
+			defer session.Close()
+
+			if fmt.Sprint(hits) != fmt.Sprint(test.expected) {
+				t.Errorf("visit order mismatch.\n- received: %v\n- expected: %v", hits, test.expected)
+			}
+
+			if visited := session.VisitedURLs.Len(); visited != pageCount {
+				t.Errorf("visited count mismatch.\n- received: %d\n- expected: %d", visited, pageCount)
+			}
+			if seen := session.SeenContent.Len(); seen != pageCount {
+				t.Errorf("seen count mismatch.\n- received: %d\n- expected: %d", seen, pageCount)
+			}
+
+			// bodies are spilled to disk rather than kept on the page
+			if seed.RawContent != "" {
+				t.Error("page body kept in memory")
+			}
+			content, e := session.PageContent(seed)
+			if e != nil {
+				t.Fatalf("unexpected error - %s", e)
+			}
+			if !strings.Contains(content, "Page 1") {
+				t.Errorf("page body not saved to disk.\n- received: %s", content)
+			}
+		})
+	}
+}
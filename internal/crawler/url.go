@@ -1,25 +1,52 @@
 package crawler
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"net/url"
+	"path"
+	"sort"
 	"strings"
 	crawlerConfig "webcrawler/config/crawler"
 	logger "webcrawler/logger"
+
+	"golang.org/x/net/idna"
+)
+
+// trailing slash policies that can be configured
+const (
+	TrailingSlashKeep   = "keep"
+	TrailingSlashAdd    = "add"
+	TrailingSlashRemove = "remove"
+)
+
+// query policy modes that can be configured
+const (
+	QueryKeep  = "keep"
+	QueryDrop  = "drop"
+	QueryAllow = "allow"
+	QueryDeny  = "deny"
 )
 
+// defaultPorts are the ports implied by each scheme when none is given
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
 // GetURLDomain returns the subdomain & domain portion of a given URL
 func GetURLDomain(urlString string) (domain string, e error) {
 	parsed, e := url.Parse(urlString)
 
 	if e != nil {
-		e = fmt.Errorf("could not derive URL domain, error parsing url [%s] - [%s]", urlString, e)
+		e = &CrawlError{Kind: ErrParse, URL: urlString, Err: fmt.Errorf("could not derive URL domain, error parsing url [%s] - [%w]", urlString, e)}
 		logger.Error(e)
 		return
 	}
 
 	if parsed.Host == "" {
-		e = fmt.Errorf("could not derive URL domain, incomplete URL provided - [%s]", urlString)
+		e = &CrawlError{Kind: ErrParse, URL: urlString, Err: fmt.Errorf("could not derive URL domain, incomplete URL provided - [%s]", urlString)}
 		logger.Error(e)
 		return
 	}
@@ -27,18 +54,18 @@ func GetURLDomain(urlString string) (domain string, e error) {
 	return parsed.Host, nil
 }
 
-// FixLinkForm fixes link form issues, e.g. checks if a link is relative (i.e. not fully-formed) and, if so, adds the schema and domain of its parent page
+// FixLinkForm fixes link form issues, i.e. checks if a link is relative (i.e. not fully-formed) and, if so,
+// resolves it against the URL of the page it was found in (or that page's base URL) following RFC 3986 section 5.2.
+// Spaces are kept, to be percent-encoded as %20 rather than dropped from the path
 func FixLinkForm(parentURL string, link string) (fixedLink string, e error) {
-	link = strings.ReplaceAll(link, " ", "")
-	
 	parsed, e := url.Parse(link)
 	if e != nil {
-		e = fmt.Errorf("could not test link format, error parsing url [%s] - [%s]", link, e)
+		e = &CrawlError{Kind: ErrParse, URL: link, Err: fmt.Errorf("could not test link format, error parsing url [%s] - [%w]", link, e)}
 		logger.Error(e)
 		return link, e
 	}
 
-	if parsed.Scheme != "" && parsed.Host != "" {
+	if parsed.IsAbs() {
 		return link, nil
 	}
 
@@ -46,51 +73,387 @@ func FixLinkForm(parentURL string, link string) (fixedLink string, e error) {
 
 	parent, e := url.Parse(parentURL)
 	if e != nil {
-		e = fmt.Errorf("could not fix link format, error parsing parent url [%s] - [%s]", parentURL, e)
+		e = &CrawlError{Kind: ErrParse, URL: link, Err: fmt.Errorf("could not fix link format, error parsing parent url [%s] - [%w]", parentURL, e)}
 		logger.Error(e)
 		return link, e
 	}
 
-	if parsed.Host == "" {
-		link = strings.TrimPrefix(link, ".")
-		link = strings.TrimPrefix(link, "/")
-	}
-
-	fixedLink = fmt.Sprintf("%s://%s/%s", parent.Scheme, parent.Host, link)
+	fixedLink = parent.ResolveReference(parsed).String()
 	logger.Infof("fixed relative link [%s] in page [%s]", fixedLink, parentURL)
 	return
 }
 
 // IsValidLink decides if a link is valid to be added to the page tree
 func IsValidLink(url string, linkText string, currentChildren []*Page) (isValid bool) {
-	if url == "" {
-		return false
-	}
-	if linkText == "" {
-		return false
+	return CheckValidLink(url, linkText, currentChildren) == nil
+}
+
+// CheckValidLink decides if a link is valid to be added to the page tree, like IsValidLink,
+// returning why not if it isn't - an ErrPolicy CrawlError naming the first link rule to reject it
+func CheckValidLink(url string, linkText string, currentChildren []*Page) error {
+	for _, rule := range linkRules {
+		if e := rule.check(url, linkText, currentChildren); e != nil {
+			return linkError(url, rule.name, e)
+		}
 	}
+	return nil
+}
 
-	// check if link already added to parent page children
-	for _, page := range currentChildren {
-		if page.URL == url {
-			return false
+// EvaluateLinkRules runs every link rule against a link, in order, and returns the decision of each - the
+// link is added to the page tree if all of them accept it, and otherwise rejected by the first that doesn't
+func EvaluateLinkRules(url string, linkText string, currentChildren []*Page) (decisions []Decision) {
+	for _, rule := range linkRules {
+		var e error
+		if reason := rule.check(url, linkText, currentChildren); reason != nil {
+			e = linkError(url, rule.name, reason)
 		}
+		decisions = append(decisions, newDecision(StageLink, rule.name, url, e))
 	}
+	return
+}
 
-	// get config to check for blacklisted sites
-	config := crawlerConfig.Get()
+// linkError creates the error for why a link wasn't added to the page tree - not logged, as links are rejected by the dozen
+func linkError(url string, rule string, reason error) error {
+	return &CrawlError{Kind: ErrPolicy, Rule: rule, URL: url, Err: fmt.Errorf("link [%s] not valid - %w", url, reason)}
+}
+
+// a linkRule is one of the checks a link must pass to be added to the page tree, returning why not if it fails
+type linkRule struct {
+	name  string
+	check func(url string, linkText string, currentChildren []*Page) error
+}
+
+// linkRules are the checks deciding whether a link is added to the page tree, in the order they're made
+var linkRules = []linkRule{
+	{name: RuleEmptyURL, check: func(url string, _ string, _ []*Page) error {
+		if url == "" {
+			return errors.New("empty url")
+		}
+		return nil
+	}},
+
+	{name: RuleNoLinkText, check: func(_ string, linkText string, _ []*Page) error {
+		if linkText == "" && !crawlerConfig.Get().KeepTextlessLinks {
+			return errors.New("no link text")
+		}
+		return nil
+	}},
+
+	// check if link already added to parent page children
+	{name: RuleDuplicateLink, check: func(url string, _ string, currentChildren []*Page) error {
+		for _, page := range currentChildren {
+			if page.URL == url {
+				return errors.New("already linked from the page")
+			}
+		}
+		return nil
+	}},
 
 	// check if blacklisted
-	for _, ignoreable := range config.IgnoreIfContains {
-		if strings.Contains(strings.ToLower(url), strings.ToLower(ignoreable)) {
-			return false
+	{name: RuleIgnoreIfContains, check: func(url string, _ string, _ []*Page) error {
+		for _, ignoreable := range crawlerConfig.Get().IgnoreIfContains {
+			if strings.Contains(strings.ToLower(url), strings.ToLower(ignoreable)) {
+				return fmt.Errorf("contains [%s]", ignoreable)
+			}
 		}
-	}
+		return nil
+	}},
 
-	return true
+	// check the link is in scope, by the configured include and exclude rules
+	{name: RuleScope, check: func(url string, _ string, _ []*Page) error {
+		return CheckScope(url)
+	}},
 }
 
 // TrimLinkVars returns a URL as just a combination of its schema, domain and path, removing any query params
 func TrimLinkVars(link string) string {
 	return strings.Split(link, "?")[0]
 }
+
+// NormalizeURL rewrites a URL into its canonical form following RFC 3986 section 6, so equivalent URLs
+// hash the same - the scheme and host are lowercased, internationalised hosts converted to punycode,
+// default ports dropped, percent-encodings normalised, dot segments removed, an empty path made "/"
+// and the fragment stripped. Trailing slashes are then kept, added or removed as configured
+func NormalizeURL(link string) (normalized string, e error) {
+	parsed, e := url.Parse(link)
+	if e != nil {
+		return link, &CrawlError{Kind: ErrParse, URL: link, Err: fmt.Errorf("could not normalize url, error parsing url [%s] - [%w]", link, e)}
+	}
+
+	// e.g. "mailto:someone@example.com" - nothing to normalize beyond the scheme
+	if parsed.Opaque != "" {
+		return fmt.Sprintf("%s:%s", parsed.Scheme, parsed.Opaque), nil
+	}
+
+	var builder strings.Builder
+	if parsed.Scheme != "" {
+		builder.WriteString(parsed.Scheme + ":")
+	}
+
+	if parsed.Host != "" {
+		host, e := normalizeHost(parsed)
+		if e != nil {
+			return link, &CrawlError{Kind: ErrParse, URL: link, Err: fmt.Errorf("could not normalize url [%s] - [%w]", link, e)}
+		}
+
+		builder.WriteString("//")
+		if parsed.User != nil {
+			builder.WriteString(parsed.User.String() + "@")
+		}
+		builder.WriteString(host)
+	}
+
+	path := removeDotSegments(normalizePercentEncoding(parsed.EscapedPath()))
+	if path == "" && parsed.Host != "" {
+		path = "/"
+	}
+	builder.WriteString(applyTrailingSlashPolicy(path, crawlerConfig.Get().TrailingSlash))
+
+	if parsed.RawQuery != "" || parsed.ForceQuery {
+		builder.WriteString("?" + normalizePercentEncoding(parsed.RawQuery))
+	}
+
+	return builder.String(), nil
+}
+
+// normalizeHost lowercases a URL's host, converts it to punycode if internationalised, and drops its port if it's the scheme's default
+func normalizeHost(parsed *url.URL) (host string, e error) {
+	host = strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+
+	for _, char := range host {
+		if char > 127 {
+			host, e = idna.Lookup.ToASCII(host)
+			if e != nil {
+				return "", fmt.Errorf("invalid internationalised host [%s] - %s", parsed.Hostname(), e)
+			}
+			break
+		}
+	}
+
+	// ipv6 addresses need their brackets back
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	port := parsed.Port()
+	if port == "" || port == defaultPorts[parsed.Scheme] {
+		return host, nil
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port), nil
+}
+
+// normalizePercentEncoding uppercases the hex digits of percent-encodings,
+// and decodes those of unreserved characters, which never need encoding
+func normalizePercentEncoding(escaped string) string {
+	var builder strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] != '%' || i+2 >= len(escaped) {
+			builder.WriteByte(escaped[i])
+			continue
+		}
+
+		decoded, ok := unhex(escaped[i+1], escaped[i+2])
+		if !ok {
+			builder.WriteByte(escaped[i])
+			continue
+		}
+
+		if isUnreserved(decoded) {
+			builder.WriteByte(decoded)
+		} else {
+			builder.WriteString(fmt.Sprintf("%%%02X", decoded))
+		}
+		i += 2
+	}
+	return builder.String()
+}
+
+func unhex(high, low byte) (byte, bool) {
+	value := 0
+	for _, char := range []byte{high, low} {
+		value <<= 4
+		switch {
+		case '0' <= char && char <= '9':
+			value |= int(char - '0')
+		case 'a' <= char && char <= 'f':
+			value |= int(char - 'a' + 10)
+		case 'A' <= char && char <= 'F':
+			value |= int(char - 'A' + 10)
+		default:
+			return 0, false
+		}
+	}
+	return byte(value), true
+}
+
+// isUnreserved reports whether a character is in RFC 3986's unreserved set
+func isUnreserved(char byte) bool {
+	return 'a' <= char && char <= 'z' || 'A' <= char && char <= 'Z' || '0' <= char && char <= '9' ||
+		char == '-' || char == '.' || char == '_' || char == '~'
+}
+
+// removeDotSegments resolves the "." and ".." segments of a path, following RFC 3986 section 5.2.4
+func removeDotSegments(path string) string {
+	var output []string
+	input := path
+
+	for input != "" {
+		switch {
+		case strings.HasPrefix(input, "../"):
+			input = input[3:]
+		case strings.HasPrefix(input, "./"):
+			input = input[2:]
+		case strings.HasPrefix(input, "/./"):
+			input = input[2:]
+		case input == "/.":
+			input = "/"
+		case strings.HasPrefix(input, "/../"):
+			input = input[3:]
+			if len(output) > 0 {
+				output = output[:len(output)-1]
+			}
+		case input == "/..":
+			input = "/"
+			if len(output) > 0 {
+				output = output[:len(output)-1]
+			}
+		case input == "." || input == "..":
+			input = ""
+		default:
+			// move the first segment, with its leading slash if it has one, to the output
+			end := strings.Index(input[1:], "/")
+			if end < 0 {
+				end = len(input)
+			} else {
+				end++
+			}
+			output = append(output, input[:end])
+			input = input[end:]
+		}
+	}
+	return strings.Join(output, "")
+}
+
+// applyTrailingSlashPolicy adds or removes the trailing slash of a path as configured. Slashes
+// are only added to paths whose last segment doesn't look like a file, e.g. "/about" but not "/about.html"
+func applyTrailingSlashPolicy(path string, policy string) string {
+	switch policy {
+	case TrailingSlashAdd:
+		last := path[strings.LastIndex(path, "/")+1:]
+		if path != "" && last != "" && !strings.Contains(last, ".") {
+			return path + "/"
+		}
+	case TrailingSlashRemove:
+		if len(path) > 1 {
+			return strings.TrimRight(path, "/")
+		}
+	}
+	return path
+}
+
+// CanonicalizeURL normalizes a URL and applies the query policy of its domain, so every URL of the same page is the same
+func CanonicalizeURL(link string) (string, error) {
+	normalized, e := NormalizeURL(link)
+	if e != nil {
+		return link, e
+	}
+	return ApplyQueryPolicy(normalized), nil
+}
+
+// ApplyQueryPolicy keeps only the query params of a URL its domain's query policy allows, sorted
+// by name and then value so the same params given in a different order make the same URL
+func ApplyQueryPolicy(link string) string {
+	base, query, found := strings.Cut(link, "?")
+	if !found {
+		return link
+	}
+
+	host := ""
+	if parsed, e := url.Parse(base); e == nil {
+		host = parsed.Hostname()
+	}
+
+	policy := QueryPolicyFor(host)
+	if policy.Mode == QueryDrop {
+		return base
+	}
+
+	type param struct {
+		name string
+		raw  string
+	}
+	var params []param
+	for _, raw := range strings.Split(query, "&") {
+		if raw == "" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(raw, "=")
+		if unescaped, e := url.QueryUnescape(name); e == nil {
+			name = unescaped
+		}
+
+		if policy.Keeps(name) {
+			params = append(params, param{name: name, raw: raw})
+		}
+	}
+
+	if len(params) == 0 {
+		return base
+	}
+
+	sort.SliceStable(params, func(i, j int) bool {
+		if params[i].name != params[j].name {
+			return params[i].name < params[j].name
+		}
+		return params[i].raw < params[j].raw
+	})
+
+	raws := make([]string, len(params))
+	for i, param := range params {
+		raws[i] = param.raw
+	}
+	return base + "?" + strings.Join(raws, "&")
+}
+
+// QueryPolicy decides which query params of a URL are kept
+type QueryPolicy crawlerConfig.QueryPolicy
+
+// QueryPolicyFor returns the query policy configured for the most specific domain
+// the given host falls under, or the default policy if there isn't one
+func QueryPolicyFor(host string) QueryPolicy {
+	config := crawlerConfig.Get()
+
+	policy, matched := config.QueryPolicy, ""
+	for domain, domainPolicy := range config.DomainQueryPolicies {
+		domain = strings.ToLower(domain)
+		if (host == domain || strings.HasSuffix(host, "."+domain)) && len(domain) > len(matched) {
+			policy, matched = domainPolicy, domain
+		}
+	}
+	return QueryPolicy(policy)
+}
+
+// Keeps decides whether a query param of the given name is kept
+func (p QueryPolicy) Keeps(name string) bool {
+	switch p.Mode {
+	case QueryKeep:
+		return true
+	case QueryAllow:
+		return p.lists(name)
+	case QueryDeny:
+		return !p.lists(name)
+	}
+	return false
+}
+
+// lists checks whether a param name matches any of the policy's params, ignoring case
+func (p QueryPolicy) lists(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range p.Params {
+		if matched, _ := path.Match(strings.ToLower(pattern), name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package crawler
 import (
 	"fmt"
 	"testing"
+	config "webcrawler/config/crawler"
 )
 
 func TestGetURLDomain(t *testing.T) {
@@ -124,6 +125,7 @@ func TestIsValidLink(t *testing.T) {
 		url             string
 		linkText        string
 		currentChildren []*Page
+		keepTextless    bool
 		expectedResult  bool
 	}{
 		{
@@ -154,6 +156,14 @@ func TestIsValidLink(t *testing.T) {
 			currentChildren: []*Page{},
 			expectedResult:  false,
 		},
+		{
+			name:            "success_valid_textless_kept",
+			url:             "https://www.google.com",
+			linkText:        "",
+			currentChildren: []*Page{},
+			keepTextless:    true,
+			expectedResult:  true,
+		},
 		{
 			name:            "success_nil_children",
 			url:             "https://www.google.com",
@@ -165,6 +175,9 @@ func TestIsValidLink(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
+			withConfig(t, func(conf *config.Config) {
+				conf.KeepTextlessLinks = test.keepTextless
+			})
 
 			isValidLink := IsValidLink(test.url, test.linkText, test.currentChildren)
 
@@ -209,3 +222,291 @@ func TestTrimLinkVars(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		name           string
+		link           string
+		trailingSlash  string
+		expectedResult string
+		errorExpected  bool
+	}{
+		// case
+		{name: "success_lowercase_scheme", link: "HTTP://example.com/", expectedResult: "http://example.com/"},
+		{name: "success_lowercase_host", link: "http://Example.COM/", expectedResult: "http://example.com/"},
+		{name: "success_path_case_kept", link: "http://example.com/About/Us", expectedResult: "http://example.com/About/Us"},
+		{name: "success_host_trailing_dot", link: "http://example.com./a", expectedResult: "http://example.com/a"},
+
+		// ports
+		{name: "success_default_http_port", link: "http://example.com:80/a", expectedResult: "http://example.com/a"},
+		{name: "success_default_https_port", link: "https://example.com:443/a", expectedResult: "https://example.com/a"},
+		{name: "success_empty_port", link: "http://example.com:/a", expectedResult: "http://example.com/a"},
+		{name: "success_other_port_kept", link: "http://example.com:8080/a", expectedResult: "http://example.com:8080/a"},
+		{name: "success_https_port_on_http_kept", link: "http://example.com:443/a", expectedResult: "http://example.com:443/a"},
+		{name: "success_ipv6", link: "http://[::1]:80/a", expectedResult: "http://[::1]/a"},
+		{name: "success_ipv6_port_kept", link: "http://[::1]:8080/a", expectedResult: "http://[::1]:8080/a"},
+
+		// empty path
+		{name: "success_empty_path", link: "http://example.com", expectedResult: "http://example.com/"},
+		{name: "success_empty_path_query", link: "http://example.com?a=b", expectedResult: "http://example.com/?a=b"},
+
+		// dot segments, including the examples of RFC 3986 section 5.2.4
+		{name: "success_dot_segments_rfc_1", link: "http://example.com/a/b/c/./../../g", expectedResult: "http://example.com/a/g"},
+		{name: "success_dot_segments_rfc_2", link: "http://example.com/mid/content=5/../6", expectedResult: "http://example.com/mid/6"},
+		{name: "success_dot_segment", link: "http://example.com/a/./b", expectedResult: "http://example.com/a/b"},
+		{name: "success_double_dot_segment", link: "http://example.com/a/../b/", expectedResult: "http://example.com/b/"},
+		{name: "success_dot_segments_above_root", link: "http://example.com/../../a", expectedResult: "http://example.com/a"},
+		{name: "success_trailing_dot", link: "http://example.com/a/.", expectedResult: "http://example.com/a/"},
+		{name: "success_trailing_double_dot", link: "http://example.com/a/b/..", expectedResult: "http://example.com/a/"},
+		{name: "success_dots_in_names_kept", link: "http://example.com/a..b/.c/d.", expectedResult: "http://example.com/a..b/.c/d."},
+		{name: "success_encoded_dot_segment", link: "http://example.com/a/%2E%2E/b", expectedResult: "http://example.com/b"},
+
+		// percent-encoding
+		{name: "success_unreserved_decoded", link: "http://example.com/%7Euser/%41%2d%5F", expectedResult: "http://example.com/~user/A-_"},
+		{name: "success_hex_uppercased", link: "http://example.com/a%2fb%3a", expectedResult: "http://example.com/a%2Fb%3A"},
+		{name: "success_reserved_kept_encoded", link: "http://example.com/a%20b", expectedResult: "http://example.com/a%20b"},
+		{name: "success_space_encoded", link: "http://example.com/a b", expectedResult: "http://example.com/a%20b"},
+		{name: "success_unicode_path_encoded", link: "http://example.com/café", expectedResult: "http://example.com/caf%C3%A9"},
+		{name: "success_query_encoding", link: "http://example.com/?q=%7e%2f", expectedResult: "http://example.com/?q=~%2F"},
+
+		// fragments
+		{name: "success_fragment_stripped", link: "http://example.com/b#x", expectedResult: "http://example.com/b"},
+		{name: "success_empty_fragment_stripped", link: "http://example.com/b#", expectedResult: "http://example.com/b"},
+		{name: "success_fragment_after_query_stripped", link: "http://example.com/b?a=1#x", expectedResult: "http://example.com/b?a=1"},
+
+		// internationalised hosts
+		{name: "success_idn", link: "http://bücher.example/a", expectedResult: "http://xn--bcher-kva.example/a"},
+		{name: "success_idn_uppercase", link: "http://BÜCHER.example/a", expectedResult: "http://xn--bcher-kva.example/a"},
+		{name: "success_punycode_kept", link: "http://xn--bcher-kva.example/a", expectedResult: "http://xn--bcher-kva.example/a"},
+		{name: "success_underscore_host_kept", link: "http://my_host.example/a", expectedResult: "http://my_host.example/a"},
+
+		// everything at once
+		{name: "success_combined", link: "HTTP://Example.com:80/a/../b/%7e#x", expectedResult: "http://example.com/b/~"},
+
+		// trailing slashes
+		{name: "success_slash_kept", link: "http://example.com/a/", trailingSlash: TrailingSlashKeep, expectedResult: "http://example.com/a/"},
+		{name: "success_no_slash_kept", link: "http://example.com/a", trailingSlash: TrailingSlashKeep, expectedResult: "http://example.com/a"},
+		{name: "success_slash_added", link: "http://example.com/a", trailingSlash: TrailingSlashAdd, expectedResult: "http://example.com/a/"},
+		{name: "success_slash_not_added_to_file", link: "http://example.com/a.html", trailingSlash: TrailingSlashAdd, expectedResult: "http://example.com/a.html"},
+		{name: "success_slash_not_doubled", link: "http://example.com/a/", trailingSlash: TrailingSlashAdd, expectedResult: "http://example.com/a/"},
+		{name: "success_slash_added_before_query", link: "http://example.com/a?b=c", trailingSlash: TrailingSlashAdd, expectedResult: "http://example.com/a/?b=c"},
+		{name: "success_slash_removed", link: "http://example.com/a/", trailingSlash: TrailingSlashRemove, expectedResult: "http://example.com/a"},
+		{name: "success_root_slash_kept", link: "http://example.com/", trailingSlash: TrailingSlashRemove, expectedResult: "http://example.com/"},
+		{name: "success_root_slash_kept_empty_path", link: "http://example.com", trailingSlash: TrailingSlashRemove, expectedResult: "http://example.com/"},
+
+		// others
+		{name: "success_relative", link: "/a/./b/../c#x", expectedResult: "/a/c"},
+		{name: "success_opaque", link: "MAILTO:someone@example.com", expectedResult: "mailto:someone@example.com"},
+		{name: "success_userinfo_kept", link: "http://user@Example.com/", expectedResult: "http://user@example.com/"},
+		{name: "fail_malformed", link: "http://example.com/\x10", errorExpected: true},
+		{name: "fail_bad_idn", link: "http://bücher-.example/", errorExpected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withConfig(t, func(conf *config.Config) {
+				conf.TrailingSlash = test.trailingSlash
+				if conf.TrailingSlash == "" {
+					conf.TrailingSlash = TrailingSlashKeep
+				}
+			})
+
+			normalized, e := NormalizeURL(test.link)
+
+			if test.errorExpected {
+				if e == nil {
+					t.Errorf("missing expected error, got [%s]", normalized)
+				}
+				return
+			}
+			if e != nil {
+				t.Fatalf("unexpected error - %s", e)
+			}
+			if normalized != test.expectedResult {
+				t.Errorf("unexpected result.\n- received: %s\n- expected %s", normalized, test.expectedResult)
+			}
+
+			// normalizing is idempotent
+			if again, _ := NormalizeURL(normalized); again != normalized {
+				t.Errorf("normalizing twice changed the url.\n- received: %s\n- expected %s", again, normalized)
+			}
+		})
+	}
+}
+
+func TestNewPageNormalizesURL(t *testing.T) {
+	equivalent := []string{
+		"HTTP://Example.com:80/a/../b",
+		"http://example.com/b",
+		"http://example.com/b#x",
+	}
+
+	expected := NewPage(equivalent[0], "b", 0, nil)
+	for _, link := range equivalent[1:] {
+		if page := NewPage(link, "b", 0, nil); page.URL != expected.URL || page.URLHash != expected.URLHash {
+			t.Errorf("equivalent urls hashed differently.\n- received: %s\n- expected %s", page.URL, expected.URL)
+		}
+	}
+}
+
+func TestApplyQueryPolicy(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.QueryPolicy = config.QueryPolicy{Mode: QueryDeny, Params: []string{"utm_*", "sessionid"}}
+		conf.DomainQueryPolicies = map[string]config.QueryPolicy{
+			"keep.com":         {Mode: QueryKeep},
+			"drop.com":         {Mode: QueryDrop},
+			"shop.com":         {Mode: QueryAllow, Params: []string{"page", "q"}},
+			"special.shop.com": {Mode: QueryKeep},
+		}
+	})
+
+	tests := []struct {
+		name           string
+		link           string
+		expectedResult string
+	}{
+		{name: "success_no_query", link: "https://a.com/b", expectedResult: "https://a.com/b"},
+		{name: "success_deny_tracking", link: "https://a.com/b?utm_source=x&page=2&utm_medium=y", expectedResult: "https://a.com/b?page=2"},
+		{name: "success_deny_case_insensitive", link: "https://a.com/b?SessionID=1&page=2", expectedResult: "https://a.com/b?page=2"},
+		{name: "success_deny_all_params", link: "https://a.com/b?sessionid=1", expectedResult: "https://a.com/b"},
+		{name: "success_sorted", link: "https://a.com/b?z=1&a=2&m=3", expectedResult: "https://a.com/b?a=2&m=3&z=1"},
+		{name: "success_sorted_repeated", link: "https://a.com/b?a=2&b=1&a=1", expectedResult: "https://a.com/b?a=1&a=2&b=1"},
+		{name: "success_sorted_by_unescaped_name", link: "https://a.com/b?%62=1&a=2", expectedResult: "https://a.com/b?a=2&%62=1"},
+		{name: "success_empty_params_dropped", link: "https://a.com/b?&a=1&&", expectedResult: "https://a.com/b?a=1"},
+		{name: "success_keep", link: "https://keep.com/b?utm_source=x&b=1", expectedResult: "https://keep.com/b?b=1&utm_source=x"},
+		{name: "success_drop", link: "https://drop.com/b?page=2", expectedResult: "https://drop.com/b"},
+		{name: "success_drop_subdomain", link: "https://www.drop.com/b?page=2", expectedResult: "https://www.drop.com/b"},
+		{name: "success_not_subdomain", link: "https://notdrop.com/b?page=2", expectedResult: "https://notdrop.com/b?page=2"},
+		{name: "success_allow", link: "https://shop.com/list?sort=asc&page=2&q=shoes", expectedResult: "https://shop.com/list?page=2&q=shoes"},
+		{name: "success_allow_none", link: "https://shop.com/list?sort=asc", expectedResult: "https://shop.com/list"},
+		{name: "success_most_specific_domain", link: "https://special.shop.com/list?sort=asc", expectedResult: "https://special.shop.com/list?sort=asc"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			link := ApplyQueryPolicy(test.link)
+
+			if link != test.expectedResult {
+				t.Errorf("unexpected result.\n- received: %s\n- expected %s", link, test.expectedResult)
+			}
+		})
+	}
+}
+
+func TestNewPageAppliesQueryPolicy(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.QueryPolicy = config.QueryPolicy{Mode: QueryDeny, Params: []string{"utm_*"}}
+	})
+
+	first := NewPage("https://a.com/list?page=2&sort=asc&utm_source=x", "list", 0, nil)
+	second := NewPage("https://a.com/list?sort=asc&page=2#top", "list", 0, nil)
+	other := NewPage("https://a.com/list?page=3&sort=asc", "list", 0, nil)
+
+	if first.URLHash != second.URLHash {
+		t.Errorf("equivalent urls hashed differently.\n- received: %s\n- expected %s", second.URL, first.URL)
+	}
+	if first.URLHash == other.URLHash {
+		t.Errorf("different pages hashed the same - %s", other.URL)
+	}
+}
+
+// TestFixLinkFormRFC3986 checks relative links resolve as in the examples of RFC 3986 section 5.4
+func TestFixLinkFormRFC3986(t *testing.T) {
+	base := "http://a/b/c/d;p?q"
+
+	tests := []struct {
+		link           string
+		expectedResult string
+	}{
+		// normal examples, section 5.4.1
+		{link: "g:h", expectedResult: "g:h"},
+		{link: "g", expectedResult: "http://a/b/c/g"},
+		{link: "./g", expectedResult: "http://a/b/c/g"},
+		{link: "g/", expectedResult: "http://a/b/c/g/"},
+		{link: "/g", expectedResult: "http://a/g"},
+		{link: "//g", expectedResult: "http://g"},
+		{link: "?y", expectedResult: "http://a/b/c/d;p?y"},
+		{link: "g?y", expectedResult: "http://a/b/c/g?y"},
+		{link: "#s", expectedResult: "http://a/b/c/d;p?q#s"},
+		{link: "g#s", expectedResult: "http://a/b/c/g#s"},
+		{link: "g?y#s", expectedResult: "http://a/b/c/g?y#s"},
+		{link: ";x", expectedResult: "http://a/b/c/;x"},
+		{link: "g;x", expectedResult: "http://a/b/c/g;x"},
+		{link: "g;x?y#s", expectedResult: "http://a/b/c/g;x?y#s"},
+		{link: "", expectedResult: "http://a/b/c/d;p?q"},
+		{link: ".", expectedResult: "http://a/b/c/"},
+		{link: "./", expectedResult: "http://a/b/c/"},
+		{link: "..", expectedResult: "http://a/b/"},
+		{link: "../", expectedResult: "http://a/b/"},
+		{link: "../g", expectedResult: "http://a/b/g"},
+		{link: "../..", expectedResult: "http://a/"},
+		{link: "../../", expectedResult: "http://a/"},
+		{link: "../../g", expectedResult: "http://a/g"},
+
+		// abnormal examples, section 5.4.2
+		{link: "../../../g", expectedResult: "http://a/g"},
+		{link: "../../../../g", expectedResult: "http://a/g"},
+		{link: "/./g", expectedResult: "http://a/g"},
+		{link: "/../g", expectedResult: "http://a/g"},
+		{link: "g.", expectedResult: "http://a/b/c/g."},
+		{link: ".g", expectedResult: "http://a/b/c/.g"},
+		{link: "g..", expectedResult: "http://a/b/c/g.."},
+		{link: "..g", expectedResult: "http://a/b/c/..g"},
+		{link: "./../g", expectedResult: "http://a/b/g"},
+		{link: "./g/.", expectedResult: "http://a/b/c/g/"},
+		{link: "g/./h", expectedResult: "http://a/b/c/g/h"},
+		{link: "g/../h", expectedResult: "http://a/b/c/h"},
+		{link: "g;x=1/./y", expectedResult: "http://a/b/c/g;x=1/y"},
+		{link: "g;x=1/../y", expectedResult: "http://a/b/c/y"},
+		{link: "g?y/./x", expectedResult: "http://a/b/c/g?y/./x"},
+		{link: "g?y/../x", expectedResult: "http://a/b/c/g?y/../x"},
+		{link: "g#s/./x", expectedResult: "http://a/b/c/g#s/./x"},
+		{link: "g#s/../x", expectedResult: "http://a/b/c/g#s/../x"},
+		{link: "http:g", expectedResult: "http:g"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.link, func(t *testing.T) {
+			link, e := FixLinkForm(base, test.link)
+
+			if e != nil {
+				t.Fatalf("unexpected error - %s", e)
+			}
+			if link != test.expectedResult {
+				t.Errorf("unexpected result.\n- received: %s\n- expected %s", link, test.expectedResult)
+			}
+		})
+	}
+}
+
+func TestFixLinkFormRelative(t *testing.T) {
+	tests := []struct {
+		name           string
+		parentURL      string
+		link           string
+		expectedResult string
+	}{
+		{name: "success_parent_dir", parentURL: "https://example.com/docs/guide/index.html", link: "../img/a.html", expectedResult: "https://example.com/docs/img/a.html"},
+		{name: "success_sibling", parentURL: "https://example.com/docs/index.html", link: "sub/page.html", expectedResult: "https://example.com/docs/sub/page.html"},
+		{name: "success_sibling_of_dir", parentURL: "https://example.com/docs/", link: "page.html", expectedResult: "https://example.com/docs/page.html"},
+		{name: "success_protocol_relative", parentURL: "https://example.com/docs/", link: "//cdn.example.com/x", expectedResult: "https://cdn.example.com/x"},
+		{name: "success_root_relative", parentURL: "https://example.com/docs/index.html", link: "/about", expectedResult: "https://example.com/about"},
+		{name: "success_query_only", parentURL: "https://example.com/list?page=1", link: "?page=2", expectedResult: "https://example.com/list?page=2"},
+		{name: "success_mailto_kept", parentURL: "https://example.com/", link: "mailto:someone@example.com", expectedResult: "mailto:someone@example.com"},
+		{name: "success_space_encoded", parentURL: "https://example.com/docs/", link: "my page.html", expectedResult: "https://example.com/docs/my%20page.html"},
+		{name: "success_absolute_space_kept", parentURL: "https://example.com/", link: "https://example.com/a b", expectedResult: "https://example.com/a b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			link, e := FixLinkForm(test.parentURL, test.link)
+
+			if e != nil {
+				t.Fatalf("unexpected error - %s", e)
+			}
+			if link != test.expectedResult {
+				t.Errorf("unexpected result.\n- received: %s\n- expected %s", link, test.expectedResult)
+			}
+		})
+	}
+}
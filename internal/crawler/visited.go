@@ -0,0 +1,340 @@
+package crawler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	logger "webcrawler/logger"
+)
+
+// visited stores that can be configured
+const (
+	VisitedExact = "exact"
+	VisitedBloom = "bloom"
+)
+
+// bloomInitialCapacity is the number of keys the first filter of a scalable bloom filter is sized
+// for - later filters grow by bloomGrowth times, and tighten their false positive rate by bloomTightening
+const (
+	bloomInitialCapacity = 1 << 16
+	bloomGrowth          = 2
+	bloomTightening      = 0.5
+)
+
+// VisitedStore records the hashes of the links crawled, or content seen, so far
+type VisitedStore interface {
+	// Add records a key, keeping the value already recorded if there is one
+	Add(key string, val int)
+
+	// KeyExists checks whether a key has been recorded
+	KeyExists(key string) bool
+
+	// Keys returns a snapshot of the recorded keys
+	Keys() []string
+
+	// Len returns the number of recorded keys
+	Len() int
+}
+
+// NewVisitedStore creates an in-memory visited store of the given kind - an exact map, or a scalable bloom
+// filter with the given false positive rate, which may wrongly report a key as recorded but is far lighter
+func NewVisitedStore(kind string, falsePositiveRate float64) (VisitedStore, error) {
+	switch kind {
+	case VisitedExact, "":
+		return NewConcurrentMap(), nil
+	case VisitedBloom:
+		return NewScalableBloomFilter(bloomInitialCapacity, falsePositiveRate)
+	}
+	return nil, fmt.Errorf("unknown visited store [%s]", kind)
+}
+
+// newVisitedStore creates the configured visited store, falling back to an exact map if it can't be created
+func newVisitedStore(kind string, falsePositiveRate float64) VisitedStore {
+	store, e := NewVisitedStore(kind, falsePositiveRate)
+	if e != nil {
+		logger.Errorf("could not create visited store, using an exact map instead - %s", e)
+		return NewConcurrentMap()
+	}
+	return store
+}
+
+// LogVisitedStats logs how many links have been visited and how much content seen, along with how full
+// the bloom filters recording them are, if that's what they're recorded in
+func (c *CrawlSession) LogVisitedStats() {
+	for _, store := range []struct {
+		name  string
+		store VisitedStore
+	}{
+		{name: "visited urls", store: c.VisitedURLs},
+		{name: "seen content", store: c.SeenContent},
+	} {
+		if filter, ok := store.store.(*ScalableBloomFilter); ok {
+			logger.Infof("%s bloom filter - %s", store.name, filter.Stats())
+		} else {
+			logger.Infof("%s - [%d] keys", store.name, store.store.Len())
+		}
+	}
+}
+
+// ScalableBloomFilter is a VisitedStore that keeps only a few bits per key. It's a series of bloom filters,
+// each larger and stricter than the last, with a new one added whenever the newest fills up - so however
+// many keys are added, the chance of a key wrongly being reported as recorded stays within the configured rate.
+// Its keys can't be listed, so Keys always returns nil
+type ScalableBloomFilter struct {
+	mutex             sync.Mutex
+	filters           []*bloomFilter
+	falsePositiveRate float64
+	count             int
+}
+
+// NewScalableBloomFilter creates a scalable bloom filter whose first filter holds the given number of keys
+func NewScalableBloomFilter(initialCapacity int, falsePositiveRate float64) (*ScalableBloomFilter, error) {
+	if initialCapacity < 1 {
+		return nil, fmt.Errorf("bloom filter capacity must be at least 1, got [%d]", initialCapacity)
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, fmt.Errorf("bloom filter false positive rate must be between 0 and 1, got [%g]", falsePositiveRate)
+	}
+
+	// the filters' rates form a geometric series, which sums to the configured rate
+	first := newBloomFilter(initialCapacity, falsePositiveRate*(1-bloomTightening))
+	return &ScalableBloomFilter{
+		filters:           []*bloomFilter{first},
+		falsePositiveRate: falsePositiveRate}, nil
+}
+
+// Add records a key, adding a new filter first if the newest is full
+func (b *ScalableBloomFilter) Add(key string, _ int) {
+	h1, h2 := bloomHashes(key)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.contains(h1, h2) {
+		return
+	}
+
+	newest := b.filters[len(b.filters)-1]
+	if newest.count >= newest.capacity {
+		newest = newBloomFilter(newest.capacity*bloomGrowth, newest.falsePositiveRate*bloomTightening)
+		b.filters = append(b.filters, newest)
+	}
+	newest.add(h1, h2)
+	b.count++
+}
+
+// KeyExists checks whether a key has been recorded - it may wrongly say so, but never wrongly says not
+func (b *ScalableBloomFilter) KeyExists(key string) bool {
+	h1, h2 := bloomHashes(key)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.contains(h1, h2)
+}
+
+func (b *ScalableBloomFilter) contains(h1, h2 uint64) bool {
+	for _, filter := range b.filters {
+		if filter.contains(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// Keys returns nil, as a bloom filter doesn't keep its keys
+func (b *ScalableBloomFilter) Keys() []string {
+	return nil
+}
+
+// Len returns the number of keys recorded, less any that were wrongly taken as already recorded when added
+func (b *ScalableBloomFilter) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.count
+}
+
+// BloomStats describes how full a scalable bloom filter is
+type BloomStats struct {
+	Keys    int
+	Filters int
+	Bits    uint64
+	BitsSet uint64
+
+	// the share of all bits that are set
+	FillRatio float64
+
+	// the chance of a key not yet recorded being reported as recorded, given the bits set so far
+	EstimatedFalsePositiveRate float64
+}
+
+// String formats the stats for logging
+func (s BloomStats) String() string {
+	return fmt.Sprintf("[%d] keys, [%d] filters, [%d/%d] bits set (%.1f%%), estimated false positive rate [%.6f]",
+		s.Keys, s.Filters, s.BitsSet, s.Bits, s.FillRatio*100, s.EstimatedFalsePositiveRate)
+}
+
+// Stats returns how full the filter is
+func (b *ScalableBloomFilter) Stats() BloomStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	stats := BloomStats{Keys: b.count, Filters: len(b.filters)}
+	missed := 1.0
+	for _, filter := range b.filters {
+		stats.Bits += filter.bits
+		stats.BitsSet += filter.bitsSet
+
+		// a false positive in any filter is a false positive overall
+		missed *= 1 - math.Pow(float64(filter.bitsSet)/float64(filter.bits), float64(filter.hashes))
+	}
+	stats.FillRatio = float64(stats.BitsSet) / float64(stats.Bits)
+	stats.EstimatedFalsePositiveRate = 1 - missed
+	return stats
+}
+
+// MarshalBinary encodes the filter, so it can be saved in a checkpoint in place of its keys
+func (b *ScalableBloomFilter) MarshalBinary() ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	data := binary.AppendUvarint(nil, math.Float64bits(b.falsePositiveRate))
+	data = binary.AppendUvarint(data, uint64(b.count))
+	data = binary.AppendUvarint(data, uint64(len(b.filters)))
+	for _, filter := range b.filters {
+		data = binary.AppendUvarint(data, uint64(filter.capacity))
+		data = binary.AppendUvarint(data, math.Float64bits(filter.falsePositiveRate))
+		data = binary.AppendUvarint(data, uint64(filter.count))
+		data = binary.AppendUvarint(data, filter.bitsSet)
+		data = binary.AppendUvarint(data, uint64(len(filter.words)))
+		for _, word := range filter.words {
+			data = binary.LittleEndian.AppendUint64(data, word)
+		}
+	}
+	return data, nil
+}
+
+// UnmarshalBinary replaces the filter with one encoded by MarshalBinary
+func (b *ScalableBloomFilter) UnmarshalBinary(data []byte) error {
+	errBadFilter := errors.New("bad bloom filter encoding")
+
+	next := func() uint64 {
+		value, n := binary.Uvarint(data)
+		if n <= 0 {
+			data = nil
+			return 0
+		}
+		data = data[n:]
+		return value
+	}
+
+	// a filter is added each time the last doubles in size, so there are never many
+	falsePositiveRate := math.Float64frombits(next())
+	count := int(next())
+	filterCount := next()
+	if filterCount == 0 || filterCount > 64 {
+		return errBadFilter
+	}
+
+	filters := make([]*bloomFilter, filterCount)
+	for i := range filters {
+		capacity := int(next())
+		rate := math.Float64frombits(next())
+		filterKeys := int(next())
+		bitsSet := next()
+		words := next()
+		if capacity < 1 || !(rate > 0 && rate < 1) || data == nil {
+			return errBadFilter
+		}
+
+		// checked before the filter is allocated, so a bad encoding can't ask for more memory than it holds
+		bits, _ := bloomSize(capacity, rate)
+		if words != bits/64 || uint64(len(data)) < 8*words {
+			return errBadFilter
+		}
+
+		filter := newBloomFilter(capacity, rate)
+		filter.count = filterKeys
+		filter.bitsSet = bitsSet
+		for j := range filter.words {
+			filter.words[j] = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		}
+		filters[i] = filter
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.filters = filters
+	b.falsePositiveRate = falsePositiveRate
+	b.count = count
+	return nil
+}
+
+// bloomFilter is a single, fixed size bloom filter
+type bloomFilter struct {
+	words             []uint64
+	bits              uint64
+	hashes            int
+	capacity          int
+	falsePositiveRate float64
+	count             int
+	bitsSet           uint64
+}
+
+// newBloomFilter sizes a bloom filter to hold the given number of keys within the given false positive rate
+func newBloomFilter(capacity int, falsePositiveRate float64) *bloomFilter {
+	bits, hashes := bloomSize(capacity, falsePositiveRate)
+	return &bloomFilter{
+		words:             make([]uint64, bits/64),
+		bits:              bits,
+		hashes:            hashes,
+		capacity:          capacity,
+		falsePositiveRate: falsePositiveRate}
+}
+
+// bloomSize returns the optimal number of bits, rounded up to whole words, and hashes for a bloom filter
+func bloomSize(capacity int, falsePositiveRate float64) (bits uint64, hashes int) {
+	bits = uint64(math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	bits = (bits + 63) / 64 * 64
+	hashes = int(math.Ceil(-math.Log2(falsePositiveRate)))
+	return
+}
+
+func (f *bloomFilter) add(h1, h2 uint64) {
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % f.bits
+		mask := uint64(1) << (bit % 64)
+		if f.words[bit/64]&mask == 0 {
+			f.words[bit/64] |= mask
+			f.bitsSet++
+		}
+	}
+	f.count++
+}
+
+func (f *bloomFilter) contains(h1, h2 uint64) bool {
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % f.bits
+		if f.words[bit/64]&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHashes returns the two hashes of a key that each of its bits in a filter are derived from
+func bloomHashes(key string) (h1, h2 uint64) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(key))
+	h1 = hasher.Sum64()
+
+	hasher = fnv.New64()
+	hasher.Write([]byte(key))
+
+	// a zero step would set the same bit for every hash
+	h2 = hasher.Sum64() | 1
+	return
+}
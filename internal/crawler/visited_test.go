@@ -0,0 +1,165 @@
+package crawler
+
+import (
+	"fmt"
+	"testing"
+	config "webcrawler/config/crawler"
+	"webcrawler/internal/util"
+)
+
+func TestNewVisitedStore(t *testing.T) {
+	tests := []struct {
+		name          string
+		kind          string
+		rate          float64
+		expectedType  string
+		errorExpected bool
+	}{
+		{name: "exact", kind: VisitedExact, expectedType: "*crawler.ConcurrentMap"},
+		{name: "default", kind: "", expectedType: "*crawler.ConcurrentMap"},
+		{name: "bloom", kind: VisitedBloom, rate: 0.01, expectedType: "*crawler.ScalableBloomFilter"},
+		{name: "fail_bloom_bad_rate", kind: VisitedBloom, rate: 1, errorExpected: true},
+		{name: "fail_unknown", kind: "random", errorExpected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, e := NewVisitedStore(test.kind, test.rate)
+			if test.errorExpected {
+				if e == nil {
+					t.Error("missing expected error")
+				}
+				return
+			}
+			if e != nil {
+				t.Fatalf("unexpected error - %s", e)
+			}
+			if received := fmt.Sprintf("%T", store); received != test.expectedType {
+				t.Errorf("store type mismatch.\n- received: %s\n- expected: %s", received, test.expectedType)
+			}
+		})
+	}
+}
+
+func TestScalableBloomFilter(t *testing.T) {
+	rate := 0.01
+	filter, e := NewScalableBloomFilter(1000, rate)
+	if e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+
+	// enough keys for the filter to have to grow a few times
+	keyCount := 20000
+	for i := 0; i < keyCount; i++ {
+		filter.Add(util.Hash(fmt.Sprintf("https://a.com/%d", i)), 1)
+	}
+	filter.Add(util.Hash("https://a.com/0"), 1)
+
+	// keys wrongly taken as already recorded aren't counted
+	if filter.Len() > keyCount || float64(filter.Len()) < float64(keyCount)*(1-rate) {
+		t.Errorf("key count mismatch.\n- received: %d\n- expected: about %d", filter.Len(), keyCount)
+	}
+
+	for i := 0; i < keyCount; i++ {
+		if !filter.KeyExists(util.Hash(fmt.Sprintf("https://a.com/%d", i))) {
+			t.Fatalf("false negative for key [%d]", i)
+		}
+	}
+
+	falsePositives := 0
+	trials := 100000
+	for i := 0; i < trials; i++ {
+		if filter.KeyExists(util.Hash(fmt.Sprintf("https://b.com/%d", i))) {
+			falsePositives++
+		}
+	}
+	if measured := float64(falsePositives) / float64(trials); measured > rate {
+		t.Errorf("false positive rate too high.\n- received: %f\n- expected: at most %f", measured, rate)
+	}
+
+	stats := filter.Stats()
+	t.Log(stats)
+	if stats.Keys != filter.Len() || stats.Filters < 2 {
+		t.Errorf("filter didn't scale.\n- received: %+v", stats)
+	}
+	if stats.FillRatio <= 0 || stats.FillRatio >= 1 {
+		t.Errorf("fill ratio out of range - %f", stats.FillRatio)
+	}
+	if stats.EstimatedFalsePositiveRate <= 0 || stats.EstimatedFalsePositiveRate > rate {
+		t.Errorf("estimated false positive rate out of range.\n- received: %f\n- expected: at most %f", stats.EstimatedFalsePositiveRate, rate)
+	}
+
+	if filter.Keys() != nil {
+		t.Error("bloom filter unexpectedly listed keys")
+	}
+}
+
+func TestScalableBloomFilterEncoding(t *testing.T) {
+	filter, _ := NewScalableBloomFilter(100, 0.01)
+	for i := 0; i < 500; i++ {
+		filter.Add(fmt.Sprintf("key %d", i), 1)
+	}
+
+	data, e := filter.MarshalBinary()
+	if e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+
+	decoded, _ := NewScalableBloomFilter(1, 0.5)
+	if e = decoded.UnmarshalBinary(data); e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+	if decoded.Stats() != filter.Stats() {
+		t.Errorf("stats mismatch.\n- received: %+v\n- expected: %+v", decoded.Stats(), filter.Stats())
+	}
+	for i := 0; i < 500; i++ {
+		if !decoded.KeyExists(fmt.Sprintf("key %d", i)) {
+			t.Fatalf("decoded filter missing key [%d]", i)
+		}
+	}
+
+	if e = decoded.UnmarshalBinary(data[:len(data)-1]); e == nil {
+		t.Error("expected error for truncated encoding")
+	}
+	if e = decoded.UnmarshalBinary([]byte{1, 2, 3}); e == nil {
+		t.Error("expected error for bad encoding")
+	}
+}
+
+func TestSnapshotRestoreBloom(t *testing.T) {
+	withConfig(t, func(conf *config.Config) {
+		conf.VisitedStore = VisitedBloom
+		conf.BloomFalsePositiveRate = 0.001
+	})
+
+	session := NewCrawlSession(3)
+	seed := NewPage("https://www.google.com", "google", 0, nil)
+	seed.Processed = true
+	session.Seeds = []*Page{seed}
+	session.VisitedURLs.Add(seed.URLHash, 1)
+	session.SeenContent.Add(util.Hash("<html>google</html>"), 1)
+
+	checkpoint := session.Snapshot()
+	if checkpoint.VisitedFilter == nil || checkpoint.SeenFilter == nil {
+		t.Fatal("bloom filters not saved in checkpoint")
+	}
+
+	restored := NewCrawlSession(3)
+	if _, e := restored.Restore(checkpoint); e != nil {
+		t.Fatalf("unexpected error - %s", e)
+	}
+	if !restored.VisitedURLs.KeyExists(seed.URLHash) {
+		t.Error("visited url not restored")
+	}
+	if !restored.SeenContent.KeyExists(util.Hash("<html>google</html>")) {
+		t.Error("seen content not restored")
+	}
+
+	// an exact session can't take in a bloom filter
+	withConfig(t, func(conf *config.Config) {
+		conf.VisitedStore = VisitedExact
+	})
+	if _, e := NewCrawlSession(3).Restore(checkpoint); e == nil {
+		t.Error("expected error restoring bloom filter into exact store")
+	}
+}
@@ -2,14 +2,35 @@ package util
 
 import (
 	"bytes"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	logger "webcrawler/logger"
 )
 
-func GetLogBuffer() *bytes.Buffer {
-	var buffer bytes.Buffer
+// LogBuffer holds the log output of a test, which the crawl's goroutines may still be writing to as it's read
+type LogBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *LogBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func GetLogBuffer() *LogBuffer {
+	var buffer LogBuffer
 	log.SetOutput(&buffer)
 	return &buffer
 }
@@ -39,3 +60,65 @@ func GetTestServer(
 			}
 		}))
 }
+
+// TestPage is a canned response served at a single path of a test site
+type TestPage struct {
+	StatusCode int
+	Body       string
+	Headers    map[string]string
+}
+
+// GetTestSite returns a test server serving the given pages by path, and a 404 for any other path.
+// Pages may be added to the map after the server is created, e.g. once its URL is known
+func GetTestSite(pages map[string]TestPage) *httptest.Server {
+
+	return httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			logger.Infof("test site hit - path [%s]", r.URL.RequestURI())
+
+			page, ok := pages[r.URL.RequestURI()]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			for key, val := range page.Headers {
+				w.Header().Add(key, val)
+			}
+
+			statusCode := page.StatusCode
+			if statusCode == 0 {
+				statusCode = http.StatusOK
+			}
+			w.WriteHeader(statusCode)
+			w.Write([]byte(page.Body))
+		}))
+}
+
+// GetFixtureSite returns a test server hosting a site of pageCount html pages, arranged as a tree where
+// "/" is page 0 and page i links to pages fanout*i+1 to fanout*i+fanout, each found at "/page/<n>"
+func GetFixtureSite(pageCount int, fanout int) *httptest.Server {
+	pages := make(map[string]TestPage, pageCount)
+
+	for i := 0; i < pageCount; i++ {
+		var body strings.Builder
+		body.WriteString("<!doctype html><html><body>")
+		fmt.Fprintf(&body, "<h1>Page %d</h1>", i)
+
+		for child := fanout*i + 1; child <= fanout*i+fanout && child < pageCount; child++ {
+			fmt.Fprintf(&body, `<a href="/page/%d">Page %d</a>`, child, child)
+		}
+		body.WriteString("</body></html>")
+
+		path := fmt.Sprintf("/page/%d", i)
+		if i == 0 {
+			path = "/"
+		}
+		pages[path] = TestPage{
+			Body:    body.String(),
+			Headers: map[string]string{"Content-Type": "text/html"}}
+	}
+
+	return GetTestSite(pages)
+}